@@ -1,9 +1,17 @@
 package agents
 
 import (
-	"html/template"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/kurusugawa-computer/ace/templates"
 )
 
 type Agent struct {
@@ -11,7 +19,7 @@ type Agent struct {
 
 	Name           string
 	Description    string
-	Instruction    string
+	Instruction    *template.Template
 	PromptTemplate *template.Template
 	InputSchema    *jsonschema.Schema
 	OutputSchema   *jsonschema.Schema
@@ -19,9 +27,160 @@ type Agent struct {
 	Sandbox        string
 	Config         CodexConfig
 	SubAgents      []*SubAgent
+
+	OutputChecks       []*OutputCheck // 出力にたいする検査
+	OutputCheckRetries int            // 検査を満たさなかったときに回答をやり直させる回数
+
+	Retry          *RetryPolicy     // 一時的な失敗を再試行する方針。nil なら再試行しない
+	FallbackModels []*FallbackModel // 主のモデルが失敗したときに順に試す代替のモデル
+	RepairModel    string           // 回答を出力形式に合わせて整形するモデル。空なら DefaultRepairModel
+
+	Workdir       *template.Template // 呼び出し元の作業ディレクトリからの相対パスで表した作業ディレクトリ。nil なら呼び出し元と同じ
+	WritableRoots []string           // 作業ディレクトリのほかに書き込めるディレクトリ（作業ディレクトリからの相対パス）
+	ReadablePaths []string           // 読み込めるファイルやディレクトリ（作業ディレクトリからの相対パス）。ファイルの入力を制限し、Codex には指示するのみ。空なら制限しない
 }
 
 type SubAgent struct {
 	Name       string
 	TimeoutSec int
 }
+
+// エージェントを実行するときの状況
+// instruction のテンプレートから .ace として参照できる
+type RuntimeContext struct {
+	Workdir     string    // 作業ディレクトリの絶対パス
+	Date        time.Time // 実行日時
+	Agent       string    // 実行するエージェントの名前
+	ParentAgent string    // サブエージェントとして実行された場合、呼び出し元のエージェントの名前
+	Depth       int       // サブエージェントの呼び出しの深さ（トップレベルのエージェントは 0）
+}
+
+// テンプレートに与える値を返す
+func (runtime *RuntimeContext) templateData() map[string]any {
+	return map[string]any{
+		"workdir":      runtime.Workdir,
+		"date":         runtime.Date.Format(time.DateOnly),
+		"agent":        runtime.Agent,
+		"parent_agent": runtime.ParentAgent,
+		"depth":        runtime.Depth,
+	}
+}
+
+// instruction を input と実行時の状況で展開した指示を返す
+// input に ace という名前の値がなければ、実行時の状況を .ace として参照できる。
+func (agent *Agent) RenderInstruction(input map[string]any, runtime *RuntimeContext) (string, error) {
+	data := maps.Clone(input)
+	if data == nil {
+		data = map[string]any{}
+	}
+	if _, ok := data["ace"]; !ok {
+		data["ace"] = runtime.templateData()
+	}
+	return templates.Execute(agent.Instruction, runtime.Workdir, data)
+}
+
+// prompt_template を input で展開したプロンプトを返す
+// readFile で読み込めるのは workdir 以下のファイルに限る
+func (agent *Agent) RenderPrompt(workdir string, input map[string]any) (string, error) {
+	return templates.Execute(agent.PromptTemplate, workdir, input)
+}
+
+// workdir を input で展開し、エージェントの作業ディレクトリの絶対パスを返す
+// 作業ディレクトリは、呼び出し元の作業ディレクトリ parentWorkdir 以下に限る
+func (agent *Agent) ResolveWorkdir(parentWorkdir string, input map[string]any) (string, error) {
+	if agent.Workdir == nil {
+		return parentWorkdir, nil
+	}
+
+	workdir, err := templates.Execute(agent.Workdir, parentWorkdir, input)
+	if err != nil {
+		return "", err
+	}
+	workdir = strings.TrimSpace(workdir)
+	if workdir == "" {
+		return parentWorkdir, nil
+	}
+
+	absPath, ok := resolvePath(parentWorkdir, workdir)
+	if !ok {
+		return "", errors.New("workdir is outside of the working directory: " + workdir)
+	}
+	return absPath, nil
+}
+
+// ファイルをエージェントが読み込めるか判定する
+// readable_paths が定義されていなければ、どのファイルも読み込める
+// シンボリックリンクは、リンク先が readable_paths 以下にあるかで判定する
+func (agent *Agent) IsReadable(workdir string, absPath string) bool {
+	if len(agent.ReadablePaths) == 0 {
+		return true
+	}
+	for _, readablePath := range agent.ReadablePaths {
+		readableAbsPath := readablePath
+		if !filepath.IsAbs(readableAbsPath) {
+			readableAbsPath = filepath.Join(workdir, readablePath)
+		}
+		if _, ok := resolvePath(readableAbsPath, absPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dir からの相対パスもしくは絶対パスを絶対パスにする
+// パスが dir 以下でないか、シンボリックリンクで dir の外を指していれば false を返す
+func resolvePath(dir string, path string) (string, bool) {
+	absPath := path
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(dir, path)
+	}
+	absPath = filepath.Clean(absPath)
+
+	if !isUnder(dir, absPath) {
+		return "", false
+	}
+
+	// シンボリックリンクで dir の外を指していないか
+	realDir, err := evalSymlinks(dir)
+	if err != nil {
+		return "", false
+	}
+	realPath, err := evalSymlinks(absPath)
+	if err != nil {
+		return "", false
+	}
+	if !isUnder(realDir, realPath) {
+		return "", false
+	}
+
+	return absPath, true
+}
+
+// シンボリックリンクをたどった絶対パスを返す
+// パスが存在しなければ、存在する親ディレクトリまでのシンボリックリンクをたどり、残りのパスをつなげる
+// リンク先が存在しないシンボリックリンクはエラーとする
+func evalSymlinks(path string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return realPath, err
+	}
+	if _, lstatErr := os.Lstat(path); lstatErr == nil {
+		return "", err
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	realParent, err := evalSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(realParent, filepath.Base(path)), nil
+}
+
+// path が dir 以下にあるか判定する
+func isUnder(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
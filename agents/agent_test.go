@@ -0,0 +1,267 @@
+package agents
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/kurusugawa-computer/ace/templates"
+)
+
+func TestRenderInstruction(t *testing.T) {
+	runtime := &RuntimeContext{
+		Workdir:     "/work",
+		Date:        time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC),
+		Agent:       "translate",
+		ParentAgent: "root",
+		Depth:       1,
+	}
+
+	tests := []struct {
+		name        string
+		instruction string
+		input       map[string]any
+		runtime     *RuntimeContext
+		want        string
+	}{
+		{
+			name:        "runtime context",
+			instruction: "{{.ace.agent}} {{.ace.date}} {{.ace.workdir}} {{.ace.parent_agent}} {{.ace.depth}}",
+			runtime:     runtime,
+			want:        "translate 2025-01-02 /work root 1",
+		},
+		{
+			name:        "input",
+			instruction: "{{.lang}} に翻訳すること",
+			input:       map[string]any{"lang": "英語"},
+			runtime:     runtime,
+			want:        "英語 に翻訳すること",
+		},
+		{
+			name:        "top level agent",
+			instruction: "{{if .ace.parent_agent}}sub{{else}}top{{end}} {{.ace.depth}}",
+			runtime:     &RuntimeContext{Workdir: "/work", Agent: "root"},
+			want:        "top 0",
+		},
+		{
+			name:        "input named ace",
+			instruction: "{{.ace}}",
+			input:       map[string]any{"ace": "input"},
+			runtime:     runtime,
+			want:        "input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := Build("", &Config{Name: "translate", Instruction: tt.instruction})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			inputLen := len(tt.input)
+			got, err := agent.RenderInstruction(tt.input, tt.runtime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderInstruction() = %q, want %q", got, tt.want)
+			}
+			if len(tt.input) != inputLen {
+				t.Error("RenderInstruction() should not modify the input")
+			}
+		})
+	}
+}
+
+func TestRunRuntimeContext(t *testing.T) {
+	codexPath := fakeCodex(t, fakeCodexScript)
+	t.Setenv("FAKE_CODEX_ANSWER", `{"ok":"y"}`)
+	agent, err := Build(codexPath, &Config{
+		Name:         "translate",
+		Instruction:  "agent={{.ace.agent}} parent={{.ace.parent_agent}} depth={{.ace.depth}} workdir={{.ace.workdir}}",
+		OutputSchema: map[string]*jsonschema.Schema{"ok": {Type: "string"}},
+		SubAgents:    []*SubAgentConfig{{Name: "sub"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// サブエージェントの MCP Server には、呼び出し元としてこのエージェントの状況を渡す
+	var parent *RuntimeContext
+	workdir := t.TempDir()
+	_, err = agent.Run(workdir, map[string]any{}, &RunConfig{
+		ParentAgent: "root",
+		Depth:       2,
+		SubagentMCPServerConfig: func(subAgent *SubAgent, runtime *RuntimeContext) (map[string]any, error) {
+			parent = runtime
+			return map[string]any{"command": "ace"}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workdirAbsPath, err := filepath.Abs(workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil || parent.Agent != "translate" || parent.ParentAgent != "root" || parent.Depth != 2 || parent.Workdir != workdirAbsPath {
+		t.Errorf("runtime context for sub agents = %+v", parent)
+	}
+
+	args, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "args.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `developer_instructions="agent=translate parent=root depth=2 workdir=` + workdirAbsPath
+	if !strings.Contains(string(args), want) {
+		t.Errorf("args do not contain the rendered instruction %s:\n%s", want, args)
+	}
+}
+
+func TestRenderPromptTemplates(t *testing.T) {
+	workdir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workdir, "a.txt"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(workdir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := templates.ParseSet(map[string]string{
+		"file":      `[{{ readFile .path }}]`,
+		"recursive": `{{ include "recursive" . }}`,
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		prompt  string
+		input   map[string]any
+		want    string
+		errText string
+	}{
+		{name: "partial", prompt: `{{ template "file" . }}`, input: map[string]any{"path": "a.txt"}, want: "[content]"},
+		{name: "include", prompt: `{{ include "file" . | printf "%q" }}`, input: map[string]any{"path": "a.txt"}, want: `"[content]"`},
+		{name: "symlink outside", prompt: `{{ template "file" . }}`, input: map[string]any{"path": "link.txt"}, errText: "outside of the working directory"},
+		{name: "parent", prompt: `{{ template "file" . }}`, input: map[string]any{"path": "../a.txt"}, errText: "outside of the working directory"},
+		{name: "recursive include", prompt: `{{ include "recursive" . }}`, errText: "exceeded maximum nesting depth"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := Build("", &Config{Name: "test", PromptTemplate: tt.prompt, Templates: set})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := agent.RenderPrompt(workdir, tt.input)
+			if tt.errText != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("RenderPrompt() error = %v, want %q", err, tt.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderPrompt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWorkdir(t *testing.T) {
+	parent := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(parent, "out"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(parent, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(parent, "out"), filepath.Join(parent, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		workdir string
+		want    string
+		wantErr bool
+	}{
+		{name: "existing", workdir: "out", want: filepath.Join(parent, "out")},
+		{name: "not created yet", workdir: "out/{{.id}}", want: filepath.Join(parent, "out", "1")},
+		{name: "symlink inside", workdir: "inside/{{.id}}", want: filepath.Join(parent, "inside", "1")},
+		{name: "parent", workdir: "../{{.id}}", wantErr: true},
+		{name: "parent after clean", workdir: "out/../../x", wantErr: true},
+		{name: "symlink escape", workdir: "escape/{{.id}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := Build("", &Config{Name: "test", Workdir: tt.workdir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := agent.ResolveWorkdir(parent, map[string]any{"id": "1"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveWorkdir() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveWorkdir() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsReadable(t *testing.T) {
+	workdir := t.TempDir()
+	for _, path := range []string{"inputs/a.txt", "secret.txt"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(workdir, path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(workdir, path), []byte(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(workdir, "secret.txt"), filepath.Join(workdir, "inputs", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(workdir, "inputs", "a.txt"), filepath.Join(workdir, "inputs", "alias.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	agent := &Agent{Name: "test", ReadablePaths: []string{"inputs"}}
+
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{name: "inside", path: "inputs/a.txt", want: true},
+		{name: "symlink inside", path: "inputs/alias.txt", want: true},
+		{name: "outside", path: "secret.txt", want: false},
+		{name: "parent", path: "inputs/../secret.txt", want: false},
+		{name: "symlink escape", path: "inputs/link.txt", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agent.IsReadable(workdir, filepath.Join(workdir, tt.path)); got != tt.want {
+				t.Errorf("IsReadable(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
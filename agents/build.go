@@ -1,9 +1,11 @@
 package agents
 
 import (
-	"html/template"
+	"fmt"
+	"text/template"
 
 	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/kurusugawa-computer/ace/templates"
 )
 
 func Build(executablePath string, config *Config) (*Agent, error) {
@@ -30,12 +32,49 @@ func Build(executablePath string, config *Config) (*Agent, error) {
 		outputSchema.Properties[name] = schema
 	}
 
+	// 共通のテンプレート
+	templateSet := config.Templates
+	if templateSet == nil {
+		var err error
+		templateSet, err = templates.ParseSet(nil, config.MissingKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 指示のテンプレートのビルド
+	// 入力と実行時の状況に応じて変わるので、展開は実行時に行う
+	instructionTemplate, err := templates.ParseWith(templateSet, "instruction", config.Instruction)
+	if err != nil {
+		return nil, err
+	}
+
 	// プロンプトテンプレートのビルド
-	promptTemplate, err := template.New("prompt").Parse(config.PromptTemplate)
+	promptTemplate, err := templates.ParseWith(templateSet, "prompt", config.PromptTemplate)
 	if err != nil {
 		return nil, err
 	}
 
+	// 作業ディレクトリのテンプレートのビルド
+	// 入力に応じて変わるので、展開は実行時に行う
+	var workdirTemplate *template.Template
+	if config.Workdir != "" {
+		workdirTemplate, err = templates.ParseWith(templateSet, "workdir", config.Workdir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 出力の検査
+	outputChecks := make([]*OutputCheck, 0, len(config.OutputChecks))
+	for _, checkConfig := range config.OutputChecks {
+		outputCheck, err := buildOutputCheck(templateSet, checkConfig)
+		if err != nil {
+			return nil, fmt.Errorf("output check %s: %w", checkConfig.Name, err)
+		}
+		outputChecks = append(outputChecks, outputCheck)
+	}
+
 	// サブエージェント
 	subAgents := make([]*SubAgent, 0, len(config.SubAgents))
 	for _, subAgentConfig := range config.SubAgents {
@@ -48,16 +87,24 @@ func Build(executablePath string, config *Config) (*Agent, error) {
 	// 構築したエージェントを返す
 	agent := &Agent{
 		codexExecutablePath: executablePath,
-		Name:           config.Name,
-		Description:    config.Description,
-		Instruction:    config.Instruction,
-		PromptTemplate: promptTemplate,
-		InputSchema:    inputSchema,
-		OutputSchema:   outputSchema,
-		ApprovalPolicy: config.ApprovalPolicy,
-		Sandbox:        config.Sandbox,
-		Config:         config.Config,
-		SubAgents:      subAgents,
+		Name:                config.Name,
+		Description:         config.Description,
+		Instruction:         instructionTemplate,
+		PromptTemplate:      promptTemplate,
+		InputSchema:         inputSchema,
+		OutputSchema:        outputSchema,
+		ApprovalPolicy:      config.ApprovalPolicy,
+		Sandbox:             config.Sandbox,
+		Config:              config.Config,
+		SubAgents:           subAgents,
+		OutputChecks:        outputChecks,
+		OutputCheckRetries:  config.OutputCheckRetries,
+		Retry:               config.Retry,
+		FallbackModels:      config.FallbackModels,
+		RepairModel:         config.RepairModel,
+		Workdir:             workdirTemplate,
+		WritableRoots:       config.WritableRoots,
+		ReadablePaths:       config.ReadablePaths,
 	}
 	return agent, nil
 }
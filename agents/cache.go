@@ -0,0 +1,157 @@
+package agents
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 回答のキャッシュ
+type Cache interface {
+	// ttl より新しいキャッシュを返す
+	// キャッシュがなければ nil を返す
+	Load(key string, ttl time.Duration) (*Result, error)
+
+	// 回答をキャッシュする
+	Save(key string, result *Result) error
+}
+
+// キャッシュした回答を返してよいか判定する
+// キャッシュした回答を返してもファイルの変更は再現されないので、ファイルを変更しうるサンドボックスでは、
+// 作業ディレクトリの内容をキーに含める場合（CacheWorkdir）を除いてキャッシュを利用しない。
+func (agent *Agent) cacheable(config *RunConfig) bool {
+	return agent.Sandbox == "" || agent.Sandbox == "read-only" || config.CacheWorkdir
+}
+
+// キャッシュのキーを返す
+// エージェントの定義、展開した指示とプロンプト、実行時の Codex の Config を含めるので、いずれかが変われば別のキーとなる。
+func (agent *Agent) cacheKey(instruction string, prompt string, codexConfig CodexConfig, config *RunConfig, workdirAbsPath string) (string, error) {
+	checks := make([]string, 0, len(agent.OutputChecks))
+	for _, check := range agent.OutputChecks {
+		checks = append(checks, check.Expr)
+	}
+
+	provider := ""
+	if config.Provider != nil {
+		provider = config.Provider.Name + " " + config.Provider.BaseURL
+	}
+
+	workdirHash := ""
+	if config.CacheWorkdir {
+		var err error
+		workdirHash, err = hashDir(workdirAbsPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// 添付する画像は、パスと内容のハッシュを含める
+	images := make([]string, 0, len(config.Images))
+	for _, image := range config.Images {
+		imageHash, err := hashFile(image)
+		if err != nil {
+			return "", err
+		}
+		images = append(images, image+" "+imageHash)
+	}
+
+	b, err := json.Marshal(map[string]any{
+		"name":                 agent.Name,
+		"instruction":          instruction,
+		"prompt":               prompt,
+		"input_schema":         agent.InputSchema,
+		"output_schema":        agent.OutputSchema,
+		"approval_policy":      agent.ApprovalPolicy,
+		"sandbox":              agent.Sandbox,
+		"config":               codexConfig,
+		"output_checks":        checks,
+		"output_check_retries": agent.OutputCheckRetries,
+		"provider":             provider,
+		"workdir":              workdirAbsPath,
+		"workdir_hash":         workdirHash,
+		"repair_model":         agent.repairModel(),
+		"images":               images,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// 隔離した作業ディレクトリのパスを、元の作業ディレクトリのパスに置き換える
+	// 指示やプロンプト、Codex の Config（writable_roots やサブエージェントの引数）に含まれるパスも置き換わる
+	if config.IsolatedWorkdir != "" {
+		isolated, err := json.Marshal(config.IsolatedWorkdir)
+		if err != nil {
+			return "", err
+		}
+		original, err := json.Marshal(config.OriginalWorkdir)
+		if err != nil {
+			return "", err
+		}
+		b = bytes.ReplaceAll(b, bytes.Trim(isolated, `"`), bytes.Trim(original, `"`))
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ファイルの内容のハッシュを返す
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ディレクトリ以下のファイルのパスと内容のハッシュを返す
+// .git と .ace ディレクトリは含めない
+func hashDir(dir string) (string, error) {
+	hash := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && (entry.Name() == ".git" || entry.Name() == ".ace") {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hash.Write([]byte(filepath.ToSlash(rel) + "\x00"))
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		hash.Write([]byte{0})
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,136 @@
+package agents
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	agent := &Agent{Name: "root"}
+	key := func(instruction string, prompt string, codexConfig CodexConfig, config *RunConfig, workdir string) string {
+		t.Helper()
+		key, err := agent.cacheKey(instruction, prompt, codexConfig, config, workdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	original := key("in /work", "prompt", CodexConfig{"sandbox_workspace_write.writable_roots": []string{"/work/out"}}, &RunConfig{}, "/work")
+
+	tests := []struct {
+		name        string
+		instruction string
+		prompt      string
+		codexConfig CodexConfig
+		config      *RunConfig
+		workdir     string
+		wantSame    bool
+	}{
+		{
+			name:        "same",
+			instruction: "in /work",
+			prompt:      "prompt",
+			codexConfig: CodexConfig{"sandbox_workspace_write.writable_roots": []string{"/work/out"}},
+			config:      &RunConfig{},
+			workdir:     "/work",
+			wantSame:    true,
+		},
+		{
+			name:        "isolated",
+			instruction: "in /tmp/ace-workspace-1",
+			prompt:      "prompt",
+			codexConfig: CodexConfig{"sandbox_workspace_write.writable_roots": []string{"/tmp/ace-workspace-1/out"}},
+			config:      &RunConfig{IsolatedWorkdir: "/tmp/ace-workspace-1", OriginalWorkdir: "/work"},
+			workdir:     "/tmp/ace-workspace-1",
+			wantSame:    true,
+		},
+		{
+			name:        "another isolated",
+			instruction: "in /tmp/ace-workspace-2",
+			prompt:      "prompt",
+			codexConfig: CodexConfig{"sandbox_workspace_write.writable_roots": []string{"/tmp/ace-workspace-2/out"}},
+			config:      &RunConfig{IsolatedWorkdir: "/tmp/ace-workspace-2", OriginalWorkdir: "/work"},
+			workdir:     "/tmp/ace-workspace-2",
+			wantSame:    true,
+		},
+		{
+			name:        "other workdir",
+			instruction: "in /other",
+			prompt:      "prompt",
+			codexConfig: CodexConfig{"sandbox_workspace_write.writable_roots": []string{"/other/out"}},
+			config:      &RunConfig{},
+			workdir:     "/other",
+			wantSame:    false,
+		},
+		{
+			name:        "other prompt",
+			instruction: "in /work",
+			prompt:      "other",
+			codexConfig: CodexConfig{"sandbox_workspace_write.writable_roots": []string{"/work/out"}},
+			config:      &RunConfig{},
+			workdir:     "/work",
+			wantSame:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := key(tt.instruction, tt.prompt, tt.codexConfig, tt.config, tt.workdir)
+			if (got == original) != tt.wantSame {
+				t.Errorf("cacheKey() same = %v, want %v", got == original, tt.wantSame)
+			}
+		})
+	}
+}
+
+// 保存した回答を覚えておく Cache
+type memoryCache map[string]*Result
+
+func (cache memoryCache) Load(key string, ttl time.Duration) (*Result, error) {
+	return cache[key], nil
+}
+
+func (cache memoryCache) Save(key string, result *Result) error {
+	cache[key] = result
+	return nil
+}
+
+func TestRunCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		sandbox      string
+		cacheWorkdir bool
+		wantCached   bool
+	}{
+		{name: "read-only", sandbox: "read-only", wantCached: true},
+		{name: "workspace-write", sandbox: "workspace-write", wantCached: false},
+		{name: "workspace-write with workdir", sandbox: "workspace-write", cacheWorkdir: true, wantCached: true},
+		{name: "danger-full-access", sandbox: "danger-full-access", wantCached: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codexPath := fakeCodex(t, fakeCodexScript)
+			t.Setenv("FAKE_CODEX_ANSWER", `{"ok":"y"}`)
+			agent := testAgent(t, codexPath)
+			agent.Sandbox = tt.sandbox
+
+			workdir := t.TempDir()
+			cache := memoryCache{}
+			config := &RunConfig{Cache: cache, CacheTTL: time.Hour, CacheWorkdir: tt.cacheWorkdir}
+			for i := range 2 {
+				result, err := agent.Run(workdir, map[string]any{"question": "q"}, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if wantCached := tt.wantCached && i == 1; result.Cached != wantCached {
+					t.Errorf("run %d: Cached = %v, want %v", i, result.Cached, wantCached)
+				}
+			}
+			if saved := len(cache) > 0; saved != tt.wantCached {
+				t.Errorf("saved = %v, want %v", saved, tt.wantCached)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package agents
+
+import (
+	"text/template"
+
+	"github.com/kurusugawa-computer/ace/templates"
+)
+
+// 出力の検査を満たさなかったときに、エージェントに回答をやり直させる回数のデフォルト値
+const DefaultOutputCheckRetries = 2
+
+// 出力にたいする検査
+type OutputCheck struct {
+	Name    string
+	Expr    string
+	Message string // 検査を満たさなかったときに、エージェントに伝える理由
+	test    *template.Template
+}
+
+// 出力の検査の結果
+type CheckResult struct {
+	Name    string
+	Passed  bool
+	Message string // 検査を満たさなかった理由
+}
+
+// 検査の式を構築する
+// 式は text/template のパイプラインであり、出力をデータとして評価した値が空でなければ検査を満たす。
+func buildOutputCheck(templateSet *template.Template, config *OutputCheckConfig) (*OutputCheck, error) {
+	test, err := templates.ParseWith(templateSet, "check:"+config.Name, "{{if "+config.Expr+"}}true{{end}}")
+	if err != nil {
+		return nil, err
+	}
+
+	message := config.Message
+	if message == "" {
+		message = "output does not satisfy: " + config.Expr
+	}
+
+	return &OutputCheck{
+		Name:    config.Name,
+		Expr:    config.Expr,
+		Message: message,
+		test:    test,
+	}, nil
+}
+
+// 出力を検査する
+// fileExists や readFile で参照できるのは workdir 以下のファイルに限る
+func (agent *Agent) CheckOutput(workdir string, output any) []*CheckResult {
+	results := make([]*CheckResult, 0, len(agent.OutputChecks))
+	for _, check := range agent.OutputChecks {
+		result := &CheckResult{Name: check.Name, Passed: true}
+		text, err := templates.Execute(check.test, workdir, output)
+		switch {
+		case err != nil:
+			result.Passed = false
+			result.Message = err.Error()
+		case text != "true":
+			result.Passed = false
+			result.Message = check.Message
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// すべての検査を満たしたか判定する
+func checksPassed(results []*CheckResult) bool {
+	for _, result := range results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
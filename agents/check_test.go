@@ -0,0 +1,107 @@
+package agents
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+func TestCheckOutput(t *testing.T) {
+	workdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workdir, "report.md"), []byte("# report"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := map[string]any{"result": "ok", "items": []any{}, "count": float64(3), "path": "report.md"}
+
+	tests := []struct {
+		name        string
+		expr        string
+		message     string
+		wantPassed  bool
+		wantMessage string
+	}{
+		{name: "true", expr: "gt (len .result) 0", wantPassed: true},
+		{name: "file exists", expr: "fileExists .path", wantPassed: true},
+		{name: "false", expr: "gt (len .items) 0", message: "items must not be empty", wantMessage: "items must not be empty"},
+		{name: "false with default message", expr: "eq .result \"ng\"", wantMessage: "output does not satisfy: eq .result \"ng\""},
+		{name: "missing file", expr: "fileExists \"missing.md\"", wantMessage: "output does not satisfy: fileExists \"missing.md\""},
+		{name: "error", expr: "len .count", wantMessage: "len of type float64"},
+		{name: "outside of workdir", expr: "readFile \"../secret.txt\"", wantMessage: "outside of the working directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := Build("", &Config{Name: "test", OutputChecks: []*OutputCheckConfig{{Name: "check", Expr: tt.expr, Message: tt.message}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := agent.CheckOutput(workdir, output)
+			if len(results) != 1 {
+				t.Fatalf("CheckOutput() = %d results, want 1", len(results))
+			}
+			if results[0].Passed != tt.wantPassed {
+				t.Errorf("Passed = %v, want %v (message: %s)", results[0].Passed, tt.wantPassed, results[0].Message)
+			}
+			if !strings.Contains(results[0].Message, tt.wantMessage) || tt.wantMessage == "" && results[0].Message != "" {
+				t.Errorf("Message = %q, want %q", results[0].Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestBuildOutputCheckSyntaxError(t *testing.T) {
+	if _, err := Build("", &Config{Name: "test", OutputChecks: []*OutputCheckConfig{{Name: "check", Expr: "gt (len .result"}}}); err == nil {
+		t.Error("Build() should fail with an invalid check expression")
+	}
+}
+
+func TestRunOutputChecks(t *testing.T) {
+	tests := []struct {
+		name       string
+		answer     string
+		wantPassed bool
+		wantRetry  bool
+	}{
+		{name: "passed", answer: `{"ok":"y"}`, wantPassed: true},
+		{name: "failed after retries", answer: `{"ok":""}`, wantRetry: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codexPath := fakeCodex(t, fakeCodexScript)
+			t.Setenv("FAKE_CODEX_ANSWER", tt.answer)
+			agent, err := Build(codexPath, &Config{
+				Name:               "test",
+				PromptTemplate:     "質問",
+				OutputSchema:       map[string]*jsonschema.Schema{"ok": {Type: "string"}},
+				OutputChecks:       []*OutputCheckConfig{{Name: "not_empty", Expr: "gt (len .ok) 0", Message: "ok must not be empty"}},
+				OutputCheckRetries: 1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := agent.Run(t.TempDir(), map[string]any{}, &RunConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if passed := checksPassed(result.Checks); passed != tt.wantPassed {
+				t.Errorf("checks passed = %v, want %v", passed, tt.wantPassed)
+			}
+
+			// やり直させた場合は、前回の回答と検査を満たさなかった理由をプロンプトで伝える
+			prompt, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "prompt.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			retried := strings.Contains(string(prompt), "- not_empty: ok must not be empty")
+			if retried != tt.wantRetry {
+				t.Errorf("retried = %v, want %v\n%s", retried, tt.wantRetry, prompt)
+			}
+		})
+	}
+}
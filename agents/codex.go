@@ -0,0 +1,299 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Codex に画像入力として添付できる画像ファイルの拡張子
+// これ以外の画像は、パスをプロンプトで示して画像を閲覧するツールで確認させる
+var codexImageExts = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// Codex のエラーメッセージとして残す標準エラー出力の最大のバイト数
+const maxCodexStderrBytes = 4 * 1024
+
+// Codex のプロセスを止めてから、出力が閉じられるのを待つ時間
+const codexWaitDelay = 5 * time.Second
+
+// codex exec に与える実行の内容
+type codexRequest struct {
+	Prompt         string
+	Instructions   string // developer_instructions として与える指示
+	Cwd            string
+	ApprovalPolicy string
+	Sandbox        string
+	Config         CodexConfig
+	Images         []string // 画像入力として添付する画像ファイルの絶対パス
+	Env            []string // Codex のプロセスにのみ与える環境変数（KEY=VALUE）
+}
+
+// Codex の実行に失敗したことを表すエラー
+type CodexError struct {
+	ExitCode int    // codex exec の終了コード
+	Message  string // Codex が報告したエラーメッセージ。なければ標準エラー出力の末尾
+}
+
+func (err *CodexError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("codex exited with status %d", err.ExitCode)
+	}
+	return fmt.Sprintf("codex exited with status %d: %s", err.ExitCode, err.Message)
+}
+
+// codex exec でエージェントを実行し、最後の回答を返す
+// codex-go の Invoke は画像入力を添付できず、Codex のプロセスだけに環境変数を与えることもできないので、Codex CLI を直接実行する。
+// ctx が終わると Codex のプロセスを止める
+func (agent *Agent) execCodex(ctx context.Context, request *codexRequest, config *RunConfig) (string, error) {
+	// 最後の回答を書き出すファイル
+	lastMessageFile, err := os.CreateTemp("", "ace-codex-*.txt")
+	if err != nil {
+		return "", err
+	}
+	lastMessageFile.Close()
+	defer os.Remove(lastMessageFile.Name())
+
+	args, err := codexExecArgs(request, lastMessageFile.Name())
+	if err != nil {
+		return "", err
+	}
+
+	executablePath := agent.codexExecutablePath
+	if executablePath == "" {
+		executablePath = "codex"
+	}
+	cmd := exec.CommandContext(ctx, executablePath, args...)
+	cmd.Dir = request.Cwd
+	cmd.Stdin = strings.NewReader(request.Prompt)
+	cmd.Env = slices.Concat(os.Environ(), request.Env)
+
+	// ログの出力
+	// 標準エラー出力の末尾はエラーメッセージに用いる
+	stderr := &tailBuffer{max: maxCodexStderrBytes}
+	cmd.Stderr = stderr
+	if config.LogWriter != nil && config.LogLevel != "off" {
+		cmd.Env = append(cmd.Env, "RUST_LOG="+config.LogLevel)
+		cmd.Stderr = io.MultiWriter(stderr, config.LogWriter)
+	}
+
+	// イベントから Codex が報告したエラーを取得
+	message := ""
+	cmd.Stdout = &lineWriter{f: func(line []byte) {
+		if eventMessage := codexErrorMessage(line); eventMessage != "" {
+			message = eventMessage
+		}
+	}}
+
+	// 止めた Codex の子プロセスが出力を閉じなくても、待ち続けないようにする
+	cmd.WaitDelay = codexWaitDelay
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("codex: %w", ctx.Err())
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", err
+		}
+		if message == "" {
+			message = strings.TrimSpace(stderr.String())
+		}
+		return "", &CodexError{ExitCode: exitErr.ExitCode(), Message: message}
+	}
+
+	answer, err := os.ReadFile(lastMessageFile.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(answer), nil
+}
+
+// codex exec の引数を返す
+// プロンプトは標準入力から与える
+func codexExecArgs(request *codexRequest, lastMessagePath string) ([]string, error) {
+	args := []string{"exec", "--json", "--skip-git-repo-check", "--output-last-message", lastMessagePath}
+	if request.Cwd != "" {
+		args = append(args, "--cd", request.Cwd)
+	}
+	if request.Sandbox != "" {
+		args = append(args, "--sandbox", request.Sandbox)
+	}
+
+	// Config はキーの順に並べて、実行ごとに引数が変わらないようにする
+	config := maps.Clone(request.Config)
+	if config == nil {
+		config = CodexConfig{}
+	}
+	if request.ApprovalPolicy != "" {
+		config["approval_policy"] = request.ApprovalPolicy
+	}
+	if request.Instructions != "" {
+		config["developer_instructions"] = request.Instructions
+	}
+	for _, key := range slices.Sorted(maps.Keys(config)) {
+		if config[key] == nil {
+			continue
+		}
+		value, err := tomlValue(config[key])
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", key, err)
+		}
+		args = append(args, "--config", key+"="+value)
+	}
+
+	for _, image := range request.Images {
+		args = append(args, "--image", image)
+	}
+
+	return append(args, "-"), nil
+}
+
+// codex exec --json のイベントから、Codex が報告したエラーメッセージを返す
+// エラーのイベントでなければ空文字列を返す
+func codexErrorMessage(line []byte) string {
+	var event struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(line, &event); err != nil {
+		return ""
+	}
+
+	switch event.Type {
+	case "error":
+		return event.Message
+	case "turn.failed":
+		return event.Error.Message
+	default:
+		return ""
+	}
+}
+
+// Codex に画像入力として添付できる画像ファイルか判定する
+func isCodexImage(path string) bool {
+	return slices.Contains(codexImageExts, strings.ToLower(filepath.Ext(path)))
+}
+
+// Config の値を、codex exec の --config に与える TOML の値の表記にする
+func tomlValue(value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		// JSON の文字列のエスケープは、TOML の基本文字列でもそのまま使える
+		b := &bytes.Buffer{}
+		enc := json.NewEncoder(b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(value); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float32, float64:
+		f := reflect.ValueOf(value).Float()
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+
+	case reflect.Slice, reflect.Array:
+		items := make([]string, 0, v.Len())
+		for i := range v.Len() {
+			item, err := tomlValue(v.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return "", fmt.Errorf("unsupported map key type: %s", v.Type().Key())
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		slices.Sort(keys)
+
+		fields := make([]string, 0, len(keys))
+		for _, key := range keys {
+			item := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).Interface()
+			if item == nil {
+				continue
+			}
+			quotedKey, err := tomlValue(key)
+			if err != nil {
+				return "", err
+			}
+			itemValue, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, quotedKey+" = "+itemValue)
+		}
+		return "{" + strings.Join(fields, ", ") + "}", nil
+
+	default:
+		return "", fmt.Errorf("unsupported value type: %T", value)
+	}
+}
+
+// 書き込まれた内容を行ごとに f に与える Writer
+// 改行で終わらない最後の行は与えない
+type lineWriter struct {
+	f       func(line []byte)
+	pending []byte
+}
+
+func (writer *lineWriter) Write(p []byte) (int, error) {
+	writer.pending = append(writer.pending, p...)
+	for {
+		i := bytes.IndexByte(writer.pending, '\n')
+		if i < 0 {
+			break
+		}
+		writer.f(writer.pending[:i])
+		writer.pending = writer.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// 書き込まれた内容の末尾を max バイトまで残す Writer
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (buffer *tailBuffer) Write(p []byte) (int, error) {
+	buffer.buf = append(buffer.buf, p...)
+	if len(buffer.buf) > buffer.max {
+		buffer.buf = buffer.buf[len(buffer.buf)-buffer.max:]
+	}
+	return len(p), nil
+}
+
+func (buffer *tailBuffer) String() string {
+	return strings.ToValidUTF8(string(buffer.buf), "")
+}
@@ -0,0 +1,184 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 引数とプロンプトを書き出し、プロンプトに応じて振る舞う codex の代わりのスクリプト
+// FAKE_CODEX_ANSWER が設定されていれば、それを回答とする
+const fakeCodexScript = `#!/bin/sh
+dir=$(dirname "$0")
+printf '%s\n' "$@" > "$dir/args.txt"
+env > "$dir/env.txt"
+out=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --output-last-message) out="$2"; shift ;;
+  esac
+  shift
+done
+prompt=$(cat)
+printf '%s' "$prompt" > "$dir/prompt.txt"
+if [ -n "$FAKE_CODEX_ANSWER" ]; then
+  printf '%s' "$FAKE_CODEX_ANSWER" > "$out"
+  exit 0
+fi
+case "$prompt" in
+  fail-event)
+    echo '{"type":"turn.started"}'
+    echo '{"type":"turn.failed","error":{"message":"unexpected status 429 Too Many Requests"}}'
+    exit 1 ;;
+  fail-stderr)
+    echo "boom" >&2
+    exit 2 ;;
+  sleep)
+    exec sleep 10 ;;
+esac
+echo '{"type":"turn.completed"}'
+printf 'answer: %s' "$prompt" > "$out"
+`
+
+// codex の代わりのスクリプトを作り、そのパスを返す
+func fakeCodex(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not available")
+	}
+	path := filepath.Join(t.TempDir(), "codex")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTOMLValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{name: "string", value: "a \"b\"\n<c>", want: `"a \"b\"\n<c>"`},
+		{name: "bool", value: true, want: "true"},
+		{name: "int", value: 0, want: "0"},
+		{name: "uint64", value: uint64(30), want: "30"},
+		{name: "float", value: 1.5, want: "1.5"},
+		{name: "strings", value: []string{"a", "b"}, want: `["a", "b"]`},
+		{name: "empty", value: []any{}, want: "[]"},
+		{name: "table", value: map[string]any{"b": 1, "a": "x", "c": nil}, want: `{"a" = "x", "b" = 1}`},
+		{name: "nested", value: map[string]any{"env": map[string]string{"KEY": "v"}, "args": []any{"-y"}}, want: `{"args" = ["-y"], "env" = {"KEY" = "v"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tomlValue(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("tomlValue() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := tomlValue(struct{}{}); err == nil {
+		t.Error("tomlValue() with a struct should fail")
+	}
+}
+
+func TestCodexExecArgs(t *testing.T) {
+	args, err := codexExecArgs(&codexRequest{
+		Instructions:   "指示",
+		Cwd:            "/work",
+		ApprovalPolicy: "never",
+		Sandbox:        "read-only",
+		Config:         CodexConfig{"model": "gpt-5", "project_doc_max_bytes": 0},
+		Images:         []string{"/work/a.png", "/work/b.jpg"},
+	}, "/tmp/last.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"exec", "--json", "--skip-git-repo-check", "--output-last-message", "/tmp/last.txt",
+		"--cd", "/work",
+		"--sandbox", "read-only",
+		"--config", `approval_policy="never"`,
+		"--config", `developer_instructions="指示"`,
+		"--config", `model="gpt-5"`,
+		"--config", "project_doc_max_bytes=0",
+		"--image", "/work/a.png",
+		"--image", "/work/b.jpg",
+		"-",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("codexExecArgs() = %q, want %q", args, want)
+	}
+}
+
+func TestExecCodex(t *testing.T) {
+	codexPath := fakeCodex(t, fakeCodexScript)
+	agent := &Agent{Name: "test", codexExecutablePath: codexPath}
+
+	tests := []struct {
+		name        string
+		prompt      string
+		timeout     time.Duration
+		want        string
+		wantMessage string // CodexError のメッセージ
+		wantErr     error
+	}{
+		{name: "success", prompt: "hello", want: "answer: hello"},
+		{name: "error event", prompt: "fail-event", wantMessage: "unexpected status 429 Too Many Requests"},
+		{name: "stderr", prompt: "fail-stderr", wantMessage: "boom"},
+		{name: "timeout", prompt: "sleep", timeout: 100 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			got, err := agent.execCodex(ctx, &codexRequest{Prompt: tt.prompt, Cwd: t.TempDir(), Images: []string{"/work/a.png"}}, &RunConfig{})
+			switch {
+			case tt.wantMessage != "":
+				var codexErr *CodexError
+				if !errors.As(err, &codexErr) {
+					t.Fatalf("execCodex() error = %v, want CodexError", err)
+				}
+				if codexErr.Message != tt.wantMessage {
+					t.Errorf("CodexError.Message = %q, want %q", codexErr.Message, tt.wantMessage)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("execCodex() error = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("execCodex() = %q, want %q", got, tt.want)
+				}
+				args, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "args.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(args), "--image\n/work/a.png\n") {
+					t.Errorf("args do not contain the image:\n%s", args)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package agents
 
 import (
 	"encoding/json"
+	"text/template"
 
 	"github.com/google/jsonschema-go/jsonschema"
 )
@@ -17,6 +18,25 @@ type Config struct {
 	Sandbox        string // read-only, workspace-write, danger-full-access
 	Config         CodexConfig
 	SubAgents      []*SubAgentConfig
+	MissingKey     string             // テンプレートで存在しないキーを参照したときの挙動（default, zero, error）
+	Templates      *template.Template // instruction と prompt_template から参照できる共通のテンプレート（任意）
+
+	OutputChecks       []*OutputCheckConfig // 出力にたいする検査
+	OutputCheckRetries int                  // 検査を満たさなかったときに回答をやり直させる回数
+
+	Retry          *RetryPolicy     // 一時的な失敗を再試行する方針（任意）
+	FallbackModels []*FallbackModel // 主のモデルが失敗したときに順に試す代替のモデル（任意）
+	RepairModel    string           // 回答を出力形式に合わせて整形するモデル（任意）
+
+	Workdir       string   // 呼び出し元の作業ディレクトリからの相対パスで表した作業ディレクトリ（任意、入力で展開するテンプレート）
+	WritableRoots []string // 作業ディレクトリのほかに書き込めるディレクトリ（任意、作業ディレクトリからの相対パス）
+	ReadablePaths []string // 読み込めるファイルやディレクトリ（任意、作業ディレクトリからの相対パス）
+}
+
+type OutputCheckConfig struct {
+	Name    string
+	Expr    string // text/template のパイプライン（例: gt (len .result) 0）
+	Message string // 検査を満たさなかったときに、エージェントに伝える理由
 }
 
 type SubAgentConfig struct {
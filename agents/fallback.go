@@ -0,0 +1,33 @@
+package agents
+
+import "fmt"
+
+// 主のモデルが失敗したときに用いる代替のモデル
+type FallbackModel struct {
+	Model         string
+	ModelProvider string // 空文字列の場合は主のモデルと同じプロバイダーを用いる
+}
+
+// 主のモデルと代替のモデルそれぞれの Codex の Config を、試す順に返す
+func (agent *Agent) modelConfigs(codexConfig CodexConfig) []CodexConfig {
+	modelConfigs := []CodexConfig{codexConfig}
+	for _, fallbackModel := range agent.FallbackModels {
+		modelConfig := codexConfig.Clone()
+		modelConfig["model"] = fallbackModel.Model
+		if fallbackModel.ModelProvider != "" {
+			modelConfig["model_provider"] = fallbackModel.ModelProvider
+		}
+		modelConfigs = append(modelConfigs, modelConfig)
+	}
+	return modelConfigs
+}
+
+// Codex の Config に指定されたモデルの名前を返す
+// 指定されていない場合は Codex CLI のデフォルトのモデルを用いるので、空文字列を返す
+func modelName(codexConfig CodexConfig) string {
+	model, ok := codexConfig["model"]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(model)
+}
@@ -0,0 +1,40 @@
+package agents
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModelConfigs(t *testing.T) {
+	base := CodexConfig{
+		"model":          "gpt-5",
+		"model_provider": "openai",
+		"mcp_servers":    map[string]any{"docs": map[string]any{"command": "uvx"}},
+	}
+	agent := &Agent{FallbackModels: []*FallbackModel{
+		{Model: "gpt-5-mini"},
+		{Model: "local-model", ModelProvider: "ollama"},
+	}}
+
+	got := agent.modelConfigs(base)
+
+	want := []CodexConfig{
+		base,
+		{"model": "gpt-5-mini", "model_provider": "openai", "mcp_servers": map[string]any{"docs": map[string]any{"command": "uvx"}}},
+		{"model": "local-model", "model_provider": "ollama", "mcp_servers": map[string]any{"docs": map[string]any{"command": "uvx"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("modelConfigs() = %v, want %v", got, want)
+	}
+
+	// 代替のモデルの Config を変更しても、主のモデルの Config は変わらない
+	got[1]["mcp_servers"].(map[string]any)["docs"].(map[string]any)["command"] = "npx"
+	wantBase := CodexConfig{
+		"model":          "gpt-5",
+		"model_provider": "openai",
+		"mcp_servers":    map[string]any{"docs": map[string]any{"command": "uvx"}},
+	}
+	if !reflect.DeepEqual(base, wantBase) {
+		t.Errorf("base config = %v, want %v", base, wantBase)
+	}
+}
@@ -0,0 +1,108 @@
+package agents
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// モデルプロバイダーの種類
+const (
+	ProviderOpenAI           = "openai"
+	ProviderAzure            = "azure"
+	ProviderOpenAICompatible = "openai-compatible"
+)
+
+// Codex の model_providers に登録するプロバイダーの ID
+const codexModelProviderID = "ace"
+
+// エージェントが利用するモデルプロバイダーと、その資格情報
+type Provider struct {
+	Name       string // openai, azure, openai-compatible
+	APIKey     string
+	BaseURL    string // Azure OpenAI のエンドポイント、もしくは OpenAI 互換 API のベース URL
+	APIVersion string // Azure OpenAI の API バージョン（任意）
+}
+
+// API のベース URL を返す
+// Azure OpenAI はエンドポイントの /openai/v1 以下が OpenAI 互換の API となる。
+func (provider *Provider) baseURL() string {
+	baseURL := strings.TrimSuffix(provider.BaseURL, "/")
+	if provider.Name == ProviderAzure && !strings.HasSuffix(baseURL, "/openai/v1") {
+		baseURL += "/openai/v1"
+	}
+	return baseURL
+}
+
+// Codex に API Key を渡す環境変数の名前を返す
+// プロバイダーごとに API Key が異なるので、API Key のハッシュ値を名前に含める
+func (provider *Provider) apiKeyEnvVar() string {
+	sum := sha256.Sum256([]byte(provider.APIKey))
+	return "ACE_PROVIDER_API_KEY_" + strings.ToUpper(hex.EncodeToString(sum[:4]))
+}
+
+// Codex のプロセスに API Key を渡す環境変数を KEY=VALUE 形式で返す
+// Codex の Config はコマンドライン引数で渡され ps などで見えてしまうため、API Key は Config に含めない。
+// ace のプロセスの環境変数には設定せず、Codex のプロセスにのみ与えて env_key で指定した環境変数から読み込ませる。
+func (provider *Provider) apiKeyEnv() string {
+	return provider.apiKeyEnvVar() + "=" + provider.APIKey
+}
+
+// Codex の config.toml の model_providers.NAME に与える値を返す
+// API Key は apiKeyEnv の環境変数から読み込ませる
+func (provider *Provider) codexConfig() map[string]any {
+	config := map[string]any{
+		"name":     provider.Name,
+		"wire_api": "responses",
+		"env_key":  provider.apiKeyEnvVar(),
+	}
+	if provider.BaseURL != "" {
+		config["base_url"] = provider.baseURL()
+	}
+	if provider.Name == ProviderAzure {
+		config["env_http_headers"] = map[string]string{"api-key": provider.apiKeyEnvVar()}
+		if provider.APIVersion != "" {
+			config["query_params"] = map[string]string{"api-version": provider.APIVersion}
+		}
+	}
+	if provider.Name == ProviderOpenAICompatible {
+		config["wire_api"] = "chat"
+	}
+	return config
+}
+
+// 回答を整形する OpenAI API クライアントのオプションを返す
+func (provider *Provider) clientOptions() []option.RequestOption {
+	options := []option.RequestOption{option.WithAPIKey(provider.APIKey)}
+	if provider.BaseURL != "" {
+		options = append(options, option.WithBaseURL(provider.baseURL()+"/"))
+	}
+	if provider.Name == ProviderAzure {
+		options = append(options, option.WithHeader("api-key", provider.APIKey))
+		if provider.APIVersion != "" {
+			options = append(options, option.WithQuery("api-version", provider.APIVersion))
+		}
+	}
+	return options
+}
+
+// プロバイダーのモデル一覧の API から、利用できるモデルの ID を返す
+// 資格情報が有効かどうかの確認に用いる
+func (provider *Provider) ListModels(ctx context.Context) ([]string, error) {
+	client := openai.NewClient(provider.clientOptions()...)
+	page, err := client.Models.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(page.Data))
+	for _, model := range page.Data {
+		models = append(models, model.ID)
+	}
+
+	return models, nil
+}
@@ -0,0 +1,66 @@
+package agents
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestProviderCodexConfig(t *testing.T) {
+	const apiKey = "test-api-key-0123456789"
+
+	tests := []struct {
+		name     string
+		provider *Provider
+		baseURL  string
+		wireAPI  string
+	}{
+		{
+			name:     "openai",
+			provider: &Provider{Name: ProviderOpenAI, APIKey: apiKey},
+			wireAPI:  "responses",
+		},
+		{
+			name:     "azure",
+			provider: &Provider{Name: ProviderAzure, APIKey: apiKey, BaseURL: "https://example.openai.azure.com/"},
+			baseURL:  "https://example.openai.azure.com/openai/v1",
+			wireAPI:  "responses",
+		},
+		{
+			name:     "openai-compatible",
+			provider: &Provider{Name: ProviderOpenAICompatible, APIKey: apiKey, BaseURL: "http://localhost:8000/v1"},
+			baseURL:  "http://localhost:8000/v1",
+			wireAPI:  "chat",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.provider.codexConfig()
+
+			// API Key はコマンドライン引数に現れないように、Config に含めない
+			b, err := json.Marshal(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(b), apiKey) {
+				t.Errorf("codexConfig() contains the API key: %s", b)
+			}
+
+			envKey, _ := config["env_key"].(string)
+			if envKey == "" {
+				t.Fatalf("codexConfig() has no env_key: %s", b)
+			}
+			if config["wire_api"] != tt.wireAPI {
+				t.Errorf("wire_api = %v, want %s", config["wire_api"], tt.wireAPI)
+			}
+			if baseURL, _ := config["base_url"].(string); baseURL != tt.baseURL {
+				t.Errorf("base_url = %q, want %q", baseURL, tt.baseURL)
+			}
+
+			if got := tt.provider.apiKeyEnv(); got != envKey+"="+apiKey {
+				t.Errorf("apiKeyEnv() = %q, want %s=<API key>", got, envKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// 再試行する失敗の種類
+const (
+	RetryOnRateLimit     = "rate_limit"     // レート制限（HTTP 429）
+	RetryOnServerError   = "server_error"   // サーバーエラー（HTTP 5xx）
+	RetryOnTimeout       = "timeout"        // タイムアウト
+	RetryOnOutputInvalid = "output_invalid" // 回答を出力形式に整形できなかった
+)
+
+// 回答を出力形式に整形できなかったことを表すエラー
+var ErrOutputInvalid = errors.New("invalid format, openai chat completions response")
+
+// 一時的な失敗を再試行する方針
+type RetryPolicy struct {
+	MaxAttempts    int           // 最初の試行を含む試行回数の上限
+	InitialBackoff time.Duration // 最初の再試行までの待ち時間
+	MaxBackoff     time.Duration // 待ち時間の上限
+	Multiplier     float64       // 再試行のたびに待ち時間に掛ける倍率
+	Jitter         float64       // 待ち時間をランダムに増減させる割合（0 から 1）
+	RetryOn        []string      // 再試行する失敗の種類
+	AttemptTimeout time.Duration // 各試行の時間の上限。0 なら制限しない
+}
+
+// 試行回数に応じた待ち時間を返す
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(policy.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= policy.Multiplier
+	}
+	if policy.MaxBackoff > 0 && backoff > float64(policy.MaxBackoff) {
+		backoff = float64(policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 {
+		backoff *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(backoff)
+}
+
+// Codex が報告したエラーメッセージから失敗の種類を判定するパターン
+// Codex CLI はモデルの API のエラーをメッセージとしてのみ返すので、ステータスコードや定型の文言に限って判定する
+var codexErrorPatterns = []struct {
+	class   string
+	pattern *regexp.Regexp
+}{
+	{class: RetryOnRateLimit, pattern: regexp.MustCompile(`(?i)\b429\b|\btoo many requests\b|\brate[ _-]?limit(ed)?\b`)},
+	{class: RetryOnServerError, pattern: regexp.MustCompile(`(?i)\bstatus:? 5\d\d\b|\b5\d\d (internal server error|bad gateway|service unavailable|gateway timeout)\b|\bserver (is )?overloaded\b`)},
+	{class: RetryOnTimeout, pattern: regexp.MustCompile(`(?i)\btimed out\b|\btimeout\b|\bstream disconnected before completion\b`)},
+}
+
+// 判定に用いる Codex のエラーメッセージの最大のバイト数
+const maxClassifiedMessageBytes = 1024
+
+// 失敗の種類を返す
+// 型で判定できるエラーと、Codex の実行の失敗（CodexError）のメッセージのみを分類する
+// 再試行の対象にならない失敗は空文字列を返す
+func classifyError(err error) string {
+	if errors.Is(err, ErrOutputInvalid) {
+		return RetryOnOutputInvalid
+	}
+
+	var codexErr *CodexError
+	if errors.As(err, &codexErr) {
+		message := codexErr.Message
+		if len(message) > maxClassifiedMessageBytes {
+			message = message[:maxClassifiedMessageBytes]
+		}
+		for _, codexErrorPattern := range codexErrorPatterns {
+			if codexErrorPattern.pattern.MatchString(message) {
+				return codexErrorPattern.class
+			}
+		}
+		return ""
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return RetryOnTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return RetryOnTimeout
+	}
+
+	// OpenAI API のエラーはステータスコードで判定する
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == 429:
+			return RetryOnRateLimit
+		case apiErr.StatusCode >= 500:
+			return RetryOnServerError
+		case apiErr.StatusCode == 408:
+			return RetryOnTimeout
+		}
+	}
+
+	return ""
+}
+
+// 方針に従って、一時的な失敗を再試行する
+// 各試行は AttemptTimeout を上限とする ctx で実行する
+// step は失敗した処理の名前であり、各試行の失敗とあわせてログに出力する
+func retry[T any](ctx context.Context, agent *Agent, config *RunConfig, step string, f func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	policy := agent.Retry
+	for attempt := 1; ; attempt++ {
+		value, err := attemptWithTimeout(ctx, policy, f)
+		if err == nil {
+			return value, nil
+		}
+		if ctx.Err() != nil {
+			return zero, err
+		}
+
+		class := classifyError(err)
+		if policy == nil || attempt >= policy.MaxAttempts || class == "" || !slices.Contains(policy.RetryOn, class) {
+			return zero, err
+		}
+
+		backoff := policy.backoff(attempt)
+		agent.logf(config, "%s attempt %d/%d failed (%s): %s; retrying in %s", step, attempt, policy.MaxAttempts, class, err, backoff.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// 方針の AttemptTimeout を上限として f を実行する
+func attemptWithTimeout[T any](ctx context.Context, policy *RetryPolicy, f func(ctx context.Context) (T, error)) (T, error) {
+	if policy == nil || policy.AttemptTimeout <= 0 {
+		return f(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, policy.AttemptTimeout)
+	defer cancel()
+	return f(ctx)
+}
+
+// ログに警告を出力する
+func (agent *Agent) logf(config *RunConfig, format string, args ...any) {
+	switch config.LogLevel {
+	case "warn", "info", "debug", "trace":
+	default:
+		return
+	}
+	if config.LogWriter == nil {
+		return
+	}
+	fmt.Fprintf(config.LogWriter, "[WARN] agent %s: %s\n", agent.Name, fmt.Sprintf(format, args...))
+}
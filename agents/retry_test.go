@@ -0,0 +1,166 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// タイムアウトを表す net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "output invalid", err: fmt.Errorf("format: %w", ErrOutputInvalid), want: RetryOnOutputInvalid},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: RetryOnTimeout},
+		{name: "wrapped deadline exceeded", err: fmt.Errorf("invoke: %w", context.DeadlineExceeded), want: RetryOnTimeout},
+		{name: "net timeout", err: fmt.Errorf("dial: %w", timeoutError{}), want: RetryOnTimeout},
+		{name: "rate limit", err: &openai.Error{StatusCode: 429}, want: RetryOnRateLimit},
+		{name: "server error", err: &openai.Error{StatusCode: 503}, want: RetryOnServerError},
+		{name: "request timeout", err: &openai.Error{StatusCode: 408}, want: RetryOnTimeout},
+		{name: "wrapped api error", err: fmt.Errorf("format: %w", &openai.Error{StatusCode: 500}), want: RetryOnServerError},
+		{name: "bad request", err: &openai.Error{StatusCode: 400}, want: ""},
+		{name: "canceled", err: context.Canceled, want: ""},
+		{name: "message only", err: errors.New("429 Too Many Requests: rate limit timeout"), want: ""},
+		{name: "codex rate limit", err: &CodexError{ExitCode: 1, Message: "unexpected status 429 Too Many Requests"}, want: RetryOnRateLimit},
+		{name: "codex rate limit message", err: &CodexError{ExitCode: 1, Message: "Rate limit reached for gpt-5"}, want: RetryOnRateLimit},
+		{name: "codex server error", err: fmt.Errorf("invoke: %w", &CodexError{ExitCode: 1, Message: "unexpected status 503 Service Unavailable"}), want: RetryOnServerError},
+		{name: "codex timeout", err: &CodexError{ExitCode: 1, Message: "request timed out"}, want: RetryOnTimeout},
+		{name: "codex other failure", err: &CodexError{ExitCode: 2, Message: "unexpected status 401 Unauthorized"}, want: ""},
+		{name: "codex status in long output", err: &CodexError{ExitCode: 1, Message: strings.Repeat("x", maxClassifiedMessageBytes) + " 429"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := &RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 4, want: 5 * time.Second},
+		{attempt: 10, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			if got := policy.backoff(tt.attempt); got != tt.want {
+				t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := &RetryPolicy{
+		InitialBackoff: time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+
+	for range 100 {
+		got := policy.backoff(2)
+		if got < 1600*time.Millisecond || got > 2400*time.Millisecond {
+			t.Fatalf("backoff(2) = %s, want between 1.6s and 2.4s", got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	agent := &Agent{
+		Name: "test",
+		Retry: &RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			Multiplier:     1,
+			RetryOn:        []string{RetryOnRateLimit},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "success", errs: []error{nil}, wantAttempts: 1},
+		{name: "retried", errs: []error{&openai.Error{StatusCode: 429}, nil}, wantAttempts: 2},
+		{name: "max attempts", errs: []error{&openai.Error{StatusCode: 429}, &openai.Error{StatusCode: 429}, &openai.Error{StatusCode: 429}}, wantAttempts: 3, wantErr: true},
+		{name: "not in retry_on", errs: []error{&openai.Error{StatusCode: 503}}, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			_, err := retry(context.Background(), agent, &RunConfig{}, "test", func(ctx context.Context) (string, error) {
+				err := tt.errs[attempts]
+				attempts++
+				return "answer", err
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("retry() error = %v, wantErr %v", err != nil, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	agent := &Agent{
+		Name: "test",
+		Retry: &RetryPolicy{
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			Multiplier:     1,
+			RetryOn:        []string{RetryOnTimeout},
+			AttemptTimeout: 10 * time.Millisecond,
+		},
+	}
+
+	// 1 回目の試行は時間の上限まで終わらず、2 回目の試行で回答する
+	attempts := 0
+	got, err := retry(context.Background(), agent, &RunConfig{}, "test", func(ctx context.Context) (string, error) {
+		attempts++
+		if attempts == 1 {
+			<-ctx.Done()
+			return "", fmt.Errorf("codex: %w", ctx.Err())
+		}
+		return "answer", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "answer" || attempts != 2 {
+		t.Errorf("retry() = %q after %d attempts, want %q after 2 attempts", got, attempts, "answer")
+	}
+}
@@ -3,11 +3,11 @@ package agents
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
@@ -16,22 +16,121 @@ import (
 
 type RunConfig struct {
 	APIKey                  string
-	SubagentMCPServerConfig func(subAgent *SubAgent) (map[string]any, error)
+	Provider                *Provider // 指定されている場合、APIKey と Codex CLI のログイン状況の代わりに用いる
+	SubagentMCPServerConfig func(subAgent *SubAgent, parent *RuntimeContext) (map[string]any, error)
 	LogLevel                string // error, warn, info, debug, trace, off
 	LogWriter               io.Writer
+
+	// これまでの会話の履歴
+	// 指定されている場合、会話の続きとしてエージェントを実行する。
+	History []*Turn
+
+	// prompt_template の代わりにプロンプトとして用いるメッセージ
+	// 空文字列の場合は prompt_template を input で展開したものをプロンプトとする。
+	Message string
+
+	// Codex に確認させる画像ファイルの絶対パス
+	// Codex に画像入力として添付する。添付できない形式の画像は、プロンプトでパスを示して画像を閲覧するツールで確認させる。
+	Images []string
+
+	// サブエージェントとして実行された場合、呼び出し元のエージェントの名前と呼び出しの深さ
+	ParentAgent string
+	Depth       int
+
+	// 回答のキャッシュ
+	// 指定されている場合、同じエージェントの定義と入力で CacheTTL 以内に得た回答があれば、Codex を実行せずにそれを返す。
+	// ファイルを変更しうるサンドボックスでは、CacheWorkdir を指定しない限り利用しない。
+	Cache        Cache
+	CacheTTL     time.Duration
+	CacheWorkdir bool // 作業ディレクトリのファイルの内容もキャッシュのキーに含める
+
+	// 作業ディレクトリを隔離した場合、隔離した作業ディレクトリと元の作業ディレクトリの絶対パス
+	// 隔離した作業ディレクトリは実行ごとに異なるので、キャッシュのキーでは元の作業ディレクトリのパスに置き換える。
+	IsolatedWorkdir string
+	OriginalWorkdir string
+}
+
+// 会話のひとつのやりとり
+type Turn struct {
+	Prompt string
+	Answer string
+}
+
+// 回答を出力形式に合わせて整形するモデルのデフォルト値
+const DefaultRepairModel = openai.ChatModelGPT5Nano
+
+// プロンプトに含める会話の履歴の最大のバイト数
+// 会話を継続するたびにプロンプトが長くならないように、超えた分は古いやりとりから省く
+const MaxHistoryBytes = 32 * 1024
+
+// 会話の履歴のうち、プロンプトに含める最近のやりとりと、省いたやりとりの数を返す
+// 最新のやりとりだけで maxBytes を超える場合は、プロンプトと回答を切り詰めて含める
+func recentHistory(history []*Turn, maxBytes int) ([]*Turn, int) {
+	size := 0
+	start := len(history)
+	for start > 0 {
+		turn := history[start-1]
+		if size+len(turn.Prompt)+len(turn.Answer) > maxBytes {
+			break
+		}
+		size += len(turn.Prompt) + len(turn.Answer)
+		start--
+	}
+
+	if start == len(history) && len(history) > 0 {
+		last := history[len(history)-1]
+		return []*Turn{{
+			Prompt: truncateText(last.Prompt, maxBytes/2),
+			Answer: truncateText(last.Answer, maxBytes/2),
+		}}, len(history) - 1
+	}
+
+	return history[start:], start
+}
+
+// 文字列を maxBytes 以下に切り詰める
+// 切り詰めた場合は、その旨を末尾に記す
+func truncateText(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxBytes], "") + "\n（以下省略）"
+}
+
+// エージェントの実行結果
+type Result struct {
+	Output any    // 出力スキーマに従った出力
+	Prompt string // Codex に与えたプロンプト（会話の履歴と出力形式の指定は含まない）
+	Answer string // Codex の回答
+
+	// 出力にたいする検査の結果
+	// 検査を満たさないまま回答をやり直させる回数を超えた場合も、結果を返す。
+	Checks []*CheckResult
+
+	Cached bool   // キャッシュした回答を返した場合は true
+	Model  string // 回答したモデル（代替のモデルを用いた場合はそのモデル）
 }
 
-func (agent *Agent) Run(workdir string, input map[string]any, config *RunConfig) (any, error) {
+func (agent *Agent) Run(workdir string, input map[string]any, config *RunConfig) (*Result, error) {
 	// 作業ディレクトリの絶対パスを取得
 	workdirAbsPath, err := filepath.Abs(workdir)
 	if err != nil {
 		return nil, err
 	}
 
+	// 実行時の状況
+	runtime := &RuntimeContext{
+		Workdir:     workdirAbsPath,
+		Date:        time.Now(),
+		Agent:       agent.Name,
+		ParentAgent: config.ParentAgent,
+		Depth:       config.Depth,
+	}
+
 	// Codex の Config を構築
 	codexConfig := agent.Config.Clone()
 	for _, subAgent := range agent.SubAgents {
-		mcpServerConfig, err := config.SubagentMCPServerConfig(subAgent)
+		mcpServerConfig, err := config.SubagentMCPServerConfig(subAgent, runtime)
 		if err != nil {
 			return nil, err
 		}
@@ -39,13 +138,103 @@ func (agent *Agent) Run(workdir string, input map[string]any, config *RunConfig)
 		codexConfig["mcp_servers."+subAgent.Name] = mcpServerConfig
 	}
 
+	// モデルプロバイダーの指定
+	var codexEnv []string
+	if config.Provider != nil {
+		codexEnv = append(codexEnv, config.Provider.apiKeyEnv())
+		codexConfig["model_provider"] = codexModelProviderID
+		codexConfig["model_providers."+codexModelProviderID] = config.Provider.codexConfig()
+	}
+
 	// AGENTS.md が存在しても見に行かないように制限
 	codexConfig["project_doc_max_bytes"] = 0
 
+	// 画像は画像入力として添付する
+	// 添付できない形式の画像は、画像を閲覧するツールを有効化してパスで示す
+	var images, imagePaths []string
+	for _, image := range config.Images {
+		if isCodexImage(image) {
+			images = append(images, image)
+		} else {
+			imagePaths = append(imagePaths, image)
+		}
+	}
+	if len(imagePaths) > 0 {
+		codexConfig["features.view_image_tool"] = true
+	}
+
+	// 作業ディレクトリのほかに書き込めるディレクトリを指定
+	if len(agent.WritableRoots) > 0 {
+		writableRoots := make([]string, 0, len(agent.WritableRoots))
+		for _, writableRoot := range agent.WritableRoots {
+			if !filepath.IsAbs(writableRoot) {
+				writableRoot = filepath.Join(workdirAbsPath, writableRoot)
+			}
+			writableRoots = append(writableRoots, filepath.Clean(writableRoot))
+		}
+		codexConfig["sandbox_workspace_write.writable_roots"] = writableRoots
+	}
+
+	// 指示の構築
+	instruction, err := agent.RenderInstruction(input, runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	// 読み込めるファイルを制限する場合は、指示にその範囲を追加
+	// Codex のサンドボックスは読み込みを制限できないので、指示で制限する
+	if len(agent.ReadablePaths) > 0 {
+		readable := &strings.Builder{}
+		readable.WriteString(instruction)
+		fmt.Fprintln(readable, "")
+		fmt.Fprintln(readable, "")
+		fmt.Fprintln(readable, "以下のファイルとディレクトリ以外は読み込まないこと。")
+		for _, readablePath := range agent.ReadablePaths {
+			fmt.Fprintln(readable, "- "+readablePath)
+		}
+		instruction = readable.String()
+	}
+
+	// メッセージの構築
+	message := config.Message
+	if message == "" {
+		message, err = agent.RenderPrompt(workdirAbsPath, input)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// プロンプトの構築
+	// 会話の履歴があれば、その続きとして回答させる
 	prompt := &strings.Builder{}
-	if err := agent.PromptTemplate.Execute(prompt, input); err != nil {
-		return nil, err
+	if len(config.History) > 0 {
+		history, omitted := recentHistory(config.History, MaxHistoryBytes)
+		fmt.Fprintln(prompt, "<これまでの会話>")
+		if omitted > 0 {
+			fmt.Fprintf(prompt, "（古い %d 件のやりとりは省略）\n", omitted)
+		}
+		for _, turn := range history {
+			fmt.Fprintln(prompt, "<ユーザー>")
+			fmt.Fprintln(prompt, strings.TrimSpace(turn.Prompt))
+			fmt.Fprintln(prompt, "</ユーザー>")
+			fmt.Fprintln(prompt, "<アシスタント>")
+			fmt.Fprintln(prompt, strings.TrimSpace(turn.Answer))
+			fmt.Fprintln(prompt, "</アシスタント>")
+		}
+		fmt.Fprintln(prompt, "</これまでの会話>")
+		fmt.Fprintln(prompt, "")
+		fmt.Fprintln(prompt, "上記の会話の続きとして、以下に回答すること。")
+		fmt.Fprintln(prompt, "")
+	}
+	prompt.WriteString(message)
+
+	// 添付できない画像は、プロンプトでパスを示す
+	if len(imagePaths) > 0 {
+		fmt.Fprintln(prompt, "")
+		fmt.Fprintln(prompt, "以下の画像ファイルを view_image ツールで確認してから回答すること。")
+		for _, image := range imagePaths {
+			fmt.Fprintln(prompt, "- "+image)
+		}
 	}
 
 	// プロンプトに出力形式の指定を追加
@@ -57,6 +246,31 @@ func (agent *Agent) Run(workdir string, input map[string]any, config *RunConfig)
 	fmt.Fprintln(prompt, "なお、回答の出力形式は以下の JSON Schema に厳格に従うこと。")
 	fmt.Fprintln(prompt, string(outputSchemaJSON))
 
+	// キャッシュした回答があれば、それを返す
+	// 作業ディレクトリの状態によって検査の結果が変わることがあるので、検査は改めて行う
+	cache := config.Cache
+	if cache != nil && !agent.cacheable(config) {
+		agent.logf(config, "cache is not used with sandbox %s because cached answers do not reproduce file changes; set cache.workdir to use it", agent.Sandbox)
+		cache = nil
+	}
+	cacheKey := ""
+	if cache != nil {
+		cacheKey, err = agent.cacheKey(instruction, prompt.String(), codexConfig, config, workdirAbsPath)
+		if err != nil {
+			return nil, err
+		}
+		cached, err := cache.Load(cacheKey, config.CacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			checks := agent.CheckOutput(workdirAbsPath, cached.Output)
+			if checksPassed(checks) {
+				return &Result{Output: cached.Output, Prompt: message, Answer: cached.Answer, Checks: checks, Cached: true, Model: cached.Model}, nil
+			}
+		}
+	}
+
 	// Codex を実行して回答を取得
 	var options []codex.CodexOption
 	if agent.codexExecutablePath != "" {
@@ -68,27 +282,105 @@ func (agent *Agent) Run(workdir string, input map[string]any, config *RunConfig)
 	codexInstance := codex.New(options...)
 	ctx := context.Background()
 
-	loggedIn, err := codexInstance.IsLoggedIn(ctx)
-	if err != nil {
-		return nil, err
+	// モデルプロバイダーが指定されている場合は、その資格情報を用いるのでログインは不要
+	if config.Provider == nil {
+		loggedIn, err := codexInstance.IsLoggedIn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !loggedIn {
+			if err := codexInstance.Login(ctx, config.APIKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	invoke := func(ctx context.Context, codexConfig CodexConfig, prompt string) (string, error) {
+		return agent.execCodex(ctx, &codexRequest{
+			Prompt:         prompt,
+			Instructions:   instruction,
+			Cwd:            workdirAbsPath,
+			ApprovalPolicy: agent.ApprovalPolicy,
+			Sandbox:        agent.Sandbox,
+			Config:         codexConfig,
+			Images:         images,
+			Env:            codexEnv,
+		}, config)
+	}
+
+	// 主のモデルから代替のモデルへ順に試す
+	// 回答を得られないか、出力の検査を満たさない場合は、次のモデルで同じプロンプトを試す
+	modelConfigs := agent.modelConfigs(codexConfig)
+	var result *Result
+	for i, modelConfig := range modelConfigs {
+		result, err = agent.runModel(ctx, invoke, workdirAbsPath, modelConfig, prompt.String(), config)
+		if err == nil && checksPassed(result.Checks) {
+			break
+		}
+		if i == len(modelConfigs)-1 {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		reason := "output checks failed"
+		if err != nil {
+			reason = err.Error()
+		}
+		agent.logf(config, "model %s failed: %s; falling back to %s", modelName(modelConfig), reason, modelName(modelConfigs[i+1]))
 	}
-	if !loggedIn {
-		if err := codexInstance.Login(ctx, config.APIKey); err != nil {
+	result.Prompt = message
+
+	// 検査を満たした回答のみキャッシュする
+	if cache != nil && checksPassed(result.Checks) {
+		if err := cache.Save(cacheKey, result); err != nil {
 			return nil, err
 		}
 	}
 
-	answer, err := codexInstance.Invoke(
-		ctx,
-		prompt.String(),
-		codex.WithDeveloperInstructions(agent.Instruction),
-		codex.WithCwd(workdirAbsPath),
-		codex.WithApprovalPolicy(agent.ApprovalPolicy),
-		codex.WithSandbox(agent.Sandbox),
-		codex.WithConfig(codexConfig),
-	)
+	return result, nil
+}
+
+// 指定したモデルの Config で Codex を実行して回答を取得する
+// 出力の検査を満たさなければ、理由を伝えて回答をやり直させる
+func (agent *Agent) runModel(ctx context.Context, invoke func(ctx context.Context, codexConfig CodexConfig, prompt string) (string, error), workdirAbsPath string, codexConfig CodexConfig, prompt string, config *RunConfig) (*Result, error) {
+	invokeModel := func(ctx context.Context, prompt string) (string, error) {
+		return invoke(ctx, codexConfig, prompt)
+	}
+
+	promptText := prompt
+	for retries := 0; ; retries++ {
+		answer, output, err := agent.answer(ctx, invokeModel, promptText, config)
+		if err != nil {
+			return nil, err
+		}
+
+		checks := agent.CheckOutput(workdirAbsPath, output)
+		if checksPassed(checks) || retries >= agent.OutputCheckRetries {
+			return &Result{Output: output, Answer: answer, Checks: checks, Model: modelName(codexConfig)}, nil
+		}
+
+		promptText = retryPrompt(prompt, answer, checks)
+	}
+}
+
+// 回答を出力形式に合わせて整形するモデルを返す
+func (agent *Agent) repairModel() string {
+	if agent.RepairModel != "" {
+		return agent.RepairModel
+	}
+	return DefaultRepairModel
+}
+
+// Codex を実行して、出力形式に従った回答を取得する
+// Codex の実行と回答の整形は、それぞれ一時的な失敗を再試行する
+func (agent *Agent) answer(ctx context.Context, invoke func(ctx context.Context, prompt string) (string, error), prompt string, config *RunConfig) (string, any, error) {
+	answer, err := retry(ctx, agent, config, "codex", func(ctx context.Context) (string, error) {
+		return invoke(ctx, prompt)
+	})
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	answer = strings.TrimSpace(answer)
 
@@ -97,20 +389,40 @@ func (agent *Agent) Run(workdir string, input map[string]any, config *RunConfig)
 	if err := json.Unmarshal([]byte(answer), &output); err == nil {
 		resolved, err := agent.OutputSchema.Resolve(nil)
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
 		if resolved.Validate(output) == nil {
 			// 出力形式に従っていたら、そのまま返す
-			return output, nil
+			return answer, output, nil
 		}
 	}
 
 	// 回答の内容を AI で出力形式に合わせて整形する
-	client := openai.NewClient(option.WithAPIKey(config.APIKey))
+	output, err = retry(ctx, agent, config, "repair", func(ctx context.Context) (any, error) {
+		return agent.repair(ctx, answer, config)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return answer, output, nil
+}
+
+// 回答の内容をモデルで出力形式に合わせて整形する
+func (agent *Agent) repair(ctx context.Context, answer string, config *RunConfig) (any, error) {
+	clientOptions := []option.RequestOption{option.WithAPIKey(config.APIKey)}
+	if config.Provider != nil {
+		clientOptions = config.Provider.clientOptions()
+	}
+	if agent.Retry != nil {
+		// 再試行の方針に従って再試行するので、クライアントでは再試行しない
+		clientOptions = append(clientOptions, option.WithMaxRetries(0))
+	}
+	client := openai.NewClient(clientOptions...)
 	chatCompletion, err := client.Chat.Completions.New(
 		ctx,
 		openai.ChatCompletionNewParams{
-			Model:       openai.ChatModelGPT5Nano,
+			Model:       agent.repairModel(),
 			Temperature: openai.Float(1),
 			ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
 				OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
@@ -132,11 +444,29 @@ func (agent *Agent) Run(workdir string, input map[string]any, config *RunConfig)
 		return nil, err
 	}
 	if chatCompletion == nil || len(chatCompletion.Choices) == 0 {
-		return nil, errors.New("invalid format, openai chat completions response")
+		return nil, ErrOutputInvalid
 	}
+	var output any
 	if err := json.Unmarshal([]byte(chatCompletion.Choices[0].Message.Content), &output); err != nil {
-		return nil, errors.New("invalid format, openai chat completions response")
+		return nil, ErrOutputInvalid
 	}
-
 	return output, nil
 }
+
+// 出力の検査を満たさなかった回答をやり直させるプロンプトを返す
+func retryPrompt(prompt string, answer string, checks []*CheckResult) string {
+	retry := &strings.Builder{}
+	retry.WriteString(prompt)
+	fmt.Fprintln(retry, "")
+	fmt.Fprintln(retry, "<前回の回答>")
+	fmt.Fprintln(retry, answer)
+	fmt.Fprintln(retry, "</前回の回答>")
+	fmt.Fprintln(retry, "")
+	fmt.Fprintln(retry, "前回の回答は以下の検査を満たさなかった。理由を踏まえて、改めて回答すること。")
+	for _, check := range checks {
+		if !check.Passed {
+			fmt.Fprintf(retry, "- %s: %s\n", check.Name, check.Message)
+		}
+	}
+	return retry.String()
+}
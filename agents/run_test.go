@@ -0,0 +1,324 @@
+package agents
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+func TestRecentHistory(t *testing.T) {
+	turn := func(prompt string, answer string) *Turn {
+		return &Turn{Prompt: prompt, Answer: answer}
+	}
+
+	tests := []struct {
+		name        string
+		history     []*Turn
+		maxBytes    int
+		wantPrompts []string
+		wantOmitted int
+	}{
+		{
+			name:        "empty",
+			history:     []*Turn{},
+			maxBytes:    10,
+			wantPrompts: []string{},
+			wantOmitted: 0,
+		},
+		{
+			name:        "all turns fit",
+			history:     []*Turn{turn("a", "b"), turn("c", "d")},
+			maxBytes:    10,
+			wantPrompts: []string{"a", "c"},
+			wantOmitted: 0,
+		},
+		{
+			name:        "old turns are omitted",
+			history:     []*Turn{turn("aaaa", "bbbb"), turn("cc", "dd"), turn("ee", "ff")},
+			maxBytes:    10,
+			wantPrompts: []string{"cc", "ee"},
+			wantOmitted: 1,
+		},
+		{
+			name:        "latest turn is truncated",
+			history:     []*Turn{turn("aa", "bb"), turn("cccccccc", "dddddddd")},
+			maxBytes:    8,
+			wantPrompts: []string{"cccc\n（以下省略）"},
+			wantOmitted: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, omitted := recentHistory(tt.history, tt.maxBytes)
+			if omitted != tt.wantOmitted {
+				t.Errorf("omitted = %d, want %d", omitted, tt.wantOmitted)
+			}
+			prompts := []string{}
+			for _, turn := range history {
+				prompts = append(prompts, turn.Prompt)
+			}
+			if strings.Join(prompts, "|") != strings.Join(tt.wantPrompts, "|") {
+				t.Errorf("prompts = %q, want %q", prompts, tt.wantPrompts)
+			}
+		})
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		maxBytes int
+		want     string
+	}{
+		{name: "short", s: "abc", maxBytes: 3, want: "abc"},
+		{name: "long", s: "abcdef", maxBytes: 3, want: "abc\n（以下省略）"},
+		{name: "multibyte boundary", s: "あいう", maxBytes: 4, want: "あ\n（以下省略）"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateText(tt.s, tt.maxBytes); got != tt.want {
+				t.Errorf("truncateText(%q, %d) = %q, want %q", tt.s, tt.maxBytes, got, tt.want)
+			}
+		})
+	}
+}
+
+// codex の代わりのスクリプトで実行するエージェントを返す
+func testAgent(t *testing.T, codexPath string) *Agent {
+	t.Helper()
+	agent, err := Build(codexPath, &Config{
+		Name:           "test",
+		Instruction:    "指示",
+		PromptTemplate: "{{.question}}",
+		InputSchema:    map[string]*jsonschema.Schema{"question": {Type: "string"}},
+		OutputSchema:   map[string]*jsonschema.Schema{"ok": {Type: "string"}},
+		Sandbox:        "read-only",
+		Config:         CodexConfig{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func TestRunImages(t *testing.T) {
+	tests := []struct {
+		name          string
+		images        []string
+		wantAttached  []string
+		wantInPrompt  []string
+		wantViewImage bool
+	}{
+		{name: "none"},
+		{name: "attached", images: []string{"/work/a.png", "/work/b.JPG"}, wantAttached: []string{"/work/a.png", "/work/b.JPG"}},
+		{name: "fallback", images: []string{"/work/a.png", "/work/c.bmp"}, wantAttached: []string{"/work/a.png"}, wantInPrompt: []string{"/work/c.bmp"}, wantViewImage: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codexPath := fakeCodex(t, fakeCodexScript)
+			t.Setenv("FAKE_CODEX_ANSWER", `{"ok":"y"}`)
+			agent := testAgent(t, codexPath)
+
+			result, err := agent.Run(t.TempDir(), map[string]any{"question": "q"}, &RunConfig{Images: tt.images})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Output.(map[string]any)["ok"] != "y" {
+				t.Errorf("Output = %v", result.Output)
+			}
+
+			b, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "args.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := strings.Split(string(b), "\n")
+			attached := []string{}
+			for i, arg := range args {
+				if arg == "--image" {
+					attached = append(attached, args[i+1])
+				}
+			}
+			if len(tt.wantAttached) > 0 && !reflect.DeepEqual(attached, tt.wantAttached) || len(tt.wantAttached) == 0 && len(attached) > 0 {
+				t.Errorf("attached images = %v, want %v", attached, tt.wantAttached)
+			}
+			if viewImage := slices.Contains(args, "features.view_image_tool=true"); viewImage != tt.wantViewImage {
+				t.Errorf("view_image_tool = %v, want %v", viewImage, tt.wantViewImage)
+			}
+
+			prompt, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "prompt.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, image := range tt.images {
+				if inPrompt := strings.Contains(string(prompt), image); inPrompt != slices.Contains(tt.wantInPrompt, image) {
+					t.Errorf("%s in prompt = %v", image, inPrompt)
+				}
+			}
+		})
+	}
+}
+
+func TestRunProvider(t *testing.T) {
+	// Codex の回答を整形するモデルを記録する OpenAI 互換 API
+	var repairModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		repairModel = body.Model
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"1","object":"chat.completion","created":0,"model":"m","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"{\"ok\":\"y\"}"}}]}`)
+	}))
+	defer server.Close()
+
+	provider := &Provider{Name: ProviderOpenAICompatible, APIKey: "test-api-key-0123456789", BaseURL: server.URL}
+
+	tests := []struct {
+		name        string
+		repairModel string
+		want        string
+	}{
+		{name: "default", want: DefaultRepairModel},
+		{name: "repair_model", repairModel: "my-repair-model", want: "my-repair-model"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codexPath := fakeCodex(t, fakeCodexScript)
+			t.Setenv("FAKE_CODEX_ANSWER", "ok は y です")
+			agent := testAgent(t, codexPath)
+			agent.RepairModel = tt.repairModel
+
+			result, err := agent.Run(t.TempDir(), map[string]any{"question": "q"}, &RunConfig{Provider: provider})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Output.(map[string]any)["ok"] != "y" {
+				t.Errorf("Output = %v", result.Output)
+			}
+			if repairModel != tt.want {
+				t.Errorf("repair model = %q, want %q", repairModel, tt.want)
+			}
+
+			// API Key は Codex のプロセスにのみ与え、ace のプロセスの環境変数には設定しない
+			env, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "env.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(strings.Split(string(env), "\n"), provider.apiKeyEnv()) {
+				t.Error("the API key is not passed to codex")
+			}
+			if _, ok := os.LookupEnv(provider.apiKeyEnvVar()); ok {
+				t.Error("the API key is set in the environment of ace")
+			}
+		})
+	}
+}
+
+// 呼び出された回数を記録し、FAKE_CODEX_FAILURES 回目までは FAKE_CODEX_ERROR を報告して失敗する codex の代わりのスクリプト
+// 成功したときは出力形式に従わない回答を返すので、回答の整形が必要になる
+const failingCodexScript = `#!/bin/sh
+dir=$(dirname "$0")
+echo call >> "$dir/calls.txt"
+out=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --output-last-message) out="$2"; shift ;;
+  esac
+  shift
+done
+cat > /dev/null
+if [ "$(wc -l < "$dir/calls.txt")" -le "$FAKE_CODEX_FAILURES" ]; then
+  echo '{"type":"turn.started"}'
+  echo "{\"type\":\"turn.failed\",\"error\":{\"message\":\"$FAKE_CODEX_ERROR\"}}"
+  exit 1
+fi
+echo '{"type":"turn.completed"}'
+printf 'ok は y です' > "$out"
+`
+
+func TestRunRetry(t *testing.T) {
+	tests := []struct {
+		name            string
+		codexFailures   int
+		codexError      string
+		repairFailures  int
+		wantErr         bool
+		wantCodexCalls  int
+		wantRepairCalls int
+	}{
+		{name: "codex rate limit", codexFailures: 1, codexError: "unexpected status 429 Too Many Requests", wantCodexCalls: 2, wantRepairCalls: 1},
+		{name: "codex not retried", codexFailures: 1, codexError: "unexpected status 401 Unauthorized", wantErr: true, wantCodexCalls: 1},
+		{name: "codex max attempts", codexFailures: 3, codexError: "unexpected status 503 Service Unavailable", wantErr: true, wantCodexCalls: 3},
+		// 整形の失敗は整形のみを再試行し、Codex を再実行しない
+		{name: "repair rate limit", repairFailures: 1, wantCodexCalls: 1, wantRepairCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// repairFailures 回目までは 429 を返す OpenAI 互換 API
+			repairCalls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				repairCalls++
+				w.Header().Set("Content-Type", "application/json")
+				if repairCalls <= tt.repairFailures {
+					w.WriteHeader(http.StatusTooManyRequests)
+					io.WriteString(w, `{"error":{"message":"rate limit","type":"rate_limit_error"}}`)
+					return
+				}
+				io.WriteString(w, `{"id":"1","object":"chat.completion","created":0,"model":"m","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"{\"ok\":\"y\"}"}}]}`)
+			}))
+			defer server.Close()
+
+			codexPath := fakeCodex(t, failingCodexScript)
+			t.Setenv("FAKE_CODEX_FAILURES", strconv.Itoa(tt.codexFailures))
+			t.Setenv("FAKE_CODEX_ERROR", tt.codexError)
+			agent := testAgent(t, codexPath)
+			agent.Retry = &RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Millisecond,
+				Multiplier:     1,
+				RetryOn:        []string{RetryOnRateLimit, RetryOnServerError},
+			}
+
+			provider := &Provider{Name: ProviderOpenAICompatible, APIKey: "test-api-key-0123456789", BaseURL: server.URL}
+			result, err := agent.Run(t.TempDir(), map[string]any{"question": "q"}, &RunConfig{Provider: provider})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && result.Output.(map[string]any)["ok"] != "y" {
+				t.Errorf("Output = %v", result.Output)
+			}
+
+			calls, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "calls.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codexCalls := strings.Count(string(calls), "\n"); codexCalls != tt.wantCodexCalls {
+				t.Errorf("codex calls = %d, want %d", codexCalls, tt.wantCodexCalls)
+			}
+			if repairCalls != tt.wantRepairCalls {
+				t.Errorf("repair calls = %d, want %d", repairCalls, tt.wantRepairCalls)
+			}
+		})
+	}
+}
@@ -2,66 +2,356 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/jsonschema-go/jsonschema"
 	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/kurusugawa-computer/ace/workspace"
 )
 
+// エージェントの実行オプション
+type RunOptions struct {
+	// 会話を継続するセッション名
+	// 同じセッション名でエージェントを実行すると、前回の実行の続きとして会話する。
+	Session string
+
+	// 会話を継続する実行ID
+	// 指定した実行の続きとして会話する。
+	Resume string
+
+	// prompt_template の代わりにプロンプトとして用いるメッセージ
+	// 指定した場合、arguments は input_schema に従ってパースされない。
+	Message string
+
+	// Message を指定した場合に、instruction や workdir のテンプレートの展開に用いる入力
+	// 会話を継続する場合は、最初の実行の入力を指定する。
+	Input map[string]any
+}
+
 // エージェントを実行する。
 // arguments: ["KEY=VALUE", "KEY=VALUE"...]
-func (app *App) RunAgent(agentName string, workdir string, arguments []string) (any, error) {
+func (app *App) RunAgent(agentName string, workdir string, arguments []string, options *RunOptions) (*journal.Run, error) {
 	// エージェントのビルド
 	agent, err := app.buildAgent(agentName)
 	if err != nil {
 		return nil, err
 	}
 
-	// ["KEY=VALUE", "KEY=VALUE"...] 形式の arguments を map[string]any にパースする
-	// ただし、この段階では value は string のまま。(KEYの階層構造のみをパース)
-	argumentsMap, err := parseArguments(arguments)
+	input := map[string]any{}
+	if options.Message != "" && options.Input != nil {
+		input = maps.Clone(options.Input)
+	}
+	if options.Message == "" {
+		// ["KEY=VALUE", "KEY=VALUE"...] 形式の arguments を map[string]any にパースする
+		// ただし、この段階では value は string のまま。(KEYの階層構造のみをパース)
+		argumentsMap, err := parseArguments(arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		// input_schema の定義に従って、arguments をパースする
+		for propName, propSchema := range agent.InputSchema.Properties {
+			value, err := app.applyJSONSchema(propName, argumentsMap[propName], propSchema)
+			if err != nil {
+				return nil, err
+			}
+			input[propName] = value
+		}
+	}
+
+	// エージェントの実行
+	return app.runAgent(agent, workdir, input, options)
+}
+
+// エージェントを実行し、実行記録を保存する
+func (app *App) runAgent(agent *agents.Agent, workdir string, input map[string]any, options *RunOptions) (*journal.Run, error) {
+	defer app.flushLog()
+
+	// 作業ディレクトリの絶対パスを取得
+	workdirAbsPath, err := filepath.Abs(workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	// エージェントの作業ディレクトリを解決
+	// workdir が定義されていれば、呼び出し元の作業ディレクトリ以下のそのディレクトリで実行する
+	agentWorkdirAbsPath, err := agent.ResolveWorkdir(workdirAbsPath, input)
+	if err != nil {
+		return nil, err
+	}
+	agentWorkdirRel, err := filepath.Rel(workdirAbsPath, agentWorkdirAbsPath)
 	if err != nil {
 		return nil, err
 	}
 
-	// input_schema の定義に従って、arguments をパースする
-	input := map[string]any{}
-	for propName, propSchema := range agent.InputSchema.Properties {
-		value, err := applyJSONSchema(propName, argumentsMap[propName], propSchema)
+	// 作業ディレクトリを隔離
+	// エージェントは隔離した作業ディレクトリで実行し、変更は実行を終えてから反映・破棄する
+	// ファイルの入力は、git で無視されるファイルであっても隔離した作業ディレクトリに含める
+	baseWorkdir := workdirAbsPath
+	var isolated *workspace.Workspace
+	if app.isolation != "" {
+		isolated, err = workspace.Create(app.isolation, workdirAbsPath, fileInputPaths(agent, input)...)
 		if err != nil {
 			return nil, err
 		}
-		input[propName] = value
+		defer func() {
+			if isolated != nil {
+				isolated.Remove() // 実行前に失敗した場合
+			}
+		}()
+		baseWorkdir = isolated.Dir
+	}
+	agentWorkdir := filepath.Join(baseWorkdir, agentWorkdirRel)
+	if err := os.MkdirAll(agentWorkdir, 0o755); err != nil {
+		return nil, err
 	}
 
-	// vars の値を展開する
-	if app.config.Vars != nil {
-		for key, value := range app.config.Vars {
-			if _, ok := input[key]; ok {
-				continue
-			}
-			input[key] = value
+	// ファイルの入力を確認し、Codex に確認させる画像を取得
+	// ファイルの入力は呼び出し元の作業ディレクトリからの相対パスで受け取り、エージェントの作業ディレクトリからの相対パスに置き換える
+	runInput := maps.Clone(input)
+	images, err := checkFileInputs(agent, baseWorkdir, agentWorkdir, input)
+	if err != nil {
+		return nil, err
+	}
+	if agentWorkdir != baseWorkdir {
+		if err := relocateFileInputs(agent, baseWorkdir, agentWorkdir, input); err != nil {
+			return nil, err
+		}
+	}
+
+	// 資格情報のプロファイルを解決
+	provider, err := app.resolveCredential(agent.Name)
+	if err != nil {
+		return nil, err
+	}
+	if provider != nil && app.redactor != nil {
+		app.redactor.AddValues(provider.APIKey)
+	}
+
+	// 回答のキャッシュの利用方法を解決
+	cache, cacheTTL, cacheWorkdir, err := app.resolveCache(agent.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// 会話を継続する実行記録を特定
+	if (options.Session != "" || options.Resume != "") && app.journal == nil {
+		return nil, errors.New("sessions require a run journal")
+	}
+	session := options.Session
+	parentID := ""
+	switch {
+	case options.Resume != "":
+		resumed, err := app.journal.Load(options.Resume)
+		if err != nil {
+			return nil, err
+		}
+		if resumed.Agent != agent.Name {
+			return nil, fmt.Errorf("run %s was executed by another agent: %s", resumed.ID, resumed.Agent)
+		}
+		if session == "" {
+			session = resumed.Session
+		}
+		parentID = resumed.ID
+
+	case options.Session != "":
+		latest, err := app.journal.Latest(agent.Name, options.Session)
+		if err != nil {
+			return nil, err
+		}
+		if latest != nil {
+			parentID = latest.ID
 		}
 	}
 
+	// 会話の履歴を取得
+	history := []*agents.Turn{}
+	if parentID != "" {
+		runs, err := app.journal.History(parentID)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range runs {
+			history = append(history, &agents.Turn{Prompt: run.Prompt, Answer: run.Answer})
+		}
+	}
+
+	run := &journal.Run{
+		ID:        journal.NewID(),
+		Agent:     agent.Name,
+		Session:   session,
+		ParentID:  parentID,
+		Workdir:   agentWorkdirAbsPath,
+		Input:     runInput,
+		StartedAt: time.Now(),
+	}
+
+	// vars の値を展開する
+	app.expandVars(input)
+
+	// 隔離した作業ディレクトリと元の作業ディレクトリ
+	isolatedWorkdir, originalWorkdir := "", ""
+	if isolated != nil {
+		isolatedWorkdir, originalWorkdir = isolated.Dir, workdirAbsPath
+	}
+
 	// エージェントの実行
-	output, err := agent.Run(
-		workdir,
+	result, err := agent.Run(
+		agentWorkdir,
 		input,
 		&agents.RunConfig{
 			APIKey:                  app.apiKey,
+			Provider:                provider,
 			SubagentMCPServerConfig: app.subAgentMCPServerConfig,
 			LogLevel:                app.logLevel,
 			LogWriter:               app.logWriter,
+			History:                 history,
+			Message:                 options.Message,
+			Images:                  images,
+			ParentAgent:             app.parentAgent,
+			Depth:                   app.depth,
+			Cache:                   cache,
+			CacheTTL:                cacheTTL,
+			CacheWorkdir:            cacheWorkdir,
+			IsolatedWorkdir:         isolatedWorkdir,
+			OriginalWorkdir:         originalWorkdir,
 		},
 	)
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	} else {
+		run.Prompt = result.Prompt
+		run.Answer = result.Answer
+		run.Output = result.Output
+		run.Cached = result.Cached
+		run.Model = result.Model
+		for _, check := range result.Checks {
+			run.Checks = append(run.Checks, &journal.Check{Name: check.Name, Passed: check.Passed, Message: check.Message})
+		}
+	}
+
+	// 出力から秘密の情報を取り除く
+	if app.redactsOutputs() {
+		run.Output = app.redactor.Value(run.Output)
+	}
+
+	// 隔離した作業ディレクトリでの変更を記録し、反映・破棄する
+	if isolated != nil {
+		if err1 := app.finishWorkspace(isolated, run, err != nil); err1 != nil && err == nil {
+			err = err1
+			run.Error = err1.Error()
+		}
+		isolated = nil
+	}
+
+	// 秘密の情報を取り除いて実行記録を保存
+	if app.journal != nil {
+		if err1 := app.journal.Save(app.redactRun(run)); err1 != nil && err == nil {
+			err = err1
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return output, nil
+	return run, nil
+}
+
+// 隔離した作業ディレクトリでの変更の差分を実行記録に残し、変更を反映・破棄する
+// 実行に失敗した場合は変更を反映しない
+func (app *App) finishWorkspace(isolated *workspace.Workspace, run *journal.Run, failed bool) error {
+	diff, err := isolated.Diff()
+	if err != nil {
+		isolated.Remove()
+		return err
+	}
+	run.Diff = diff
+
+	changes := app.isolationChanges
+	if failed && changes == workspace.ChangesApply {
+		changes = workspace.ChangesDiscard
+	}
+	if changes == workspace.ChangesKeep {
+		run.Workspace = isolated.Dir
+	}
+
+	if err := isolated.Finish(changes); err != nil {
+		// 反映できなかった変更を失わないように、隔離した作業ディレクトリを残す
+		if changes == workspace.ChangesApply {
+			run.Workspace = isolated.Dir
+			return fmt.Errorf("failed to apply changes (kept in %s): %w", isolated.Dir, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// エージェントの cache の定義とキャッシュの利用方法から、キャッシュの保存先と有効期間を返す
+// キャッシュを利用しない場合は nil を返す
+func (app *App) resolveCache(agentName string) (agents.Cache, time.Duration, bool, error) {
+	if app.cache == nil || app.cacheMode == CacheModeNone {
+		return nil, 0, false, nil
+	}
+
+	cacheConfig := app.config.Agents[agentName].Cache
+	if cacheConfig == nil {
+		if app.cacheMode != CacheModeAll {
+			return nil, 0, false, nil
+		}
+		cacheConfig = &CacheConfig{}
+	}
+
+	ttl, err := cacheConfig.Duration()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	// 秘密の情報を取り除いてからキャッシュする
+	cache := app.cache
+	if app.redactor != nil {
+		cache = &redactedCache{Cache: cache, redactor: app.redactor, outputs: app.redactsOutputs()}
+	}
+
+	return cache, ttl, cacheConfig.Workdir, nil
+}
+
+// エージェントの credential に指定されたプロファイルから、モデルプロバイダーを返す
+// credential が指定されていなければ nil を返す
+func (app *App) resolveCredential(agentName string) (*agents.Provider, error) {
+	agentConfig, ok := app.config.Agents[agentName]
+	if !ok || agentConfig.Credential == "" {
+		return nil, nil
+	}
+	if app.credentialResolver == nil {
+		return nil, errors.New("credential profiles are not available: " + agentConfig.Credential)
+	}
+
+	provider, err := app.credentialResolver(agentConfig.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("credential %s: %w", agentConfig.Credential, err)
+	}
+
+	return provider, nil
+}
+
+// input に vars の値を展開する
+// input に同じ Key が存在する場合は input の値を優先する
+func (app *App) expandVars(input map[string]any) {
+	for key, value := range app.config.Vars {
+		if _, ok := input[key]; ok {
+			continue
+		}
+		input[key] = value
+	}
 }
 
 func parseArguments(arguments []string) (map[string]any, error) {
@@ -92,14 +382,51 @@ func parseArguments(arguments []string) (map[string]any, error) {
 			}
 		}
 
+		// 同じ KEY が複数回指定された場合は配列とする
 		key := keys[len(keys)-1]
-		currentMap[key] = value
+		switch current := currentMap[key].(type) {
+		case nil:
+			currentMap[key] = value
+		case string:
+			currentMap[key] = []string{current, value}
+		case []string:
+			currentMap[key] = append(current, value)
+		default:
+			return nil, fmt.Errorf("conflicting input values specified in arguments: %s", strings.Join(keys, "."))
+		}
 	}
 
 	return input, nil
 }
 
-func applyJSONSchema(key string, value any, schema *jsonschema.Schema) (any, error) {
+func (app *App) applyJSONSchema(key string, value any, schema *jsonschema.Schema) (any, error) {
+	// 値が指定されていなければ、対話的に入力してもらう
+	if value == nil && app.inputPrompter != nil {
+		switch schema.Type {
+		case "object":
+			// プロパティごとに入力してもらう
+			if schema.Default == nil {
+				value = map[string]any{}
+			}
+
+		case "null":
+
+		default:
+			prompted, err := app.inputPrompter(key, schema)
+			if err != nil {
+				return nil, err
+			}
+			if prompted != nil {
+				value = prompted
+			}
+		}
+	}
+
+	// 同じ KEY が複数回指定された場合、配列以外では最後の値を用いる
+	if values, ok := value.([]string); ok && schema.Type != "array" && len(values) > 0 {
+		value = values[len(values)-1]
+	}
+
 	switch schema.Type {
 	default:
 		return value, nil
@@ -229,8 +556,13 @@ func applyJSONSchema(key string, value any, schema *jsonschema.Schema) (any, err
 			return array, nil
 		}
 
-		values, ok := value.([]string)
-		if !ok {
+		var values []string
+		switch value := value.(type) {
+		case string:
+			values = []string{value}
+		case []string:
+			values = value
+		default:
 			return nil, fmt.Errorf("specified value is incompatible with the input_schema definition: %s", key)
 		}
 
@@ -249,9 +581,14 @@ func applyJSONSchema(key string, value any, schema *jsonschema.Schema) (any, err
 			}
 		}
 
+		// 要素のスキーマが定義されていなければ、文字列のまま受け取る
+		for i := len(schemas); i < len(values); i++ {
+			schemas = append(schemas, &jsonschema.Schema{})
+		}
+
 		array := make([]any, 0, len(values))
 		for i := 0; i < len(values); i++ {
-			value, err := applyJSONSchema(fmt.Sprintf("%s[%d]", key, i), values[i], schemas[i])
+			value, err := app.applyJSONSchema(fmt.Sprintf("%s[%d]", key, i), values[i], schemas[i])
 			if err != nil {
 				return nil, err
 			}
@@ -282,7 +619,7 @@ func applyJSONSchema(key string, value any, schema *jsonschema.Schema) (any, err
 
 		object := map[string]any{}
 		for propName, propSchema := range schema.Properties {
-			value, err := applyJSONSchema(fmt.Sprintf("%s.%s", key, propName), values[propName], propSchema)
+			value, err := app.applyJSONSchema(fmt.Sprintf("%s.%s", key, propName), values[propName], propSchema)
 			if err != nil {
 				return nil, err
 			}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+func TestParseArguments(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments []string
+		want      map[string]any
+		wantErr   bool
+	}{
+		{
+			name:      "empty",
+			arguments: []string{},
+			want:      map[string]any{},
+		},
+		{
+			name:      "flat",
+			arguments: []string{"a=1", "b=x=y"},
+			want:      map[string]any{"a": "1", "b": "x=y"},
+		},
+		{
+			name:      "nested",
+			arguments: []string{"a.b=1", "a.c=2"},
+			want:      map[string]any{"a": map[string]any{"b": "1", "c": "2"}},
+		},
+		{
+			name:      "repeated",
+			arguments: []string{"a=1", "a=2", "a=3"},
+			want:      map[string]any{"a": []string{"1", "2", "3"}},
+		},
+		{
+			name:      "not KEY=VALUE",
+			arguments: []string{"a"},
+			wantErr:   true,
+		},
+		{
+			name:      "value and object",
+			arguments: []string{"a=1", "a.b=2"},
+			wantErr:   true,
+		},
+		{
+			name:      "object and value",
+			arguments: []string{"a.b=1", "a=2"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArguments(tt.arguments)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseArguments(%q) error = %v, wantErr %v", tt.arguments, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArguments(%q) = %#v, want %#v", tt.arguments, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		schema  *jsonschema.Schema
+		want    any
+		wantErr bool
+	}{
+		{
+			name:   "string",
+			value:  "abc",
+			schema: &jsonschema.Schema{Type: "string"},
+			want:   "abc",
+		},
+		{
+			name:   "string default",
+			value:  nil,
+			schema: &jsonschema.Schema{Type: "string", Default: json.RawMessage(`"def"`)},
+			want:   "def",
+		},
+		{
+			name:    "string missing",
+			value:   nil,
+			schema:  &jsonschema.Schema{Type: "string"},
+			wantErr: true,
+		},
+		{
+			name:   "string repeated uses last value",
+			value:  []string{"a", "b"},
+			schema: &jsonschema.Schema{Type: "string"},
+			want:   "b",
+		},
+		{
+			name:   "integer",
+			value:  "42",
+			schema: &jsonschema.Schema{Type: "integer"},
+			want:   int64(42),
+		},
+		{
+			name:   "integer repeated uses last value",
+			value:  []string{"1", "2"},
+			schema: &jsonschema.Schema{Type: "integer"},
+			want:   int64(2),
+		},
+		{
+			name:    "integer invalid",
+			value:   "x",
+			schema:  &jsonschema.Schema{Type: "integer"},
+			wantErr: true,
+		},
+		{
+			name:   "number",
+			value:  "1.5",
+			schema: &jsonschema.Schema{Type: "number"},
+			want:   1.5,
+		},
+		{
+			name:   "boolean",
+			value:  "true",
+			schema: &jsonschema.Schema{Type: "boolean"},
+			want:   true,
+		},
+		{
+			name:   "null",
+			value:  "null",
+			schema: &jsonschema.Schema{Type: "null"},
+			want:   nil,
+		},
+		{
+			name:    "null invalid",
+			value:   "x",
+			schema:  &jsonschema.Schema{Type: "null"},
+			wantErr: true,
+		},
+		{
+			name:   "untyped",
+			value:  []string{"a", "b"},
+			schema: &jsonschema.Schema{},
+			want:   "b",
+		},
+		{
+			name:   "array single value",
+			value:  "1",
+			schema: &jsonschema.Schema{Type: "array", Items: &jsonschema.Schema{Type: "integer"}},
+			want:   []any{int64(1)},
+		},
+		{
+			name:   "array repeated",
+			value:  []string{"1", "2"},
+			schema: &jsonschema.Schema{Type: "array", Items: &jsonschema.Schema{Type: "integer"}},
+			want:   []any{int64(1), int64(2)},
+		},
+		{
+			name:  "array prefix items",
+			value: []string{"a", "2", "true"},
+			schema: &jsonschema.Schema{
+				Type:        "array",
+				PrefixItems: []*jsonschema.Schema{{Type: "string"}, {Type: "integer"}},
+				Items:       &jsonschema.Schema{Type: "boolean"},
+			},
+			want: []any{"a", int64(2), true},
+		},
+		{
+			name:  "array more values than prefix items",
+			value: []string{"a", "b"},
+			schema: &jsonschema.Schema{
+				Type:        "array",
+				PrefixItems: []*jsonschema.Schema{{Type: "string"}},
+			},
+			want: []any{"a", "b"},
+		},
+		{
+			name:   "array without items",
+			value:  []string{"a", "b"},
+			schema: &jsonschema.Schema{Type: "array"},
+			want:   []any{"a", "b"},
+		},
+		{
+			name:   "array default",
+			value:  nil,
+			schema: &jsonschema.Schema{Type: "array", Default: json.RawMessage(`[1, 2]`)},
+			want:   []any{float64(1), float64(2)},
+		},
+		{
+			name:    "array of object",
+			value:   map[string]any{"a": "1"},
+			schema:  &jsonschema.Schema{Type: "array"},
+			wantErr: true,
+		},
+		{
+			name:  "object",
+			value: map[string]any{"a": "1", "b": []string{"x", "y"}},
+			schema: &jsonschema.Schema{
+				Type: "object",
+				Properties: map[string]*jsonschema.Schema{
+					"a": {Type: "integer"},
+					"b": {Type: "array"},
+					"c": {Type: "string", Default: json.RawMessage(`"z"`)},
+				},
+			},
+			want: map[string]any{"a": int64(1), "b": []any{"x", "y"}, "c": "z"},
+		},
+		{
+			name:    "object from string",
+			value:   "x",
+			schema:  &jsonschema.Schema{Type: "object"},
+			wantErr: true,
+		},
+		{
+			name:  "object missing property",
+			value: map[string]any{},
+			schema: &jsonschema.Schema{
+				Type:       "object",
+				Properties: map[string]*jsonschema.Schema{"a": {Type: "string"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	app := &App{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := app.applyJSONSchema("key", tt.value, tt.schema)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyJSONSchema(%#v) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyJSONSchema(%#v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,24 +1,66 @@
 package app
 
 import (
+	"fmt"
 	"io"
+	"time"
 
+	"github.com/google/jsonschema-go/jsonschema"
 	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/kurusugawa-computer/ace/redact"
 )
 
 type App struct {
 	config                  *Config
 	codexExecutablePath     string // Codex の実行パス
 	apiKey                  string
-	subAgentMCPServerConfig func(subAgent *agents.SubAgent) (map[string]any, error)
+	subAgentMCPServerConfig func(subAgent *agents.SubAgent, parent *agents.RuntimeContext) (map[string]any, error)
 
 	logWriter io.Writer
 	logLevel  string // error, warn, info, debug, trace, off
+
+	journal *journal.Journal // 実行記録の保存先。nil なら記録しない
+
+	inputPrompter InputPrompter // 入力が不足しているときに値を問い合わせる関数。nil なら問い合わせない
+
+	credentialResolver CredentialResolver // エージェントの credential を解決する関数
+
+	parentAgent string // サブエージェントとして実行された場合、呼び出し元のエージェントの名前
+	depth       int    // サブエージェントの呼び出しの深さ
+
+	cache     agents.Cache // 回答のキャッシュの保存先。nil ならキャッシュしない
+	cacheMode string       // auto, all, none
+
+	isolation        string // 作業ディレクトリを隔離する方法（tmp-copy, git-worktree）。空文字列なら隔離しない
+	isolationChanges string // 隔離した作業ディレクトリでの変更をどうするか（apply, discard, keep）
+
+	policy *Policy // エージェントの定義に適用するセキュリティポリシー。nil なら制限しない
+
+	redactor *redact.Redactor // ログと実行記録から秘密の情報を取り除く。nil なら取り除かない
 }
 
+// 回答のキャッシュの利用方法
+const (
+	CacheModeAuto = "auto" // cache を定義したエージェントのみキャッシュを利用する
+	CacheModeAll  = "all"  // すべてのエージェントでキャッシュを利用する
+	CacheModeNone = "none" // キャッシュを利用しない
+)
+
+// キャッシュの有効期間のデフォルト値
+const DefaultCacheTTL = 24 * time.Hour
+
+// 資格情報のプロファイル名から、モデルプロバイダーとその資格情報を返す関数
+type CredentialResolver func(profileName string) (*agents.Provider, error)
+
+// input_schema で定義された入力の値が指定されていないとき、値を問い合わせる関数
+// 値は KEY=VALUE 形式で指定したときと同様に string、配列の場合は []string で返す。
+// nil を返した場合は input_schema の default を用いる。
+type InputPrompter func(key string, schema *jsonschema.Schema) (any, error)
+
 type AppOption func(*App)
 
-func New(config *Config, codexExecutablePath string, apiKey string, subAgentMCPServerConfig func(subAgent *agents.SubAgent) (map[string]any, error), options ...AppOption) *App {
+func New(config *Config, codexExecutablePath string, apiKey string, subAgentMCPServerConfig func(subAgent *agents.SubAgent, parent *agents.RuntimeContext) (map[string]any, error), options ...AppOption) *App {
 	app := &App{
 		config:                  config,
 		codexExecutablePath:     codexExecutablePath,
@@ -30,6 +72,12 @@ func New(config *Config, codexExecutablePath string, apiKey string, subAgentMCPS
 		option(app)
 	}
 
+	// ログから秘密の情報を取り除く
+	app.redactor = newRedactor(config, apiKey)
+	if app.redactor != nil && app.logWriter != nil {
+		app.logWriter = app.redactor.Writer(app.logWriter)
+	}
+
 	return app
 }
 
@@ -39,3 +87,73 @@ func WithLogger(logWriter io.Writer, logLevel string) AppOption {
 		app.logLevel = logLevel
 	}
 }
+
+func WithJournal(journal *journal.Journal) AppOption {
+	return func(app *App) {
+		app.journal = journal
+	}
+}
+
+func WithInputPrompter(inputPrompter InputPrompter) AppOption {
+	return func(app *App) {
+		app.inputPrompter = inputPrompter
+	}
+}
+
+func WithCredentialResolver(credentialResolver CredentialResolver) AppOption {
+	return func(app *App) {
+		app.credentialResolver = credentialResolver
+	}
+}
+
+// サブエージェントとして実行する場合に、呼び出し元のエージェントの名前と呼び出しの深さを指定する
+func WithParentAgent(parentAgent string, depth int) AppOption {
+	return func(app *App) {
+		app.parentAgent = parentAgent
+		app.depth = depth
+	}
+}
+
+// 回答のキャッシュの保存先と利用方法を指定する
+func WithCache(cache agents.Cache, cacheMode string) AppOption {
+	return func(app *App) {
+		app.cache = cache
+		app.cacheMode = cacheMode
+	}
+}
+
+// 作業ディレクトリを隔離してエージェントを実行する
+// changes で、実行を終えたときに隔離した作業ディレクトリでの変更をどうするかを指定する
+func WithIsolation(isolation string, changes string) AppOption {
+	return func(app *App) {
+		app.isolation = isolation
+		app.isolationChanges = changes
+	}
+}
+
+// エージェントをビルドするとき、定義がセキュリティポリシーに反していないか検査する
+func WithPolicy(policy *Policy) AppOption {
+	return func(app *App) {
+		app.policy = policy
+	}
+}
+
+// ログに警告を出力する
+func (app *App) logf(format string, args ...any) {
+	switch app.logLevel {
+	case "warn", "info", "debug", "trace":
+	default:
+		return
+	}
+	if app.logWriter == nil {
+		return
+	}
+	fmt.Fprintf(app.logWriter, "[WARN] %s\n", fmt.Sprintf(format, args...))
+}
+
+// 秘密の情報を取り除くためにためているログを書き出す
+func (app *App) flushLog() {
+	if writer, ok := app.logWriter.(*redact.Writer); ok {
+		writer.Flush()
+	}
+}
@@ -2,10 +2,10 @@ package app
 
 import (
 	"errors"
-	"strings"
-	"text/template"
+	"fmt"
 
 	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/templates"
 )
 
 const DefaultApprovalPolicy = "never"
@@ -37,9 +37,63 @@ func (app *App) buildAgent(agentName string) (*agents.Agent, error) {
 		sandbox = DefaultSandbox
 	}
 
+	// 書き込めるディレクトリはサンドボックスで書き込みを許す場合のみ指定できる
+	if len(agentConfig.WritableRoots) > 0 && sandbox != "workspace-write" {
+		return nil, errors.New("writable_roots requires sandbox: workspace-write")
+	}
+
+	// ネットワークへのアクセスの解決
+	if err := applyNetwork(agentConfig.Network, sandbox, agentConfig.MCPServers, agentConfig.SubAgents, codexConfig); err != nil {
+		return nil, err
+	}
+
 	// MCP Servers の解決
 	for mcpServerName, mcpServerConfig := range agentConfig.MCPServers {
-		codexConfig["mcp_servers."+mcpServerName] = mcpServerConfig
+		codexConfig["mcp_servers."+mcpServerName] = mcpServerConfig.CodexConfig()
+	}
+
+	// 出力の検査の解決
+	outputChecks := make([]*agents.OutputCheckConfig, 0, len(agentConfig.OutputChecks))
+	for _, checkConfig := range agentConfig.OutputChecks {
+		outputChecks = append(outputChecks, &agents.OutputCheckConfig{
+			Name:    checkConfig.Name,
+			Expr:    checkConfig.Expr,
+			Message: checkConfig.Message,
+		})
+	}
+	outputCheckRetries := agents.DefaultOutputCheckRetries
+	if agentConfig.OutputCheckRetries != nil {
+		outputCheckRetries = *agentConfig.OutputCheckRetries
+	}
+
+	// 再試行の方針の解決
+	var retryPolicy *agents.RetryPolicy
+	retryConfig := app.config.Retry
+	if agentConfig.Retry != nil {
+		retryConfig = agentConfig.Retry
+	}
+	if retryConfig != nil {
+		var err error
+		retryPolicy, err = retryConfig.Policy()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 代替のモデルの解決
+	fallbackModels := make([]*agents.FallbackModel, 0, len(agentConfig.FallbackModels))
+	for _, fallbackModel := range agentConfig.FallbackModels {
+		fallbackModels = append(fallbackModels, &agents.FallbackModel{
+			Model:         fallbackModel.Model,
+			ModelProvider: fallbackModel.ModelProvider,
+		})
+	}
+
+	// セキュリティポリシーの検査
+	if app.policy != nil {
+		if err := app.policy.check(agentConfig, sandbox, approvalPolicy, codexConfig, fallbackModels); err != nil {
+			return nil, err
+		}
 	}
 
 	// サブエージェントの解決
@@ -59,30 +113,25 @@ func (app *App) buildAgent(agentName string) (*agents.Agent, error) {
 		})
 	}
 
-	// vars の値を適用
-	description := agentConfig.Description
-	instruction := agentConfig.Instruction
-	if app.config.Vars != nil {
-		descriptionTemplate, err := template.New("description").Parse(agentConfig.Description)
-		if err != nil {
-			return nil, err
-		}
-		descriptionBuilder := &strings.Builder{}
-		if err := descriptionTemplate.Execute(descriptionBuilder, app.config.Vars); err != nil {
-			return nil, err
-		}
-
-		instructionTemplate, err := template.New("instruction").Parse(agentConfig.Instruction)
-		if err != nil {
-			return nil, err
-		}
-		instructionBuilder := &strings.Builder{}
-		if err := instructionTemplate.Execute(instructionBuilder, app.config.Vars); err != nil {
-			return nil, err
-		}
+	// 共通のテンプレートを構文解析
+	templateSet, err := templates.ParseSet(app.config.Templates, app.config.MissingKey)
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
 
-		description = descriptionBuilder.String()
-		instruction = instructionBuilder.String()
+	// vars の値を適用
+	// instruction は入力と実行時の状況に応じて変わるので、実行時に展開する
+	descriptionTemplate, err := templates.ParseWith(templateSet, "description", agentConfig.Description)
+	if err != nil {
+		return nil, err
+	}
+	vars := app.config.Vars
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	description, err := templates.Execute(descriptionTemplate, "", vars)
+	if err != nil {
+		return nil, err
 	}
 
 	// エージェントのビルド
@@ -91,7 +140,7 @@ func (app *App) buildAgent(agentName string) (*agents.Agent, error) {
 		&agents.Config{
 			Name:           agentConfig.Name,
 			Description:    description,
-			Instruction:    instruction,
+			Instruction:    agentConfig.Instruction,
 			PromptTemplate: agentConfig.PromptTemplate,
 			InputSchema:    agentConfig.InputSchema,
 			OutputSchema:   agentConfig.OutputSchema,
@@ -99,6 +148,19 @@ func (app *App) buildAgent(agentName string) (*agents.Agent, error) {
 			Sandbox:        sandbox,
 			Config:         codexConfig,
 			SubAgents:      subAgents,
+			MissingKey:     app.config.MissingKey,
+			Templates:      templateSet,
+
+			OutputChecks:       outputChecks,
+			OutputCheckRetries: outputCheckRetries,
+
+			Retry:          retryPolicy,
+			FallbackModels: fallbackModels,
+			RepairModel:    agentConfig.RepairModel,
+
+			Workdir:       agentConfig.Workdir,
+			WritableRoots: agentConfig.WritableRoots,
+			ReadablePaths: agentConfig.ReadablePaths,
 		},
 	)
 	if err != nil {
@@ -107,3 +169,21 @@ func (app *App) buildAgent(agentName string) (*agents.Agent, error) {
 
 	return agent, nil
 }
+
+// エージェントをビルドして、定義に誤りがないか検証する
+func (app *App) ValidateAgent(agentName string) error {
+	agent, err := app.buildAgent(agentName)
+	if err != nil {
+		return err
+	}
+
+	// 入出力のスキーマが解決できるか
+	if _, err := agent.InputSchema.Resolve(nil); err != nil {
+		return fmt.Errorf("invalid input_schema: %w", err)
+	}
+	if _, err := agent.OutputSchema.Resolve(nil); err != nil {
+		return fmt.Errorf("invalid output_schema: %w", err)
+	}
+
+	return nil
+}
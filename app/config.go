@@ -1,7 +1,17 @@
 package app
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/google/jsonschema-go/jsonschema"
@@ -20,6 +30,29 @@ type Config struct {
 	// 変数名は英大文字のスネークケースを推奨（input_schema の展開と区別するため）
 	Vars map[string]any `yaml:"vars,omitempty"`
 
+	// 共通のテンプレート（パーシャル）
+	// Key がテンプレートの名前であり、各 AI エージェントの description、instruction、prompt_template で
+	// {{template "NAME" .}} の形式で展開できる。
+	Templates map[string]string `yaml:"templates,omitempty"`
+
+	// 共通のテンプレートを格納したディレクトリ
+	// 相対パスの場合は YAML ファイルのあるディレクトリからのパスとなる。
+	// ディレクトリ以下の各ファイルが、ディレクトリからの相対パスから拡張子を除いたものを名前とするテンプレートとなる。
+	// 例えば rules/research.md は {{template "rules/research" .}} で展開できる。
+	TemplatesDir string `yaml:"templates_dir,omitempty"`
+
+	// 一時的な失敗を再試行する方針
+	// すべての AI エージェントに適用する。エージェントに retry が定義されている場合は、そちらを優先する。
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+
+	// テンプレートで存在しないキーを参照したときの挙動
+	// default: <no value> と展開する、zero: ゼロ値で展開する、error: エラーにする
+	MissingKey string `yaml:"missing_key,omitempty"` // default: default
+
+	// ログと実行記録から秘密の情報を取り除く設定
+	// 定義しない場合も、組み込みのパターンと資格情報の値を取り除く。
+	Redaction *RedactionConfig `yaml:"redaction,omitempty"`
+
 	// AI エージェントの定義
 	// Key がエージェントの名前であり、CLI の実行時に指定する AGENT_NAME であり、
 	// sub_agents で指定するサブエージェント名でもある。
@@ -57,6 +90,38 @@ type AgentConfig struct {
 	// AI が参照するので、description を丁寧に書くことを推奨する。
 	OutputSchema map[string]*jsonschema.Schema `yaml:"output_schema"`
 
+	// 出力のテンプレート
+	// 定義されている場合、CLI からの実行結果は JSON 形式ではなく、このテンプレートに出力を展開したものになる。
+	// テンプレートの書式は prompt_template と同じく text/template のもの。
+	// output_schema が `{"report":{"type":"string"}}` であるとき、`{{.report}}` とすればレポートの本文だけを出力できる。
+	OutputTemplate string `yaml:"output_template,omitempty"`
+
+	// 出力にたいする検査
+	// 出力が検査を満たさない場合、理由を伝えて AI エージェントに回答をやり直させる。
+	OutputChecks []*OutputCheckConfig `yaml:"output_checks,omitempty"`
+
+	// 出力が検査を満たさないとき、回答をやり直させる回数
+	OutputCheckRetries *int `yaml:"output_check_retries,omitempty"` // default: 2
+
+	// 回答のキャッシュ
+	// 定義されている場合、同じエージェントの定義と入力で ttl 以内に得た回答があれば、Codex を実行せずにそれを返す。
+	Cache *CacheConfig `yaml:"cache,omitempty"`
+
+	// 一時的な失敗を再試行する方針
+	// 定義されている場合、トップレベルの retry の代わりに用いる。
+	Retry *RetryConfig `yaml:"retry,omitempty"`
+
+	// 主のモデルが失敗したときに順に試す代替のモデル
+	// 主のモデルで回答を得られないか、出力の検査を満たさない場合、同じプロンプトを次のモデルで試す。
+	// モデル名のみ（例: gpt-5-mini）か、model と model_provider を指定する。
+	FallbackModels []*FallbackModelConfig `yaml:"fallback_models,omitempty"`
+
+	// 回答を出力形式に合わせて整形するモデル
+	// Codex の回答が output_schema に従っていない場合、このモデルで回答を整形する。
+	// モデルプロバイダー（credential）の API で呼び出すので、そのプロバイダーで利用できるモデルを指定する。
+	// デフォルト値は gpt-5-nano
+	RepairModel string `yaml:"repair_model,omitempty"`
+
 	// Codex がユーザーの承認を求めるタイミング
 	// https://github.com/openai/codex/blob/main/docs/config.md#approval_policy を参照。
 	// デフォルト値は never
@@ -67,12 +132,37 @@ type AgentConfig struct {
 	// デフォルト値は read-only
 	Sandbox string `yaml:"sandbox"` // read-only, workspace-write, danger-full-access
 
+	// AI エージェントの作業ディレクトリ
+	// 呼び出し元の作業ディレクトリ（サブエージェントの場合は親のエージェントの作業ディレクトリ）からの相対パスで指定し、その外は指定できない。
+	// prompt_template と同じく、入力を展開するテンプレートとして書ける（例: `out/{{.id}}`）。存在しなければ作成する。
+	// 指定しない場合は呼び出し元と同じ作業ディレクトリで実行する。
+	Workdir string `yaml:"workdir,omitempty"`
+
+	// 作業ディレクトリのほかに書き込めるディレクトリ（作業ディレクトリからの相対パス）
+	// Codex の sandbox_workspace_write.writable_roots に対応し、sandbox が workspace-write の場合のみ指定できる。
+	WritableRoots []string `yaml:"writable_roots,omitempty"`
+
+	// 読み込めるファイルやディレクトリ（作業ディレクトリからの相対パス）
+	// ファイルの入力はこの範囲に限り、AI エージェントにもこの範囲の外を読み込まないよう指示する。
+	// Codex のサンドボックスは読み込みを制限できないので、AI エージェント自身の読み込みにたいしては指示のみとなる。
+	// 指定しない場合は制限しない。
+	ReadablePaths []string `yaml:"readable_paths,omitempty"`
+
+	// AI エージェントのネットワークへのアクセス
+	// none, mcp-only, allow のいずれか、もしくは mode と allowed_hosts を指定する。
+	// 指定しない場合は sandbox と config の指定に従う。
+	Network *NetworkConfig `yaml:"network,omitempty"`
+
 	// AI エージェントをサブエージェントとして実行したとき、タイムアウトする秒数
 	TimeoutSec int `yaml:"timeout_sec,omitempty"` // default: 1800
 
+	// 利用する資格情報のプロファイル名（ace setup --profile で登録したもの）
+	// 指定しない場合は Codex CLI のログイン状況か OpenAI の API Key を用いる。
+	Credential string `yaml:"credential,omitempty"`
+
 	// 利用する MCP Server の定義
 	// https://github.com/openai/codex/blob/main/docs/config.md#mcp_servers を参照。
-	MCPServers map[string]MCPServerConfig `yaml:"mcp_servers"`
+	MCPServers map[string]*MCPServerConfig `yaml:"mcp_servers"`
 
 	// 利用するサブエージェントのエージェント名のリスト
 	// サブエージェントを指定すると、同じ YAML ファイルに定義されている別の AI エージェントを
@@ -83,9 +173,432 @@ type AgentConfig struct {
 	// 詳細は https://github.com/openai/codex/blob/main/docs/config.md を参照。
 	// ここでは、この AI エージェントにのみ適用する Config を指定する。
 	Config agents.CodexConfig `yaml:"config,omitempty"`
+
+	// AI エージェントを MCP Server として利用するときの設定
+	MCP *MCPConfig `yaml:"mcp,omitempty"`
+}
+
+type OutputCheckConfig struct {
+	// 検査の名前
+	// 省略した場合は expr を名前とする。
+	Name string `yaml:"name,omitempty"`
+
+	// 検査の式
+	// text/template のパイプラインであり、出力をデータとして評価した値が空でなければ検査を満たす。
+	// 例えば `gt (len .items) 0` は items が空でないこと、`fileExists .path` は path のファイルが作業ディレクトリにあることを検査する。
+	Expr string `yaml:"expr"`
+
+	// 検査を満たさなかったときに、AI エージェントに伝える理由
+	Message string `yaml:"message,omitempty"`
+}
+
+type CacheConfig struct {
+	// キャッシュの有効期間（例: 30m、24h）
+	TTL string `yaml:"ttl,omitempty"` // default: 24h
+
+	// 作業ディレクトリのファイルの内容もキャッシュのキーに含めるか
+	// 作業ディレクトリのファイルが変わると、キャッシュを利用しない。
+	Workdir bool `yaml:"workdir,omitempty"`
+}
+
+// キャッシュの有効期間を返す
+func (config *CacheConfig) Duration() (time.Duration, error) {
+	if config.TTL == "" {
+		return DefaultCacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(config.TTL)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return ttl, nil
+}
+
+type FallbackModelConfig struct {
+	// モデル名
+	Model string `yaml:"model"`
+
+	// モデルプロバイダー
+	// 指定しない場合は主のモデルと同じプロバイダーを用いる。
+	ModelProvider string `yaml:"model_provider,omitempty"`
+}
+
+// モデル名のみの指定も受け付ける
+func (config *FallbackModelConfig) UnmarshalYAML(b []byte) error {
+	var model string
+	if err := yaml.Unmarshal(b, &model); err == nil {
+		config.Model = model
+		return nil
+	}
+
+	type rawFallbackModelConfig FallbackModelConfig
+	return yaml.UnmarshalWithOptions(b, (*rawFallbackModelConfig)(config), yaml.DisallowUnknownField())
+}
+
+type RetryConfig struct {
+	// 最初の試行を含む試行回数の上限
+	MaxAttempts int `yaml:"max_attempts,omitempty"` // default: 3
+
+	// 最初の再試行までの待ち時間（例: 500ms、2s）
+	InitialBackoff string `yaml:"initial_backoff,omitempty"` // default: 1s
+
+	// 待ち時間の上限
+	MaxBackoff string `yaml:"max_backoff,omitempty"` // default: 30s
+
+	// 再試行のたびに待ち時間に掛ける倍率
+	Multiplier float64 `yaml:"multiplier,omitempty"` // default: 2
+
+	// 待ち時間をランダムに増減させる割合（0 から 1）
+	Jitter *float64 `yaml:"jitter,omitempty"` // default: 0.2
+
+	// 再試行する失敗の種類
+	// default: [rate_limit, server_error, timeout]
+	RetryOn []string `yaml:"retry_on,omitempty"` // rate_limit, server_error, timeout, output_invalid
+
+	// 各試行の時間の上限。超えた試行は timeout として扱う
+	AttemptTimeout string `yaml:"attempt_timeout,omitempty"` // default: 30m
+}
+
+// 再試行の方針に変換する
+func (config *RetryConfig) Policy() (*agents.RetryPolicy, error) {
+	policy := &agents.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryOn:        []string{agents.RetryOnRateLimit, agents.RetryOnServerError, agents.RetryOnTimeout},
+		AttemptTimeout: 30 * time.Minute,
+	}
+
+	if config.MaxAttempts < 0 {
+		return nil, errors.New("max_attempts must not be negative")
+	}
+	if config.MaxAttempts > 0 {
+		policy.MaxAttempts = config.MaxAttempts
+	}
+
+	if config.InitialBackoff != "" {
+		backoff, err := time.ParseDuration(config.InitialBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("initial_backoff: %w", err)
+		}
+		policy.InitialBackoff = backoff
+	}
+
+	if config.MaxBackoff != "" {
+		backoff, err := time.ParseDuration(config.MaxBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("max_backoff: %w", err)
+		}
+		policy.MaxBackoff = backoff
+	}
+
+	if config.Multiplier < 0 {
+		return nil, errors.New("multiplier must not be negative")
+	}
+	if config.Multiplier > 0 {
+		policy.Multiplier = config.Multiplier
+	}
+
+	if config.Jitter != nil {
+		if *config.Jitter < 0 || *config.Jitter > 1 {
+			return nil, errors.New("jitter must be between 0 and 1")
+		}
+		policy.Jitter = *config.Jitter
+	}
+
+	if config.RetryOn != nil {
+		for _, class := range config.RetryOn {
+			switch class {
+			case agents.RetryOnRateLimit, agents.RetryOnServerError, agents.RetryOnTimeout, agents.RetryOnOutputInvalid:
+			default:
+				return nil, errors.New("unknown retry_on: " + class)
+			}
+		}
+		policy.RetryOn = config.RetryOn
+	}
+
+	if config.AttemptTimeout != "" {
+		timeout, err := time.ParseDuration(config.AttemptTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("attempt_timeout: %w", err)
+		}
+		if timeout <= 0 {
+			return nil, errors.New("attempt_timeout must be positive")
+		}
+		policy.AttemptTimeout = timeout
+	}
+
+	return policy, nil
+}
+
+type RedactionConfig struct {
+	// ログと実行記録から秘密の情報を取り除くか
+	Enabled *bool `yaml:"enabled,omitempty"` // default: true
+
+	// 組み込みの API Key のパターンと資格情報の値に加えて取り除く文字列の正規表現（例: メールアドレス）
+	Patterns []string `yaml:"patterns,omitempty"`
+
+	// AI エージェントの出力からも取り除くか
+	Outputs bool `yaml:"outputs,omitempty"`
+}
+
+// patterns を構文解析した正規表現を返す
+func (config *RedactionConfig) Regexps() ([]*regexp.Regexp, error) {
+	regexps := make([]*regexp.Regexp, 0, len(config.Patterns))
+	for _, pattern := range config.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+type NetworkConfig struct {
+	// ネットワークへのアクセスの方針
+	// none: ネットワークにアクセスしない（MCP Server とサブエージェントも利用できない）
+	// mcp-only: MCP Server とサブエージェントを介してのみアクセスする
+	// allow: サンドボックスの中のコマンドからもアクセスする
+	Mode string `yaml:"mode"` // none, mcp-only, allow
+
+	// url で指定する MCP Server のホスト名のリスト（例: api.github.com、*.example.com）
+	// 指定した場合、MCP Server のホストはこのリストに含まれなければならない。
+	// サンドボックスの中のコマンドのアクセスは制限できないので、mcp-only でのみ指定できる。
+	AllowedHosts []string `yaml:"allowed_hosts,omitempty"`
+}
+
+// 方針のみの指定も受け付ける
+func (config *NetworkConfig) UnmarshalYAML(b []byte) error {
+	var mode string
+	if err := yaml.Unmarshal(b, &mode); err == nil {
+		config.Mode = mode
+		return nil
+	}
+
+	type rawNetworkConfig NetworkConfig
+	return yaml.UnmarshalWithOptions(b, (*rawNetworkConfig)(config), yaml.DisallowUnknownField())
+}
+
+// ネットワークへのアクセスの定義が正しいか検証する
+func (config *NetworkConfig) Validate() error {
+	switch config.Mode {
+	case NetworkNone, NetworkMCPOnly, NetworkAllow:
+	default:
+		return errors.New("invalid mode: " + config.Mode)
+	}
+
+	// allowed_hosts は MCP Server のホストのみを制限するので、mcp-only 以外では誤解を招く
+	if len(config.AllowedHosts) > 0 && config.Mode != NetworkMCPOnly {
+		return fmt.Errorf("allowed_hosts is only available with mode %s", NetworkMCPOnly)
+	}
+
+	for _, host := range config.AllowedHosts {
+		if strings.TrimPrefix(host, "*.") == "" || strings.ContainsAny(host, "/:") {
+			return errors.New("invalid allowed_hosts: " + host)
+		}
+	}
+
+	return nil
 }
 
-type MCPServerConfig map[string]any
+type MCPServerConfig struct {
+	// STDIO 形式の MCP Server を起動するコマンド
+	Command string `yaml:"command,omitempty"`
+
+	// コマンドの引数
+	Args []string `yaml:"args,omitempty"`
+
+	// コマンドに与える環境変数
+	Env map[string]string `yaml:"env,omitempty"`
+
+	// コマンドを実行するディレクトリ
+	Cwd string `yaml:"cwd,omitempty"`
+
+	// Streamable HTTP 形式の MCP Server の URL
+	// command とどちらか一方を指定する。
+	URL string `yaml:"url,omitempty"`
+
+	// MCP Server へのリクエストに付与する HTTP ヘッダー
+	HTTPHeaders map[string]string `yaml:"http_headers,omitempty"`
+
+	// Bearer トークンを格納している環境変数の名前
+	BearerTokenEnvVar string `yaml:"bearer_token_env_var,omitempty"`
+
+	// AI エージェントに提供するツール名のリスト
+	// 指定しない場合は、MCP Server が提供するすべてのツールを提供する。
+	EnabledTools []string `yaml:"enabled_tools,omitempty"`
+
+	// AI エージェントに提供しないツール名のリスト
+	DisabledTools []string `yaml:"disabled_tools,omitempty"`
+
+	// MCP Server の起動を待つ秒数
+	StartupTimeoutSec int `yaml:"startup_timeout_sec,omitempty"`
+
+	// ツールの実行を待つ秒数
+	ToolTimeoutSec int `yaml:"tool_timeout_sec,omitempty"`
+
+	// MCP Server を有効にするかどうか
+	// false の場合、Codex は MCP Server を起動しない。デフォルト値は true
+	Enabled *bool `yaml:"enabled,omitempty"`
+
+	// コマンドにそのまま引き継ぐ環境変数の名前のリスト
+	EnvVars []string `yaml:"env_vars,omitempty"`
+
+	// 環境変数の値を付与する HTTP ヘッダー
+	// キーは HTTP ヘッダーの名前、値は環境変数の名前
+	EnvHTTPHeaders map[string]string `yaml:"env_http_headers,omitempty"`
+
+	// 上記以外の項目
+	// Codex CLI の config.toml の mcp_servers.NAME にそのまま与える。
+	Extra map[string]any `yaml:"-"`
+}
+
+// MCPServerConfig のフィールドとして読み込む項目の名前
+var mcpServerConfigKeys = func() []string {
+	t := reflect.TypeFor[MCPServerConfig]()
+	keys := []string{}
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}()
+
+// 型の誤りを検出するため、MCP Server の定義は厳格に読み込む
+// 未知の項目は Codex CLI の設定として Extra に残す。
+func (config *MCPServerConfig) UnmarshalYAML(b []byte) error {
+	type rawMCPServerConfig MCPServerConfig
+	if err := yaml.Unmarshal(b, (*rawMCPServerConfig)(config)); err != nil {
+		return err
+	}
+
+	fields := map[string]any{}
+	if err := yaml.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for key, value := range fields {
+		if slices.Contains(mcpServerConfigKeys, key) {
+			continue
+		}
+		if config.Extra == nil {
+			config.Extra = map[string]any{}
+		}
+		config.Extra[key] = value
+	}
+
+	return nil
+}
+
+// MCP Server の定義が正しいか検証する
+func (config *MCPServerConfig) Validate() error {
+	switch {
+	case config.Command == "" && config.URL == "":
+		return errors.New("either command or url is required")
+
+	case config.Command != "" && config.URL != "":
+		return errors.New("command and url cannot be specified at the same time")
+
+	case config.URL != "" && (len(config.Args) > 0 || len(config.Env) > 0 || len(config.EnvVars) > 0 || config.Cwd != ""):
+		return errors.New("args, env, env_vars and cwd are only available with command")
+
+	case config.Command != "" && (len(config.HTTPHeaders) > 0 || len(config.EnvHTTPHeaders) > 0 || config.BearerTokenEnvVar != ""):
+		return errors.New("http_headers, env_http_headers and bearer_token_env_var are only available with url")
+
+	case config.StartupTimeoutSec < 0 || config.ToolTimeoutSec < 0:
+		return errors.New("timeouts must not be negative")
+	}
+
+	if config.URL != "" {
+		u, err := url.Parse(config.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("invalid url: " + config.URL)
+		}
+	}
+
+	for _, toolName := range config.DisabledTools {
+		if slices.Contains(config.EnabledTools, toolName) {
+			return errors.New("tool is both enabled and disabled: " + toolName)
+		}
+	}
+
+	return nil
+}
+
+// Codex CLI の config.toml の mcp_servers.NAME に与える値を返す
+func (config *MCPServerConfig) CodexConfig() map[string]any {
+	codexConfig := map[string]any{}
+	if config.Command != "" {
+		codexConfig["command"] = config.Command
+	}
+	if len(config.Args) > 0 {
+		codexConfig["args"] = config.Args
+	}
+	if len(config.Env) > 0 {
+		codexConfig["env"] = config.Env
+	}
+	if config.Cwd != "" {
+		codexConfig["cwd"] = config.Cwd
+	}
+	if config.URL != "" {
+		codexConfig["url"] = config.URL
+	}
+	if len(config.HTTPHeaders) > 0 {
+		codexConfig["http_headers"] = config.HTTPHeaders
+	}
+	if config.BearerTokenEnvVar != "" {
+		codexConfig["bearer_token_env_var"] = config.BearerTokenEnvVar
+	}
+	if config.EnabledTools != nil {
+		codexConfig["enabled_tools"] = config.EnabledTools
+	}
+	if len(config.DisabledTools) > 0 {
+		codexConfig["disabled_tools"] = config.DisabledTools
+	}
+	if config.StartupTimeoutSec > 0 {
+		codexConfig["startup_timeout_sec"] = config.StartupTimeoutSec
+	}
+	if config.ToolTimeoutSec > 0 {
+		codexConfig["tool_timeout_sec"] = config.ToolTimeoutSec
+	}
+	if config.Enabled != nil {
+		codexConfig["enabled"] = *config.Enabled
+	}
+	if len(config.EnvVars) > 0 {
+		codexConfig["env_vars"] = config.EnvVars
+	}
+	if len(config.EnvHTTPHeaders) > 0 {
+		codexConfig["env_http_headers"] = config.EnvHTTPHeaders
+	}
+	for key, value := range config.Extra {
+		codexConfig[key] = value
+	}
+	return codexConfig
+}
+
+type MCPConfig struct {
+	// ツールの結果に含めるテキストのテンプレート
+	// 出力を text/template で展開したものを、構造化された出力とあわせて MCP Client に返す。
+	// 空文字列の場合は、出力を JSON 形式にしたものを返す。
+	SummaryTemplate string `yaml:"summary_template,omitempty"`
+
+	// AI エージェントが作成したファイルを MCP Client に返す方法
+	// output_schema で format: file もしくは format: image が指定されたプロパティのパスのファイルを、
+	// link ならリソースリンクとして、embed なら埋め込みリソースとして返す。none なら返さない。
+	// デフォルト値は link
+	Files string `yaml:"files,omitempty"` // none, link, embed
+
+	// map_NAME ツールで AI エージェントを並列に実行する数
+	// 1 以上の場合、入力の配列を受け取って AI エージェントを並列に実行し、出力の配列を返す map_NAME ツールもあわせて提供する。
+	// サブエージェントに指定すると、呼び出し元の AI エージェントは複数の入力を一度に処理させることができる。
+	Parallelism int `yaml:"parallelism,omitempty"`
+}
 
 func LoadConfig(path string) (*Config, error) {
 	f, err := os.OpenFile(path, 0, os.FileMode(os.O_RDONLY))
@@ -106,5 +619,125 @@ func LoadConfig(path string) (*Config, error) {
 		agentConfig.Name = name
 	}
 
+	// 共通のテンプレートをディレクトリから読み込む
+	if config.TemplatesDir != "" {
+		templatesDir := config.TemplatesDir
+		if !filepath.IsAbs(templatesDir) {
+			templatesDir = filepath.Join(filepath.Dir(path), templatesDir)
+		}
+		if err := loadTemplatesDir(&config, templatesDir); err != nil {
+			return nil, fmt.Errorf("templates_dir: %w", err)
+		}
+	}
+
+	// 出力の検査の定義を検証
+	for name, agentConfig := range config.Agents {
+		for i, checkConfig := range agentConfig.OutputChecks {
+			if checkConfig == nil || checkConfig.Expr == "" {
+				return nil, fmt.Errorf("agents.%s.output_checks[%d]: expr is required", name, i)
+			}
+			if checkConfig.Name == "" {
+				checkConfig.Name = checkConfig.Expr
+			}
+		}
+		if agentConfig.OutputCheckRetries != nil && *agentConfig.OutputCheckRetries < 0 {
+			return nil, fmt.Errorf("agents.%s.output_check_retries: must not be negative", name)
+		}
+	}
+
+	// 代替のモデルの定義を検証
+	for name, agentConfig := range config.Agents {
+		for i, fallbackModel := range agentConfig.FallbackModels {
+			if fallbackModel == nil || fallbackModel.Model == "" {
+				return nil, fmt.Errorf("agents.%s.fallback_models[%d]: model is required", name, i)
+			}
+		}
+	}
+
+	// 秘密の情報を取り除く定義を検証
+	if config.Redaction != nil {
+		if _, err := config.Redaction.Regexps(); err != nil {
+			return nil, fmt.Errorf("redaction.patterns: %w", err)
+		}
+	}
+
+	// 再試行の方針を検証
+	if config.Retry != nil {
+		if _, err := config.Retry.Policy(); err != nil {
+			return nil, fmt.Errorf("retry: %w", err)
+		}
+	}
+	for name, agentConfig := range config.Agents {
+		if agentConfig.Retry != nil {
+			if _, err := agentConfig.Retry.Policy(); err != nil {
+				return nil, fmt.Errorf("agents.%s.retry: %w", name, err)
+			}
+		}
+	}
+
+	// キャッシュの定義を検証
+	for name, agentConfig := range config.Agents {
+		if agentConfig.Cache != nil {
+			if _, err := agentConfig.Cache.Duration(); err != nil {
+				return nil, fmt.Errorf("agents.%s.cache.ttl: %w", name, err)
+			}
+		}
+	}
+
+	// ネットワークへのアクセスの定義を検証
+	for name, agentConfig := range config.Agents {
+		if agentConfig.Network != nil {
+			if err := agentConfig.Network.Validate(); err != nil {
+				return nil, fmt.Errorf("agents.%s.network: %w", name, err)
+			}
+		}
+	}
+
+	// MCP Server の定義を検証
+	for name, agentConfig := range config.Agents {
+		for mcpServerName, mcpServerConfig := range agentConfig.MCPServers {
+			if mcpServerConfig == nil {
+				return nil, fmt.Errorf("agents.%s.mcp_servers.%s: definition is empty", name, mcpServerName)
+			}
+			if err := mcpServerConfig.Validate(); err != nil {
+				return nil, fmt.Errorf("agents.%s.mcp_servers.%s: %w", name, mcpServerName, err)
+			}
+		}
+	}
+
 	return &config, nil
 }
+
+// ディレクトリ以下のファイルを共通のテンプレートとして読み込む
+// templates に同じ名前のテンプレートが定義されている場合はエラーとする
+func loadTemplatesDir(config *Config, dir string) error {
+	if config.Templates == nil {
+		config.Templates = map[string]string{}
+	}
+
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+		if _, ok := config.Templates[name]; ok {
+			return errors.New("template is defined more than once: " + name)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		config.Templates[name] = string(b)
+
+		return nil
+	})
+}
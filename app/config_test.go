@@ -0,0 +1,170 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+func TestMCPServerConfigUnmarshalYAML(t *testing.T) {
+	enabled := false
+
+	tests := []struct {
+		name    string
+		yaml    string
+		want    *MCPServerConfig
+		wantErr bool
+	}{
+		{
+			name: "known keys",
+			yaml: "command: uvx\nargs: [a]\nenabled: false\nenv_vars: [HOME]\n",
+			want: &MCPServerConfig{Command: "uvx", Args: []string{"a"}, Enabled: &enabled, EnvVars: []string{"HOME"}},
+		},
+		{
+			name: "unknown keys",
+			yaml: "url: https://mcp.example.com/mcp\nenv_http_headers: {X-Api-Key: API_KEY}\nstartup_timeout_ms: 20000\noauth: {scopes: [read]}\n",
+			want: &MCPServerConfig{
+				URL:            "https://mcp.example.com/mcp",
+				EnvHTTPHeaders: map[string]string{"X-Api-Key": "API_KEY"},
+				Extra:          map[string]any{"startup_timeout_ms": uint64(20000), "oauth": map[string]any{"scopes": []any{"read"}}},
+			},
+		},
+		{
+			name:    "type error",
+			yaml:    "command: uvx\nargs: a\n",
+			wantErr: true,
+		},
+		{
+			name:    "type error of modeled key",
+			yaml:    "command: uvx\nenabled: [true]\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &MCPServerConfig{}
+			err := yaml.Unmarshal([]byte(tt.yaml), got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMCPServerConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *MCPServerConfig
+		wantErr bool
+	}{
+		{name: "command", config: &MCPServerConfig{Command: "uvx", EnvVars: []string{"HOME"}}},
+		{name: "url", config: &MCPServerConfig{URL: "https://mcp.example.com/mcp", EnvHTTPHeaders: map[string]string{"X-Api-Key": "API_KEY"}}},
+		{name: "neither", config: &MCPServerConfig{}, wantErr: true},
+		{name: "both", config: &MCPServerConfig{Command: "uvx", URL: "https://mcp.example.com/mcp"}, wantErr: true},
+		{name: "env_vars with url", config: &MCPServerConfig{URL: "https://mcp.example.com/mcp", EnvVars: []string{"HOME"}}, wantErr: true},
+		{name: "env_http_headers with command", config: &MCPServerConfig{Command: "uvx", EnvHTTPHeaders: map[string]string{"X-Api-Key": "API_KEY"}}, wantErr: true},
+		{name: "invalid url", config: &MCPServerConfig{URL: "file:///etc/passwd"}, wantErr: true},
+		{name: "negative timeout", config: &MCPServerConfig{Command: "uvx", ToolTimeoutSec: -1}, wantErr: true},
+		{name: "enabled and disabled tool", config: &MCPServerConfig{Command: "uvx", EnabledTools: []string{"a"}, DisabledTools: []string{"a"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMCPServerConfigCodexConfig(t *testing.T) {
+	enabled := false
+	config := &MCPServerConfig{
+		Command:           "uvx",
+		Args:              []string{"a"},
+		EnabledTools:      []string{},
+		StartupTimeoutSec: 20,
+		Enabled:           &enabled,
+		EnvVars:           []string{"HOME"},
+		Extra:             map[string]any{"startup_timeout_ms": uint64(20000)},
+	}
+	want := map[string]any{
+		"command":             "uvx",
+		"args":                []string{"a"},
+		"enabled_tools":       []string{},
+		"startup_timeout_sec": 20,
+		"enabled":             false,
+		"env_vars":            []string{"HOME"},
+		"startup_timeout_ms":  uint64(20000),
+	}
+
+	if got := config.CodexConfig(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CodexConfig() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadConfigTemplatesDir(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeFile := func(path string, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(filepath.Join(dir, "partials", "rules", "research.md"), "research rules")
+	writeFile(filepath.Join(dir, "partials", "greeting.txt"), "hello")
+	writeFile(filepath.Join(outside, "secret.txt"), "secret")
+	// シンボリックリンクはテンプレートとして読み込まない
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "partials", "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		yaml    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "relative to the YAML file",
+			yaml: "templates_dir: partials\ntemplates:\n  footer: bye\nagents:\n  root:\n    instruction: i\n",
+			want: map[string]string{"footer": "bye", "rules/research": "research rules", "greeting": "hello"},
+		},
+		{
+			name:    "defined more than once",
+			yaml:    "templates_dir: partials\ntemplates:\n  greeting: hi\nagents:\n  root:\n    instruction: i\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing directory",
+			yaml:    "templates_dir: missing\nagents:\n  root:\n    instruction: i\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "ace.yaml")
+			writeFile(path, tt.yaml)
+
+			config, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(config.Templates, tt.want) {
+				t.Errorf("Templates = %v, want %v", config.Templates, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/kurusugawa-computer/ace/agents"
+)
+
+// ファイルを表すスキーマか判定する
+// format で file もしくは image が指定されているか、contentMediaType が指定されているもの
+func isFileSchema(schema *jsonschema.Schema) bool {
+	return schema.Format == "file" || schema.Format == "image" || schema.ContentMediaType != ""
+}
+
+// 画像ファイルを表すスキーマか判定する
+func isImageSchema(schema *jsonschema.Schema) bool {
+	return schema.Format == "image" || strings.HasPrefix(schema.ContentMediaType, "image/")
+}
+
+// ファイルを表す入力のパスが作業ディレクトリ以下に存在し、エージェントが読み込めることを確認し、
+// Codex に確認させる画像ファイルの絶対パスを返す
+// ファイルのパスは workdirAbsPath からの相対パスとし、readable_paths は agentWorkdir からの相対パスとする
+func checkFileInputs(agent *agents.Agent, workdirAbsPath string, agentWorkdir string, input map[string]any) ([]string, error) {
+	images := []string{}
+	for propName, propSchema := range agent.InputSchema.Properties {
+		for key, value := range fileValues(propName, input[propName], propSchema) {
+			filePath, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("specified value is incompatible with the input_schema definition: %s", key)
+			}
+
+			absPath, err := resolveWorkdirPath(workdirAbsPath, filePath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			info, err := os.Stat(absPath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			if info.IsDir() {
+				return nil, fmt.Errorf("%s: is a directory: %s", key, filePath)
+			}
+			if !agent.IsReadable(agentWorkdir, absPath) {
+				return nil, fmt.Errorf("%s: not in the readable_paths of agent %s: %s", key, agent.Name, filePath)
+			}
+
+			if isImageSchema(schemaOf(propSchema)) {
+				images = append(images, absPath)
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// ファイルを表す入力のうち、相対パスで指定されたものを返す
+func fileInputPaths(agent *agents.Agent, input map[string]any) []string {
+	paths := []string{}
+	for propName, propSchema := range agent.InputSchema.Properties {
+		for _, value := range fileValues(propName, input[propName], propSchema) {
+			if filePath, ok := value.(string); ok && !filepath.IsAbs(filePath) {
+				paths = append(paths, filePath)
+			}
+		}
+	}
+	return paths
+}
+
+// ファイルを表す入力のパスを、workdirAbsPath からの相対パスから agentWorkdir からの相対パスに置き換える
+// 絶対パスはそのままとする
+func relocateFileInputs(agent *agents.Agent, workdirAbsPath string, agentWorkdir string, input map[string]any) error {
+	relocate := func(value any) (any, error) {
+		filePath, ok := value.(string)
+		if !ok || filepath.IsAbs(filePath) {
+			return value, nil
+		}
+		rel, err := filepath.Rel(agentWorkdir, filepath.Join(workdirAbsPath, filePath))
+		if err != nil {
+			return nil, err
+		}
+		return filepath.ToSlash(rel), nil
+	}
+
+	for propName, propSchema := range agent.InputSchema.Properties {
+		value := input[propName]
+		switch {
+		case value == nil:
+
+		case isFileSchema(propSchema):
+			relocated, err := relocate(value)
+			if err != nil {
+				return err
+			}
+			input[propName] = relocated
+
+		case propSchema.Type == "array" && propSchema.Items != nil && isFileSchema(propSchema.Items):
+			items, _ := value.([]any)
+			relocatedItems := make([]any, 0, len(items))
+			for _, item := range items {
+				relocated, err := relocate(item)
+				if err != nil {
+					return err
+				}
+				relocatedItems = append(relocatedItems, relocated)
+			}
+			input[propName] = relocatedItems
+		}
+	}
+
+	return nil
+}
+
+// ファイルを表す値を、プロパティ名をキーとして返す
+// 配列の場合は items がファイルを表すとき、各要素を返す
+func fileValues(key string, value any, schema *jsonschema.Schema) map[string]any {
+	values := map[string]any{}
+	switch {
+	case value == nil:
+
+	case isFileSchema(schema):
+		values[key] = value
+
+	case schema.Type == "array" && schema.Items != nil && isFileSchema(schema.Items):
+		items, _ := value.([]any)
+		for i, item := range items {
+			values[fmt.Sprintf("%s[%d]", key, i)] = item
+		}
+	}
+
+	return values
+}
+
+// 配列の場合は items のスキーマを返す
+func schemaOf(schema *jsonschema.Schema) *jsonschema.Schema {
+	if schema.Type == "array" && schema.Items != nil {
+		return schema.Items
+	}
+	return schema
+}
+
+// 作業ディレクトリからの相対パス、もしくは絶対パスを作業ディレクトリ以下の絶対パスに解決する
+func resolveWorkdirPath(workdirAbsPath string, filePath string) (string, error) {
+	absPath := filePath
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(workdirAbsPath, filePath)
+	}
+	absPath = filepath.Clean(absPath)
+
+	if !isUnder(workdirAbsPath, absPath) {
+		return "", errors.New("path is outside of the working directory: " + filePath)
+	}
+
+	// シンボリックリンクで作業ディレクトリの外を指していないか
+	realWorkdir, err := filepath.EvalSymlinks(workdirAbsPath)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", err
+	}
+	if !isUnder(realWorkdir, realPath) {
+		return "", errors.New("path is outside of the working directory: " + filePath)
+	}
+
+	return absPath, nil
+}
+
+// path が dir 以下にあるか判定する
+func isUnder(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// MCP Client から受け取るファイルの入力のスキーマ
+// 作業ディレクトリからの相対パスに加えて、画像（ImageContent）と埋め込みリソース（EmbeddedResource）を受け付ける。
+func contentInputSchema(schema *jsonschema.Schema) *jsonschema.Schema {
+	if !isFileSchema(schema) {
+		if schema.Type == "array" && schema.Items != nil && isFileSchema(schema.Items) {
+			copied := *schema
+			copied.Items = contentInputSchema(schema.Items)
+			return &copied
+		}
+		return schema
+	}
+
+	return &jsonschema.Schema{
+		Description: schema.Description,
+		AnyOf: []*jsonschema.Schema{
+			schema,
+			{
+				Type:        "object",
+				Description: "base64 でエンコードされた画像",
+				Required:    []string{"type", "data", "mimeType"},
+				Properties: map[string]*jsonschema.Schema{
+					"type":     {Type: "string", Const: jsonschema.Ptr[any]("image")},
+					"data":     {Type: "string", ContentEncoding: "base64"},
+					"mimeType": {Type: "string"},
+				},
+			},
+			{
+				Type:        "object",
+				Description: "埋め込みリソース",
+				Required:    []string{"type", "resource"},
+				Properties: map[string]*jsonschema.Schema{
+					"type": {Type: "string", Const: jsonschema.Ptr[any]("resource")},
+					"resource": {
+						Type:     "object",
+						Required: []string{"uri"},
+						Properties: map[string]*jsonschema.Schema{
+							"uri":      {Type: "string"},
+							"mimeType": {Type: "string"},
+							"text":     {Type: "string"},
+							"blob":     {Type: "string", ContentEncoding: "base64"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// MCP Client から受け取った画像や埋め込みリソースを dir 以下にファイルとして書き出し、
+// 入力の値を作業ディレクトリからの相対パスに置き換える
+func materializeContentInputs(agent *agents.Agent, workdirAbsPath string, dir string, input map[string]any) error {
+	count := 0
+	materialize := func(key string, value any) (any, error) {
+		content, ok := value.(map[string]any)
+		if !ok {
+			return value, nil
+		}
+
+		var data []byte
+		var mimeType string
+		var name string
+		switch content["type"] {
+		case "image":
+			encoded, _ := content["data"].(string)
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid image data: %w", key, err)
+			}
+			data = decoded
+			mimeType, _ = content["mimeType"].(string)
+
+		case "resource":
+			resource, _ := content["resource"].(map[string]any)
+			if blob, ok := resource["blob"].(string); ok {
+				decoded, err := base64.StdEncoding.DecodeString(blob)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid resource blob: %w", key, err)
+				}
+				data = decoded
+			} else {
+				text, _ := resource["text"].(string)
+				data = []byte(text)
+			}
+			mimeType, _ = resource["mimeType"].(string)
+			uri, _ := resource["uri"].(string)
+			name = path.Base(uri)
+
+		default:
+			return nil, fmt.Errorf("specified value is incompatible with the input_schema definition: %s", key)
+		}
+
+		// ファイル名を決める
+		count++
+		if name == "" || name == "." || name == "/" {
+			name = "input"
+		}
+		if path.Ext(name) == "" {
+			if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+				name += exts[0]
+			}
+		}
+		filePath := filepath.Join(dir, fmt.Sprintf("%d-%s", count, filepath.Base(name)))
+
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filePath, data, 0o644); err != nil {
+			return nil, err
+		}
+
+		rel, err := filepath.Rel(workdirAbsPath, filePath)
+		if err != nil {
+			return nil, err
+		}
+		return filepath.ToSlash(rel), nil
+	}
+
+	for propName, propSchema := range agent.InputSchema.Properties {
+		value := input[propName]
+		switch {
+		case value == nil:
+
+		case isFileSchema(propSchema):
+			materialized, err := materialize(propName, value)
+			if err != nil {
+				return err
+			}
+			input[propName] = materialized
+
+		case propSchema.Type == "array" && propSchema.Items != nil && isFileSchema(propSchema.Items):
+			items, _ := value.([]any)
+			for i, item := range items {
+				materialized, err := materialize(fmt.Sprintf("%s[%d]", propName, i), item)
+				if err != nil {
+					return err
+				}
+				items[i] = materialized
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveWorkdirPath(t *testing.T) {
+	workdir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workdir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(workdir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(workdir, "inner.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filePath string
+		want     string
+		wantErr  bool
+	}{
+		{name: "relative", filePath: "a.txt", want: filepath.Join(workdir, "a.txt")},
+		{name: "absolute", filePath: filepath.Join(workdir, "a.txt"), want: filepath.Join(workdir, "a.txt")},
+		{name: "symlink inside", filePath: "inner.txt", want: filepath.Join(workdir, "inner.txt")},
+		{name: "parent", filePath: "../a.txt", wantErr: true},
+		{name: "absolute outside", filePath: filepath.Join(outside, "secret.txt"), wantErr: true},
+		{name: "symlink outside", filePath: "link.txt", wantErr: true},
+		{name: "not exist", filePath: "missing.txt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveWorkdirPath(workdir, tt.filePath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveWorkdirPath(%q) error = %v, wantErr %v", tt.filePath, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("resolveWorkdirPath(%q) = %q, want %q", tt.filePath, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,11 +2,28 @@ package app
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
 
+	"github.com/google/jsonschema-go/jsonschema"
 	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/kurusugawa-computer/ace/templates"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
+// MCP Client が会話を継続するために指定するセッションIDの入力名
+const SessionIDInputName = "session_id"
+
 func (app *App) RunMCPServer(agentName string, workdir string) error {
 	// エージェントのビルド
 	agent, err := app.buildAgent(agentName)
@@ -14,6 +31,40 @@ func (app *App) RunMCPServer(agentName string, workdir string) error {
 		return err
 	}
 
+	// 作業ディレクトリの絶対パスを取得
+	workdirAbsPath, err := filepath.Abs(workdir)
+	if err != nil {
+		return err
+	}
+
+	// 入力スキーマに任意入力のセッションIDを追加
+	// ファイルの入力は、パスに加えて画像や埋め込みリソースも受け付ける
+	inputSchema := *agent.InputSchema
+	inputSchema.Properties = maps.Clone(agent.InputSchema.Properties)
+	for propName, propSchema := range inputSchema.Properties {
+		inputSchema.Properties[propName] = contentInputSchema(propSchema)
+	}
+	inputSchema.Properties[SessionIDInputName] = &jsonschema.Schema{
+		Type:        "string",
+		Description: "任意のセッションID。以前の呼び出しと同じセッションIDを指定すると、その続きとして会話する。",
+	}
+
+	// MCP Server としての設定
+	mcpConfig := &MCPConfig{}
+	if agentConfig := app.config.Agents[agentName]; agentConfig.MCP != nil {
+		mcpConfig = agentConfig.MCP
+	}
+	switch mcpConfig.Files {
+	case "", "none", "link", "embed":
+	default:
+		return errors.New("invalid mcp.files: " + mcpConfig.Files)
+	}
+
+	// セッションIDごとの最新の実行ID
+	// セッションIDは MCP Client が任意に決めるので、このプロセスの中でのみ有効とする。
+	sessions := map[string]string{}
+	sessionsMutex := &sync.Mutex{}
+
 	// MCP Serverを構築
 	server := mcp.NewServer(
 		&mcp.Implementation{
@@ -22,43 +73,94 @@ func (app *App) RunMCPServer(agentName string, workdir string) error {
 		},
 		&mcp.ServerOptions{},
 	)
-	mcp.AddTool(
-		server,
+	resolvedInputSchema, err := inputSchema.Resolve(nil)
+	if err != nil {
+		return err
+	}
+	server.AddTool(
 		&mcp.Tool{
 			Name:         agent.Name,
 			Description:  agent.Description,
-			InputSchema:  agent.InputSchema,
+			InputSchema:  &inputSchema,
 			OutputSchema: agent.OutputSchema,
 		},
-		func(ctx context.Context, request *mcp.CallToolRequest, input map[string]any) (*mcp.CallToolResult, any, error) {
-			// vars の値を展開する
-			if app.config.Vars != nil {
-				for key, value := range app.config.Vars {
-					if _, ok := input[key]; ok {
-						continue
-					}
-					input[key] = value
+		func(ctx context.Context, request *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			// 入力のパースと検証
+			// 不正な入力はプロトコルエラーではなく、ツールのエラーとして返す
+			input := map[string]any{}
+			if len(request.Params.Arguments) > 0 {
+				if err := json.Unmarshal(request.Params.Arguments, &input); err != nil {
+					return toolErrorResult("invalid arguments: %s", err), nil
 				}
 			}
+			if err := resolvedInputSchema.ApplyDefaults(&input); err != nil {
+				return toolErrorResult("invalid arguments: %s", err), nil
+			}
+			if err := resolvedInputSchema.Validate(input); err != nil {
+				return toolErrorResult("invalid arguments: %s", err), nil
+			}
+
+			// セッションIDを取り出す
+			sessionID, _ := input[SessionIDInputName].(string)
+			delete(input, SessionIDInputName)
+
+			options := &RunOptions{}
+			if sessionID != "" && app.journal != nil {
+				sessionsMutex.Lock()
+				options.Resume = sessions[sessionID]
+				sessionsMutex.Unlock()
+			}
+
+			// 画像や埋め込みリソースを作業ディレクトリ以下の一時ディレクトリに書き出す
+			tmpDir := filepath.Join(workdirAbsPath, ".ace", "tmp", journal.NewID())
+			defer os.RemoveAll(tmpDir)
+			if err := materializeContentInputs(agent, workdirAbsPath, tmpDir, input); err != nil {
+				return toolErrorResult("invalid arguments: %s", err), nil
+			}
 
 			// エージェントの実行
-			output, err := agent.Run(
-				workdir,
-				input,
-				&agents.RunConfig{
-					APIKey:                  app.apiKey,
-					SubagentMCPServerConfig: app.subAgentMCPServerConfig,
-					LogLevel:                app.logLevel,
-					LogWriter:               app.logWriter,
-				},
-			)
+			run, err := app.runAgent(agent, workdir, input, options)
 			if err != nil {
-				return nil, nil, err
+				return toolErrorResult("failed to run agent %s: %s", agent.Name, err), nil
 			}
-			return nil, output, nil
+
+			// セッションの最新の実行IDを更新
+			if sessionID != "" {
+				sessionsMutex.Lock()
+				sessions[sessionID] = run.ID
+				sessionsMutex.Unlock()
+			}
+
+			// ツールの結果を構築
+			result, err := app.toolResult(agent, mcpConfig, run.Workdir, run.Output)
+			if err != nil {
+				return toolErrorResult("failed to build result of agent %s: %s", agent.Name, err), nil
+			}
+
+			// 回答したモデルをメタデータとして返す
+			if run.Model != "" {
+				result.Meta = mcp.Meta{"model": run.Model}
+			}
+
+			// 出力の検査を満たさなかった場合は、理由を添えてツールのエラーとする
+			if !run.ChecksPassed() {
+				result.IsError = true
+				result.Content = append(result.Content, &mcp.TextContent{Text: failedChecksMessage(agent, run)})
+			}
+
+			return result, nil
 		},
 	)
 
+	// 入力の配列を受け取り、エージェントを並列に実行するツール
+	if mcpConfig.Parallelism > 0 {
+		app.addMapTool(server, agent, &inputSchema, workdir, workdirAbsPath, mcpConfig.Parallelism)
+	}
+
+	// YAML ファイルに定義されたエージェントを Prompt と Resource としても提供する
+	app.addPrompts(server, workdirAbsPath)
+	app.addResources(server, workdirAbsPath)
+
 	// MCP Serverを起動
 	if err := server.Run(context.Background(), &mcp.StdioTransport{}); err != nil {
 		return err
@@ -66,3 +168,115 @@ func (app *App) RunMCPServer(agentName string, workdir string) error {
 
 	return nil
 }
+
+// 出力の検査を満たさなかった理由を返す
+func failedChecksMessage(agent *agents.Agent, run *journal.Run) string {
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "the output of agent %s did not pass the output checks:", agent.Name)
+	for _, check := range run.Checks {
+		if !check.Passed {
+			fmt.Fprintf(text, "\n- %s: %s", check.Name, check.Message)
+		}
+	}
+	return text.String()
+}
+
+// 失敗したツールの結果を返す
+func toolErrorResult(format string, args ...any) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		IsError: true,
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: fmt.Sprintf(format, args...)},
+		},
+	}
+}
+
+// エージェントの出力からツールの結果を構築する
+func (app *App) toolResult(agent *agents.Agent, mcpConfig *MCPConfig, workdirAbsPath string, output any) (*mcp.CallToolResult, error) {
+	// 構造化された出力をテキストにしたもの
+	var text string
+	if mcpConfig.SummaryTemplate != "" {
+		summaryTemplate, err := templates.Parse("summary", mcpConfig.SummaryTemplate, app.config.MissingKey)
+		if err != nil {
+			return nil, err
+		}
+		text, err = templates.Execute(summaryTemplate, workdirAbsPath, output)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		b, err := json.Marshal(output)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+
+	result := &mcp.CallToolResult{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: output,
+	}
+
+	// エージェントが作成したファイル
+	if mcpConfig.Files == "none" {
+		return result, nil
+	}
+	outputMap, _ := output.(map[string]any)
+	for propName, propSchema := range agent.OutputSchema.Properties {
+		for _, value := range fileValues(propName, outputMap[propName], propSchema) {
+			filePath, ok := value.(string)
+			if !ok || filePath == "" {
+				continue
+			}
+			absPath, err := resolveWorkdirPath(workdirAbsPath, filePath)
+			if err != nil {
+				continue // 作業ディレクトリの外のファイルは返さない
+			}
+			info, err := os.Stat(absPath)
+			if err != nil || info.IsDir() {
+				continue
+			}
+
+			content, err := fileContent(absPath, info, mcpConfig.Files == "embed")
+			if err != nil {
+				return nil, err
+			}
+			result.Content = append(result.Content, content)
+		}
+	}
+
+	return result, nil
+}
+
+// 埋め込むファイルサイズの上限
+// これより大きいファイルは embed が指定されていてもリソースリンクとして返す。
+const maxEmbeddedFileSize = 10 * 1024 * 1024
+
+// ファイルをリソースリンクもしくは埋め込みリソースとして返す
+func fileContent(absPath string, info os.FileInfo, embed bool) (mcp.Content, error) {
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String()
+	mimeType := mime.TypeByExtension(filepath.Ext(absPath))
+
+	if !embed || info.Size() > maxEmbeddedFileSize {
+		size := info.Size()
+		return &mcp.ResourceLink{
+			URI:      uri,
+			Name:     filepath.Base(absPath),
+			MIMEType: mimeType,
+			Size:     &size,
+		}, nil
+	}
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	resource := &mcp.ResourceContents{URI: uri, MIMEType: mimeType}
+	if strings.HasPrefix(mimeType, "text/") || (mimeType == "" && utf8.Valid(data)) {
+		resource.Text = string(data)
+	} else {
+		resource.Blob = data
+	}
+
+	return &mcp.EmbeddedResource{Resource: resource}, nil
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// MCP Server の起動を待つ秒数のデフォルト値（Codex CLI と同じ）
+const DefaultMCPStartupTimeoutSec = 10
+
+// エージェントの mcp_servers に定義された MCP Server
+type MCPServer struct {
+	AgentName  string           // MCP Server を定義しているエージェント名
+	ServerName string           // MCP Server の名前
+	Config     *MCPServerConfig // MCP Server の定義
+}
+
+// MCP Server が提供するツール
+type MCPTool struct {
+	Tool    *mcp.Tool
+	Enabled bool // enabled_tools と disabled_tools によって Codex に提供されるかどうか
+}
+
+// エージェントの mcp_servers に定義された MCP Server を返す
+// agentName が空文字列の場合はすべてのエージェントから、serverName が空文字列の場合はすべての MCP Server を返す。
+func (app *App) MCPServers(agentName string, serverName string) ([]*MCPServer, error) {
+	agentNames := slices.Sorted(maps.Keys(app.config.Agents))
+	if agentName != "" {
+		if _, ok := app.config.Agents[agentName]; !ok {
+			return nil, errors.New("no such agent: " + agentName)
+		}
+		agentNames = []string{agentName}
+	}
+
+	servers := []*MCPServer{}
+	for _, name := range agentNames {
+		agentConfig := app.config.Agents[name]
+		for _, mcpServerName := range slices.Sorted(maps.Keys(agentConfig.MCPServers)) {
+			if serverName != "" && mcpServerName != serverName {
+				continue
+			}
+			servers = append(servers, &MCPServer{
+				AgentName:  name,
+				ServerName: mcpServerName,
+				Config:     agentConfig.MCPServers[mcpServerName],
+			})
+		}
+	}
+
+	if serverName != "" && len(servers) == 0 {
+		return nil, errors.New("no such mcp server: " + serverName)
+	}
+
+	return servers, nil
+}
+
+// エージェントの mcp_servers に定義された MCP Server をひとつ返す
+// 複数のエージェントに同じ名前の MCP Server が定義されている場合はエラーとする。
+func (app *App) MCPServer(agentName string, serverName string) (*MCPServer, error) {
+	servers, err := app.MCPServers(agentName, serverName)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) > 1 {
+		return nil, fmt.Errorf("mcp server %s is defined in multiple agents, please specify the agent", serverName)
+	}
+
+	return servers[0], nil
+}
+
+// MCP Server を起動して接続する
+func (server *MCPServer) Connect(ctx context.Context, workdir string) (*mcp.ClientSession, error) {
+	transport, err := server.transport(workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "ace", Version: "v1.0.0"}, nil)
+	session, err := client.Connect(ctx, transport, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mcp server %s: %w", server.ServerName, err)
+	}
+
+	return session, nil
+}
+
+// MCP Server の起動を待つ時間を返す
+func (server *MCPServer) StartupTimeout() time.Duration {
+	if server.Config.StartupTimeoutSec > 0 {
+		return time.Duration(server.Config.StartupTimeoutSec) * time.Second
+	}
+	// Codex CLI の設定としてそのまま渡す startup_timeout_ms
+	if startupTimeoutMs, err := strconv.ParseInt(fmt.Sprint(server.Config.Extra["startup_timeout_ms"]), 10, 64); err == nil && startupTimeoutMs > 0 {
+		return time.Duration(startupTimeoutMs) * time.Millisecond
+	}
+	return DefaultMCPStartupTimeoutSec * time.Second
+}
+
+// MCP Server の定義から Transport を構築する
+func (server *MCPServer) transport(workdir string) (mcp.Transport, error) {
+	config := server.Config
+
+	// Streamable HTTP
+	if config.URL != "" {
+		headers := map[string]string{}
+		for key, value := range config.HTTPHeaders {
+			headers[key] = value
+		}
+		for key, envVar := range config.EnvHTTPHeaders {
+			if value := os.Getenv(envVar); value != "" {
+				headers[key] = value
+			}
+		}
+		if config.BearerTokenEnvVar != "" {
+			headers["Authorization"] = "Bearer " + os.Getenv(config.BearerTokenEnvVar)
+		}
+		return &mcp.StreamableClientTransport{
+			Endpoint:   config.URL,
+			HTTPClient: &http.Client{Transport: &headerRoundTripper{headers: headers}},
+		}, nil
+	}
+
+	// STDIO
+	if config.Command == "" {
+		return nil, fmt.Errorf("mcp server %s has neither command nor url", server.ServerName)
+	}
+	cmd := exec.Command(config.Command, config.Args...)
+
+	// 作業ディレクトリ
+	cmd.Dir = workdir
+	if config.Cwd != "" {
+		cmd.Dir = config.Cwd
+		if !filepath.IsAbs(config.Cwd) {
+			cmd.Dir = filepath.Join(workdir, config.Cwd)
+		}
+	}
+
+	// 環境変数
+	cmd.Env = os.Environ()
+	for key, value := range config.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	// MCP Server のログは標準エラー出力にそのまま流す
+	cmd.Stderr = os.Stderr
+
+	return &mcp.CommandTransport{Command: cmd}, nil
+}
+
+// MCP Server が提供するツールの一覧を返す
+func (server *MCPServer) ListTools(ctx context.Context, session *mcp.ClientSession) ([]*MCPTool, error) {
+	tools := []*MCPTool{}
+	for tool, err := range session.Tools(ctx, nil) {
+		if err != nil {
+			return nil, err
+		}
+		tools = append(tools, &MCPTool{
+			Tool:    tool,
+			Enabled: server.toolEnabled(tool.Name),
+		})
+	}
+
+	slices.SortFunc(tools, func(a *MCPTool, b *MCPTool) int {
+		return strings.Compare(a.Tool.Name, b.Tool.Name)
+	})
+
+	return tools, nil
+}
+
+// enabled_tools に指定されているが、MCP Server が提供していないツール名を返す
+func (server *MCPServer) MissingTools(tools []*MCPTool) []string {
+	missing := []string{}
+	for _, name := range server.Config.EnabledTools {
+		if !slices.ContainsFunc(tools, func(tool *MCPTool) bool { return tool.Tool.Name == name }) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// ツールが enabled_tools と disabled_tools によって Codex に提供されるかどうか
+func (server *MCPServer) toolEnabled(toolName string) bool {
+	if server.Config.EnabledTools != nil && !slices.Contains(server.Config.EnabledTools, toolName) {
+		return false
+	}
+	if slices.Contains(server.Config.DisabledTools, toolName) {
+		return false
+	}
+	return true
+}
+
+// MCP Server のツールを呼び出す
+// arguments: ["KEY=VALUE", "KEY=VALUE"...]
+// arguments はツールの入力スキーマに従ってパースする。
+func (app *App) CallMCPTool(ctx context.Context, session *mcp.ClientSession, tool *mcp.Tool, arguments []string) (*mcp.CallToolResult, error) {
+	// ツールの入力スキーマを取得
+	inputSchema := &jsonschema.Schema{}
+	if tool.InputSchema != nil {
+		b, err := json.Marshal(tool.InputSchema)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, inputSchema); err != nil {
+			return nil, err
+		}
+	}
+
+	// ["KEY=VALUE", "KEY=VALUE"...] 形式の arguments を map[string]any にパースする
+	argumentsMap, err := parseArguments(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	// 入力スキーマの定義に従って、arguments をパースする
+	// 必須でないプロパティは指定されていなければ省略する
+	input := map[string]any{}
+	for propName, propSchema := range inputSchema.Properties {
+		if argumentsMap[propName] == nil && !slices.Contains(inputSchema.Required, propName) {
+			continue
+		}
+		value, err := app.applyJSONSchema(propName, argumentsMap[propName], propSchema)
+		if err != nil {
+			return nil, err
+		}
+		input[propName] = value
+	}
+	for key := range argumentsMap {
+		if _, ok := inputSchema.Properties[key]; !ok {
+			return nil, fmt.Errorf("no such input in tool %s: %s", tool.Name, key)
+		}
+	}
+
+	return session.CallTool(ctx, &mcp.CallToolParams{
+		Name:      tool.Name,
+		Arguments: input,
+	})
+}
+
+// ツールの結果から出力を取り出す
+// 構造化された結果があればそれを、なければテキストの結果を連結したものを返す
+func (app *App) MCPToolOutput(result *mcp.CallToolResult) any {
+	if result.StructuredContent != nil {
+		return result.StructuredContent
+	}
+
+	texts := []string{}
+	for _, content := range result.Content {
+		if text, ok := content.(*mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// リクエストに HTTP ヘッダーを付与する
+type headerRoundTripper struct {
+	headers map[string]string
+}
+
+func (roundTripper *headerRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	for key, value := range roundTripper.headers {
+		request.Header.Set(key, value)
+	}
+	return http.DefaultTransport.RoundTrip(request)
+}
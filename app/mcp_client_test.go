@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestCallMCPTool(t *testing.T) {
+	ctx := context.Background()
+
+	// 受け取った引数をそのまま返すツールを持つ MCP Server
+	server := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "v1.0.0"}, nil)
+	server.AddTool(
+		&mcp.Tool{
+			Name: "echo",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"q":    map[string]any{"type": "string"},
+					"n":    map[string]any{"type": "integer"},
+					"ok":   map[string]any{"type": "boolean"},
+					"tags": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				},
+				"required": []any{"q"},
+			},
+		},
+		func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(req.Params.Arguments)}}}, nil
+		},
+	)
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverSession.Close()
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "ace", Version: "v1.0.0"}, nil)
+	session, err := client.Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	tools, err := session.ListTools(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := tools.Tools[0]
+
+	app := &App{config: &Config{}}
+
+	tests := []struct {
+		name      string
+		arguments []string
+		want      string
+		wantErr   bool
+	}{
+		{name: "typed", arguments: []string{"q=a=b", "n=3", "ok=true", "tags=x", "tags=y"}, want: `{"n":3,"ok":true,"q":"a=b","tags":["x","y"]}`},
+		{name: "optional omitted", arguments: []string{"q=a"}, want: `{"q":"a"}`},
+		{name: "last value for non-array", arguments: []string{"q=a", "q=b"}, want: `{"q":"b"}`},
+		{name: "missing required", arguments: []string{"n=1"}, wantErr: true},
+		{name: "invalid integer", arguments: []string{"q=a", "n=x"}, wantErr: true},
+		{name: "no such input", arguments: []string{"q=a", "other=1"}, wantErr: true},
+		{name: "not KEY=VALUE", arguments: []string{"q"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := app.CallMCPTool(ctx, session, tool, tt.arguments)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CallMCPTool(%q) error = %v, wantErr %v", tt.arguments, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := app.MCPToolOutput(result); got != tt.want {
+				t.Errorf("CallMCPTool(%q) arguments = %v, want %s", tt.arguments, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// map_NAME ツールの名前の接頭辞
+const MapToolPrefix = "map_"
+
+// map_NAME ツールのひとつの入力にたいする結果
+type mapResult struct {
+	Output any    `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// 入力の配列を受け取り、エージェントを並列に実行して出力の配列を返すツールを追加する
+// 入力ごとに独立して実行するので、セッションIDは受け付けない
+func (app *App) addMapTool(server *mcp.Server, agent *agents.Agent, inputSchema *jsonschema.Schema, workdir string, workdirAbsPath string, parallelism int) {
+	itemSchema := *inputSchema
+	itemSchema.Properties = maps.Clone(inputSchema.Properties)
+	delete(itemSchema.Properties, SessionIDInputName)
+
+	mapInputSchema := &jsonschema.Schema{
+		Type:     "object",
+		Required: []string{"inputs"},
+		Properties: map[string]*jsonschema.Schema{
+			"inputs": {
+				Type:        "array",
+				Description: "エージェントへの入力の配列。各入力にたいしてエージェントを並列に実行する。",
+				Items:       &itemSchema,
+				MinItems:    jsonschema.Ptr(1),
+			},
+		},
+		AdditionalProperties: &jsonschema.Schema{Not: &jsonschema.Schema{}},
+	}
+	mapOutputSchema := &jsonschema.Schema{
+		Type:     "object",
+		Required: []string{"results"},
+		Properties: map[string]*jsonschema.Schema{
+			"results": {
+				Type:        "array",
+				Description: "入力と同じ順序の実行結果の配列。成功した場合は output に出力を、失敗した場合は error に理由を格納する。",
+				Items: &jsonschema.Schema{
+					Type: "object",
+					Properties: map[string]*jsonschema.Schema{
+						"output": agent.OutputSchema,
+						"error":  {Type: "string"},
+					},
+				},
+			},
+		},
+	}
+
+	server.AddTool(
+		&mcp.Tool{
+			Name:         MapToolPrefix + agent.Name,
+			Description:  fmt.Sprintf("複数の入力それぞれにたいして %s を最大 %d 並列で実行し、入力と同じ順序で結果を返す。\n\n%s", agent.Name, parallelism, agent.Description),
+			InputSchema:  mapInputSchema,
+			OutputSchema: mapOutputSchema,
+		},
+		func(ctx context.Context, request *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			// 入力のパースと検証
+			// 個々の入力の誤りは、その入力の結果のエラーとして返す
+			arguments := struct {
+				Inputs []map[string]any `json:"inputs"`
+			}{}
+			if err := json.Unmarshal(request.Params.Arguments, &arguments); err != nil {
+				return toolErrorResult("invalid arguments: %s", err), nil
+			}
+			if len(arguments.Inputs) == 0 {
+				return toolErrorResult("invalid arguments: inputs is empty"), nil
+			}
+			resolvedItemSchema, err := itemSchema.Resolve(nil)
+			if err != nil {
+				return toolErrorResult("invalid input schema: %s", err), nil
+			}
+
+			// 並列数を制限しながら、入力ごとにエージェントを実行する
+			results := make([]*mapResult, len(arguments.Inputs))
+			semaphore := make(chan struct{}, parallelism)
+			wg := &sync.WaitGroup{}
+			for i, input := range arguments.Inputs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					semaphore <- struct{}{}
+					defer func() { <-semaphore }()
+
+					results[i] = app.runMapItem(agent, resolvedItemSchema, workdir, workdirAbsPath, input)
+				}()
+			}
+			wg.Wait()
+
+			// 構造化された出力を返す
+			output := map[string]any{"results": results}
+			b, err := json.Marshal(output)
+			if err != nil {
+				return toolErrorResult("failed to build result of agent %s: %s", agent.Name, err), nil
+			}
+			var structured any
+			if err := json.Unmarshal(b, &structured); err != nil {
+				return toolErrorResult("failed to build result of agent %s: %s", agent.Name, err), nil
+			}
+
+			return &mcp.CallToolResult{
+				Content:           []mcp.Content{&mcp.TextContent{Text: string(b)}},
+				StructuredContent: structured,
+			}, nil
+		},
+	)
+}
+
+// map_NAME ツールのひとつの入力にたいしてエージェントを実行する
+func (app *App) runMapItem(agent *agents.Agent, schema *jsonschema.Resolved, workdir string, workdirAbsPath string, input map[string]any) *mapResult {
+	if input == nil {
+		input = map[string]any{}
+	}
+	if err := schema.ApplyDefaults(&input); err != nil {
+		return &mapResult{Error: "invalid arguments: " + err.Error()}
+	}
+	if err := schema.Validate(input); err != nil {
+		return &mapResult{Error: "invalid arguments: " + err.Error()}
+	}
+
+	// 画像や埋め込みリソースを作業ディレクトリ以下の一時ディレクトリに書き出す
+	tmpDir := filepath.Join(workdirAbsPath, ".ace", "tmp", journal.NewID())
+	defer os.RemoveAll(tmpDir)
+	if err := materializeContentInputs(agent, workdirAbsPath, tmpDir, input); err != nil {
+		return &mapResult{Error: "invalid arguments: " + err.Error()}
+	}
+
+	// エージェントの実行
+	run, err := app.runAgent(agent, workdir, input, &RunOptions{})
+	if err != nil {
+		return &mapResult{Error: fmt.Sprintf("failed to run agent %s: %s", agent.Name, err)}
+	}
+	if !run.ChecksPassed() {
+		return &mapResult{Output: run.Output, Error: failedChecksMessage(agent, run)}
+	}
+
+	return &mapResult{Output: run.Output}
+}
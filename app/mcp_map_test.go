@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// 同時に実行されている数を記録し、プロンプトの 1 行目が fail なら失敗する codex の代わりのスクリプト
+const mapCodexScript = `#!/bin/sh
+dir=$(dirname "$0")
+out=""
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --output-last-message) out="$2"; shift ;;
+  esac
+  shift
+done
+prompt=$(cat)
+question=$(printf '%s\n' "$prompt" | head -n 1)
+mkdir -p "$dir/running"
+touch "$dir/running/$$"
+ls "$dir/running" | wc -l >> "$dir/concurrency.txt"
+sleep 0.3
+rm -f "$dir/running/$$"
+if [ "$question" = "fail" ]; then
+  echo "boom" >&2
+  exit 2
+fi
+echo '{"type":"turn.completed"}'
+printf '{"ok":"%s"}' "$question" > "$out"
+`
+
+func TestMapTool(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not available")
+	}
+	ctx := context.Background()
+	const parallelism = 2
+
+	codexPath := filepath.Join(t.TempDir(), "codex")
+	if err := os.WriteFile(codexPath, []byte(mapCodexScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	inputSchema := map[string]*jsonschema.Schema{"question": {Type: "string"}}
+	agent, err := agents.Build(codexPath, &agents.Config{
+		Name:           "echo",
+		Instruction:    "指示",
+		PromptTemplate: "{{.question}}",
+		InputSchema:    inputSchema,
+		OutputSchema:   map[string]*jsonschema.Schema{"ok": {Type: "string"}},
+		Sandbox:        "read-only",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{config: &Config{}}
+	workdir := t.TempDir()
+	server := mcp.NewServer(&mcp.Implementation{Name: "ace", Version: "v1.0.0"}, nil)
+	app.addMapTool(server, agent, &jsonschema.Schema{Type: "object", Properties: inputSchema}, workdir, workdir, parallelism)
+
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serverSession.Close()
+	client := mcp.NewClient(&mcp.Implementation{Name: "test", Version: "v1.0.0"}, nil)
+	session, err := client.Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// 一部の入力が失敗しても、ほかの入力の結果を入力と同じ順序で返す
+	result, err := session.CallTool(ctx, &mcp.CallToolParams{
+		Name: MapToolPrefix + agent.Name,
+		Arguments: map[string]any{"inputs": []any{
+			map[string]any{"question": "a"},
+			map[string]any{"question": "fail"},
+			map[string]any{"question": 1},
+			map[string]any{"question": "b"},
+			map[string]any{"question": "c"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IsError {
+		t.Fatalf("map tool failed: %v", result.Content)
+	}
+
+	var got struct {
+		Results []*mapResult `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(result.Content[0].(*mcp.TextContent).Text), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results) != 5 {
+		t.Fatalf("results = %d, want 5", len(got.Results))
+	}
+	for i, want := range []string{"a", "", "", "b", "c"} {
+		if want == "" {
+			if got.Results[i].Error == "" || got.Results[i].Output != nil {
+				t.Errorf("results[%d] = %+v, want an error", i, got.Results[i])
+			}
+			continue
+		}
+		if got.Results[i].Error != "" || !reflect.DeepEqual(got.Results[i].Output, map[string]any{"ok": want}) {
+			t.Errorf("results[%d] = %+v, want output %q", i, got.Results[i], want)
+		}
+	}
+	if !strings.Contains(got.Results[2].Error, "invalid arguments") {
+		t.Errorf("results[2].Error = %q, want invalid arguments", got.Results[2].Error)
+	}
+
+	// 同時に実行するのは parallelism 個まで
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(codexPath), "concurrency.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line := range strings.FieldsSeq(string(b)) {
+		if concurrency, err := strconv.Atoi(line); err != nil || concurrency > parallelism {
+			t.Errorf("concurrency = %s, want at most %d", line, parallelism)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// YAML ファイルに定義されたエージェントの URI の接頭辞
+const agentResourceURIPrefix = "ace://agents/"
+
+// 実行記録の URI の接頭辞
+const runResourceURIPrefix = "ace://runs/"
+
+// MCP Client に一覧表示する実行記録の最大数
+const maxListedRuns = 50
+
+// YAML ファイルに定義されたすべてのエージェントの prompt_template を MCP の Prompt として登録する
+// ビルドできないエージェントは、警告をログに出力して登録しない
+func (app *App) addPrompts(server *mcp.Server, workdir string) {
+	for _, agentName := range slices.Sorted(maps.Keys(app.config.Agents)) {
+		agent, err := app.buildAgent(agentName)
+		if err != nil {
+			app.logf("agent %s is not provided as a prompt: %s", agentName, err)
+			continue
+		}
+
+		// input_schema のプロパティを Prompt の引数とする
+		arguments := []*mcp.PromptArgument{}
+		for _, propName := range slices.Sorted(maps.Keys(agent.InputSchema.Properties)) {
+			propSchema := agent.InputSchema.Properties[propName]
+			arguments = append(arguments, &mcp.PromptArgument{
+				Name:        propName,
+				Description: propSchema.Description,
+				Required:    propSchema.Default == nil,
+			})
+		}
+
+		server.AddPrompt(
+			&mcp.Prompt{
+				Name:        agent.Name,
+				Description: agent.Description,
+				Arguments:   arguments,
+			},
+			func(ctx context.Context, request *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+				prompt, err := app.renderPrompt(agent, workdir, request.Params.Arguments)
+				if err != nil {
+					return nil, err
+				}
+
+				return &mcp.GetPromptResult{
+					Description: agent.Description,
+					Messages: []*mcp.PromptMessage{
+						{Role: "user", Content: &mcp.TextContent{Text: prompt}},
+					},
+				}, nil
+			},
+		)
+	}
+}
+
+// Prompt の引数で prompt_template を展開する
+// 引数は文字列で与えられるので、input_schema の定義に従ってパースする
+func (app *App) renderPrompt(agent *agents.Agent, workdir string, arguments map[string]string) (string, error) {
+	input := map[string]any{}
+	for propName, propSchema := range agent.InputSchema.Properties {
+		argument, ok := arguments[propName]
+
+		// オブジェクトと配列は JSON 形式で受け付ける
+		if ok && (propSchema.Type == "object" || propSchema.Type == "array") {
+			var value any
+			if err := json.Unmarshal([]byte(argument), &value); err != nil {
+				return "", fmt.Errorf("specified value is incompatible with the input_schema definition: %s", propName)
+			}
+			input[propName] = value
+			continue
+		}
+
+		var value any
+		if ok {
+			value = argument
+		}
+		value, err := app.applyJSONSchema(propName, value, propSchema)
+		if err != nil {
+			return "", err
+		}
+		input[propName] = value
+	}
+
+	app.expandVars(input)
+
+	return agent.RenderPrompt(workdir, input)
+}
+
+// YAML ファイルに定義されたエージェントと実行記録を MCP の Resource として登録する
+// ビルドできないエージェントは、警告をログに出力して登録しない
+// 実行記録は、YAML ファイルに定義されたエージェントを workdir 以下で実行したものに限る
+func (app *App) addResources(server *mcp.Server, workdir string) {
+	// エージェントの定義
+	for _, agentName := range slices.Sorted(maps.Keys(app.config.Agents)) {
+		agent, err := app.buildAgent(agentName)
+		if err != nil {
+			app.logf("agent %s is not provided as a resource: %s", agentName, err)
+			continue
+		}
+
+		uri := agentResourceURIPrefix + agent.Name
+		server.AddResource(
+			&mcp.Resource{
+				URI:         uri,
+				Name:        agent.Name,
+				Description: agent.Description,
+				MIMEType:    "application/json",
+			},
+			func(ctx context.Context, request *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+				subAgents := []string{}
+				for _, subAgent := range agent.SubAgents {
+					subAgents = append(subAgents, subAgent.Name)
+				}
+				return jsonResource(uri, map[string]any{
+					"name":            agent.Name,
+					"description":     agent.Description,
+					"input_schema":    agent.InputSchema,
+					"output_schema":   agent.OutputSchema,
+					"approval_policy": agent.ApprovalPolicy,
+					"sandbox":         agent.Sandbox,
+					"sub_agents":      subAgents,
+				})
+			},
+		)
+	}
+
+	// 実行記録
+	if app.journal == nil {
+		return
+	}
+	server.AddResource(
+		&mcp.Resource{
+			URI:         strings.TrimSuffix(runResourceURIPrefix, "/"),
+			Name:        "runs",
+			Description: fmt.Sprintf("最近の実行記録（最大 %d 件）の一覧", maxListedRuns),
+			MIMEType:    "application/json",
+		},
+		func(ctx context.Context, request *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			// 新しい順に読み込み、提供する実行記録が上限に達したら打ち切る
+			ids, err := app.journal.IDs()
+			if err != nil {
+				return nil, err
+			}
+			runs := []*journal.Run{}
+			for _, id := range ids {
+				if len(runs) >= maxListedRuns {
+					break
+				}
+				run, err := app.journal.Load(id)
+				if err != nil {
+					continue // 壊れた記録は無視する
+				}
+				if app.exposesRun(run, workdir) {
+					runs = append(runs, run)
+				}
+			}
+
+			list := []map[string]any{}
+			for _, run := range runs {
+				list = append(list, map[string]any{
+					"uri":        runResourceURIPrefix + run.ID,
+					"id":         run.ID,
+					"agent":      run.Agent,
+					"session":    run.Session,
+					"error":      run.Error,
+					"started_at": run.StartedAt,
+				})
+			}
+			return jsonResource(request.Params.URI, list)
+		},
+	)
+	server.AddResourceTemplate(
+		&mcp.ResourceTemplate{
+			URITemplate: runResourceURIPrefix + "{id}",
+			Name:        "run",
+			Description: "エージェントの実行記録",
+			MIMEType:    "application/json",
+		},
+		func(ctx context.Context, request *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+			run, err := app.journal.Load(strings.TrimPrefix(request.Params.URI, runResourceURIPrefix))
+			if err != nil || !app.exposesRun(run, workdir) {
+				return nil, mcp.ResourceNotFoundError(request.Params.URI)
+			}
+			return jsonResource(request.Params.URI, run)
+		},
+	)
+}
+
+// 実行記録を MCP Client に提供するか判定する
+// ほかの YAML ファイルや作業ディレクトリでの実行の記録は提供しない
+func (app *App) exposesRun(run *journal.Run, workdir string) bool {
+	if _, ok := app.config.Agents[run.Agent]; !ok {
+		return false
+	}
+	return run.Workdir != "" && isUnder(workdir, run.Workdir)
+}
+
+// 値を JSON 形式の Resource として返す
+func jsonResource(uri string, value any) (*mcp.ReadResourceResult, error) {
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.ReadResourceResult{
+		Contents: []*mcp.ResourceContents{
+			{URI: uri, MIMEType: "application/json", Text: string(b)},
+		},
+	}, nil
+}
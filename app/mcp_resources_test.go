@@ -0,0 +1,36 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/kurusugawa-computer/ace/journal"
+)
+
+func TestExposesRun(t *testing.T) {
+	app := &App{
+		config: &Config{
+			Agents: map[string]*AgentConfig{"root": {}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		run  *journal.Run
+		want bool
+	}{
+		{name: "workdir", run: &journal.Run{Agent: "root", Workdir: "/work"}, want: true},
+		{name: "agent workdir", run: &journal.Run{Agent: "root", Workdir: "/work/sub"}, want: true},
+		{name: "other workdir", run: &journal.Run{Agent: "root", Workdir: "/other"}, want: false},
+		{name: "sibling workdir", run: &journal.Run{Agent: "root", Workdir: "/workspace"}, want: false},
+		{name: "unknown workdir", run: &journal.Run{Agent: "root"}, want: false},
+		{name: "other agent", run: &journal.Run{Agent: "other", Workdir: "/work"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.exposesRun(tt.run, "/work"); got != tt.want {
+				t.Errorf("exposesRun(%+v) = %v, want %v", tt.run, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestToolResult(t *testing.T) {
+	workdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workdir, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workdir, "b.png"), []byte{0x89, 'P', 'N', 'G'}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 埋め込むファイルサイズの上限を超えるファイル
+	if err := os.WriteFile(filepath.Join(workdir, "large.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(workdir, "large.txt"), maxEmbeddedFileSize+1); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{config: &Config{}}
+	agent := &agents.Agent{
+		Name: "root",
+		OutputSchema: &jsonschema.Schema{
+			Type: "object",
+			Properties: map[string]*jsonschema.Schema{
+				"file":    {Type: "string", Format: "file"},
+				"files":   {Type: "array", Items: &jsonschema.Schema{Type: "string", Format: "file"}},
+				"summary": {Type: "string"},
+			},
+		},
+	}
+
+	// 内容の種類（resource_link, text, blob）をファイル名ごとに返す
+	kinds := func(t *testing.T, result *mcp.CallToolResult) map[string]string {
+		t.Helper()
+		if _, ok := result.Content[0].(*mcp.TextContent); !ok {
+			t.Fatalf("Content[0] = %T, want TextContent", result.Content[0])
+		}
+		got := map[string]string{}
+		for _, content := range result.Content[1:] {
+			switch content := content.(type) {
+			case *mcp.ResourceLink:
+				got[content.Name] = "resource_link"
+			case *mcp.EmbeddedResource:
+				name := filepath.Base(content.Resource.URI)
+				if content.Resource.Blob != nil {
+					got[name] = "blob"
+				} else {
+					got[name] = "text"
+				}
+			default:
+				t.Fatalf("unexpected content %T", content)
+			}
+		}
+		return got
+	}
+
+	tests := []struct {
+		name   string
+		files  string
+		output map[string]any
+		want   map[string]string
+	}{
+		{
+			name:   "none",
+			files:  "none",
+			output: map[string]any{"file": "a.txt"},
+			want:   map[string]string{},
+		},
+		{
+			name:   "link",
+			files:  "link",
+			output: map[string]any{"file": "a.txt", "files": []any{"b.png"}},
+			want:   map[string]string{"a.txt": "resource_link", "b.png": "resource_link"},
+		},
+		{
+			name:   "embed",
+			files:  "embed",
+			output: map[string]any{"file": "a.txt", "files": []any{"b.png"}},
+			want:   map[string]string{"a.txt": "text", "b.png": "blob"},
+		},
+		{
+			name:   "embed over the size limit",
+			files:  "embed",
+			output: map[string]any{"file": "large.txt"},
+			want:   map[string]string{"large.txt": "resource_link"},
+		},
+		{
+			name:   "outside of workdir and missing files",
+			files:  "embed",
+			output: map[string]any{"file": "../outside.txt", "files": []any{"missing.txt", "", 1}},
+			want:   map[string]string{},
+		},
+		{
+			name:   "not a file property",
+			files:  "link",
+			output: map[string]any{"summary": "a.txt"},
+			want:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := app.toolResult(agent, &MCPConfig{Files: tt.files}, workdir, tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := kinds(t, result)
+			if len(got) != len(tt.want) {
+				t.Fatalf("files = %v, want %v", got, tt.want)
+			}
+			for name, kind := range tt.want {
+				if got[name] != kind {
+					t.Errorf("%s = %q, want %q", name, got[name], kind)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/kurusugawa-computer/ace/agents"
+)
+
+// ネットワークへのアクセスの方針
+const (
+	NetworkNone    = "none"     // ネットワークにアクセスしない
+	NetworkMCPOnly = "mcp-only" // MCP Server とサブエージェントを介してのみアクセスする
+	NetworkAllow   = "allow"    // サンドボックスの中のコマンドからもアクセスする
+)
+
+// Codex の Config のうち、ネットワークへのアクセスにかかわるキー
+const (
+	codexNetworkAccessKey   = "sandbox_workspace_write.network_access"
+	codexWebSearchKey       = "features.web_search_request"
+	codexLegacyWebSearchKey = "tools.web_search"
+)
+
+// network の定義を Codex の Config に反映する
+// 定義されていなければ、sandbox と config の指定のままとする
+func applyNetwork(network *NetworkConfig, sandbox string, mcpServers map[string]*MCPServerConfig, subAgents []string, codexConfig agents.CodexConfig) error {
+	if network == nil {
+		return nil
+	}
+
+	// サンドボックスを用いない場合は、ネットワークへのアクセスを制限できない
+	if network.Mode != NetworkAllow && sandbox == "danger-full-access" {
+		return fmt.Errorf("network %s is not available with sandbox: danger-full-access", network.Mode)
+	}
+
+	// config に直接書かれた MCP Server も含める
+	urls := map[string]string{}
+	for mcpServerName, mcpServerConfig := range mcpServers {
+		urls[mcpServerName] = mcpServerConfig.URL
+	}
+	for mcpServerName, mcpServerConfig := range rawMCPServers(codexConfig) {
+		urls[mcpServerName], _ = mcpServerConfig["url"].(string)
+	}
+
+	// MCP Server とサブエージェントは Codex のサンドボックスの外で動くので、none では利用できない
+	if network.Mode == NetworkNone {
+		if len(urls) > 0 {
+			return errors.New("network none does not allow mcp_servers")
+		}
+		if len(subAgents) > 0 {
+			return errors.New("network none does not allow sub_agents")
+		}
+	}
+
+	// url で指定する MCP Server のホストがアクセスを許すホストか
+	if len(network.AllowedHosts) > 0 {
+		for _, mcpServerName := range slices.Sorted(maps.Keys(urls)) {
+			if urls[mcpServerName] == "" {
+				continue
+			}
+			u, err := url.Parse(urls[mcpServerName])
+			if err != nil {
+				return err
+			}
+			if !hostAllowed(u.Hostname(), network.AllowedHosts) {
+				return fmt.Errorf("mcp_servers.%s: host is not in network.allowed_hosts: %s", mcpServerName, u.Hostname())
+			}
+		}
+	}
+
+	switch network.Mode {
+	case NetworkNone, NetworkMCPOnly:
+		setCodexConfig(codexConfig, codexNetworkAccessKey, false)
+		setCodexConfig(codexConfig, codexWebSearchKey, false)
+		setCodexConfig(codexConfig, codexLegacyWebSearchKey, false)
+
+	case NetworkAllow:
+		if sandbox == "workspace-write" {
+			setCodexConfig(codexConfig, codexNetworkAccessKey, true)
+		}
+	}
+
+	return nil
+}
+
+// ホスト名がアクセスを許すホストのリストに含まれるか判定する
+// *.example.com は example.com のサブドメインに一致する
+func hostAllowed(host string, allowedHosts []string) bool {
+	host = strings.ToLower(host)
+	for _, allowedHost := range allowedHosts {
+		allowedHost = strings.ToLower(allowedHost)
+		if suffix, ok := strings.CutPrefix(allowedHost, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowedHost {
+			return true
+		}
+	}
+	return false
+}
+
+// テーブル名.キー の形式のキーに値を設定する
+// config に同じキーがテーブルとして書かれていても、設定した値が優先されるように取り除く
+func setCodexConfig(codexConfig agents.CodexConfig, key string, value any) {
+	tableName, name, _ := strings.Cut(key, ".")
+	if table, ok := codexConfig[tableName].(map[string]any); ok {
+		if _, ok := table[name]; ok {
+			table = maps.Clone(table) // YAML ファイルの定義を書き換えないように複製する
+			delete(table, name)
+			codexConfig[tableName] = table
+		}
+	}
+	codexConfig[key] = value
+}
+
+// config に直接書かれた MCP Server の定義を、名前ごとにまとめて返す
+func rawMCPServers(codexConfig agents.CodexConfig) map[string]map[string]any {
+	mcpServers := map[string]map[string]any{}
+	walkMCPServers(codexConfig, func(name string, table map[string]any) {
+		if mcpServers[name] == nil {
+			mcpServers[name] = map[string]any{}
+		}
+		maps.Copy(mcpServers[name], table)
+	})
+	return mcpServers
+}
+
+// config に直接書かれた MCP Server の定義を、書かれた単位ごとにたどる
+// mcp_servers のテーブル、mcp_servers.名前、mcp_servers.名前.キー のいずれの書き方も受け付ける
+func walkMCPServers(codexConfig agents.CodexConfig, fn func(name string, table map[string]any)) {
+	for _, key := range slices.Sorted(maps.Keys(codexConfig)) {
+		value := codexConfig[key]
+		if key == "mcp_servers" {
+			table, _ := value.(map[string]any)
+			for _, name := range slices.Sorted(maps.Keys(table)) {
+				server, _ := table[name].(map[string]any)
+				fn(name, server)
+			}
+			continue
+		}
+		rest, ok := strings.CutPrefix(key, "mcp_servers.")
+		if !ok {
+			continue
+		}
+		if name, field, ok := strings.Cut(rest, "."); ok {
+			fn(name, map[string]any{field: value})
+		} else {
+			server, _ := value.(map[string]any)
+			fn(name, server)
+		}
+	}
+}
+
+// ドットで区切ったキーの値を返す
+// キーがそのまま書かれていなければ、入れ子のテーブルをたどる
+func lookupCodexConfig(codexConfig agents.CodexConfig, key string) any {
+	if value, ok := codexConfig[key]; ok {
+		return value
+	}
+
+	var value any = map[string]any(codexConfig)
+	for part := range strings.SplitSeq(key, ".") {
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = table[part]
+	}
+	return value
+}
+
+// エージェントのネットワークへのアクセスの状況
+type NetworkExposure struct {
+	Mode           string   // network の方針。定義されていなければ空文字列
+	AllowedHosts   []string // アクセスを許すホスト名のリスト
+	Sandbox        string   // サンドボックスモード
+	SandboxNetwork bool     // サンドボックスの中のコマンドからネットワークにアクセスできるか
+	WebSearch      bool     // Web 検索を利用できるか
+	MCPServers     []string // 利用する MCP Server と、そのアクセス先（url のホストもしくは command）
+	SubAgents      []string // 利用するサブエージェント。そのアクセスはサブエージェントの定義に従う
+}
+
+// エージェントをビルドして、ネットワークへのアクセスの状況を返す
+func (app *App) NetworkExposure(agentName string) (*NetworkExposure, error) {
+	agent, err := app.buildAgent(agentName)
+	if err != nil {
+		return nil, err
+	}
+	agentConfig := app.config.Agents[agentName]
+
+	exposure := &NetworkExposure{
+		Sandbox:    agent.Sandbox,
+		MCPServers: []string{},
+		SubAgents:  slices.Clone(agentConfig.SubAgents),
+	}
+	if agentConfig.Network != nil {
+		exposure.Mode = agentConfig.Network.Mode
+		exposure.AllowedHosts = agentConfig.Network.AllowedHosts
+	}
+
+	// サンドボックスの中のコマンドからのアクセス
+	// read-only のサンドボックスはネットワークにアクセスできない
+	switch agent.Sandbox {
+	case "danger-full-access":
+		exposure.SandboxNetwork = true
+	case "workspace-write":
+		exposure.SandboxNetwork = lookupCodexConfig(agent.Config, codexNetworkAccessKey) == true
+	}
+
+	// Web 検索
+	exposure.WebSearch = lookupCodexConfig(agent.Config, codexWebSearchKey) == true ||
+		lookupCodexConfig(agent.Config, codexLegacyWebSearchKey) == true
+
+	// MCP Server のアクセス先
+	// config に直接書かれた MCP Server も含める
+	mcpServers := rawMCPServers(agent.Config)
+	for _, mcpServerName := range slices.Sorted(maps.Keys(mcpServers)) {
+		mcpServerConfig := mcpServers[mcpServerName]
+		if serverURL, _ := mcpServerConfig["url"].(string); serverURL != "" {
+			u, _ := url.Parse(serverURL)
+			exposure.MCPServers = append(exposure.MCPServers, fmt.Sprintf("%s (url: %s)", mcpServerName, u.Hostname()))
+		} else {
+			exposure.MCPServers = append(exposure.MCPServers, fmt.Sprintf("%s (command: %v)", mcpServerName, mcpServerConfig["command"]))
+		}
+	}
+
+	return exposure, nil
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kurusugawa-computer/ace/agents"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allowedHosts := []string{"api.github.com", "*.example.com"}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "api.github.com", want: true},
+		{host: "API.GitHub.com", want: true},
+		{host: "github.com", want: false},
+		{host: "evil-api.github.com", want: false},
+		{host: "a.example.com", want: true},
+		{host: "a.b.example.com", want: true},
+		{host: "example.com", want: false},
+		{host: "badexample.com", want: false},
+		{host: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := hostAllowed(tt.host, allowedHosts); got != tt.want {
+				t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyNetwork(t *testing.T) {
+	github := map[string]*MCPServerConfig{"github": {URL: "https://api.github.com/mcp"}}
+	local := map[string]*MCPServerConfig{"local": {Command: "server"}}
+
+	tests := []struct {
+		name        string
+		network     *NetworkConfig
+		sandbox     string
+		mcpServers  map[string]*MCPServerConfig
+		subAgents   []string
+		codexConfig agents.CodexConfig
+		want        agents.CodexConfig
+		wantErr     bool
+	}{
+		{
+			name:        "not set",
+			sandbox:     "workspace-write",
+			codexConfig: agents.CodexConfig{codexNetworkAccessKey: true},
+			want:        agents.CodexConfig{codexNetworkAccessKey: true},
+		},
+		{
+			name:        "none",
+			network:     &NetworkConfig{Mode: NetworkNone},
+			sandbox:     "workspace-write",
+			codexConfig: agents.CodexConfig{"sandbox_workspace_write": map[string]any{"network_access": true}},
+			want: agents.CodexConfig{
+				"sandbox_workspace_write": map[string]any{},
+				codexNetworkAccessKey:     false,
+				codexWebSearchKey:         false,
+				codexLegacyWebSearchKey:   false,
+			},
+		},
+		{
+			name:       "none with mcp_servers",
+			network:    &NetworkConfig{Mode: NetworkNone},
+			sandbox:    "read-only",
+			mcpServers: local,
+			wantErr:    true,
+		},
+		{
+			name:        "none with mcp_servers in config",
+			network:     &NetworkConfig{Mode: NetworkNone},
+			sandbox:     "read-only",
+			codexConfig: agents.CodexConfig{"mcp_servers.local.command": "server"},
+			wantErr:     true,
+		},
+		{
+			name:      "none with sub_agents",
+			network:   &NetworkConfig{Mode: NetworkNone},
+			sandbox:   "read-only",
+			subAgents: []string{"child"},
+			wantErr:   true,
+		},
+		{
+			name:       "mcp-only",
+			network:    &NetworkConfig{Mode: NetworkMCPOnly},
+			sandbox:    "workspace-write",
+			mcpServers: local,
+			subAgents:  []string{"child"},
+			want: agents.CodexConfig{
+				codexNetworkAccessKey:   false,
+				codexWebSearchKey:       false,
+				codexLegacyWebSearchKey: false,
+			},
+		},
+		{
+			name:       "mcp-only with allowed host",
+			network:    &NetworkConfig{Mode: NetworkMCPOnly, AllowedHosts: []string{"api.github.com"}},
+			sandbox:    "read-only",
+			mcpServers: github,
+			want: agents.CodexConfig{
+				codexNetworkAccessKey:   false,
+				codexWebSearchKey:       false,
+				codexLegacyWebSearchKey: false,
+			},
+		},
+		{
+			name:       "mcp-only with disallowed host",
+			network:    &NetworkConfig{Mode: NetworkMCPOnly, AllowedHosts: []string{"*.example.com"}},
+			sandbox:    "read-only",
+			mcpServers: github,
+			wantErr:    true,
+		},
+		{
+			name:    "mcp-only with disallowed host in config",
+			network: &NetworkConfig{Mode: NetworkMCPOnly, AllowedHosts: []string{"*.example.com"}},
+			sandbox: "read-only",
+			codexConfig: agents.CodexConfig{
+				"mcp_servers": map[string]any{"github": map[string]any{"url": "https://api.github.com/mcp"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "mcp-only with danger-full-access",
+			network: &NetworkConfig{Mode: NetworkMCPOnly},
+			sandbox: "danger-full-access",
+			wantErr: true,
+		},
+		{
+			name:    "allow",
+			network: &NetworkConfig{Mode: NetworkAllow},
+			sandbox: "workspace-write",
+			want:    agents.CodexConfig{codexNetworkAccessKey: true},
+		},
+		{
+			name:    "allow with read-only",
+			network: &NetworkConfig{Mode: NetworkAllow},
+			sandbox: "read-only",
+			want:    agents.CodexConfig{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codexConfig := tt.codexConfig
+			if codexConfig == nil {
+				codexConfig = agents.CodexConfig{}
+			}
+			err := applyNetwork(tt.network, tt.sandbox, tt.mcpServers, tt.subAgents, codexConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyNetwork() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(codexConfig, tt.want) {
+				t.Errorf("codexConfig = %v, want %v", codexConfig, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *NetworkConfig
+		wantErr bool
+	}{
+		{name: "none", config: &NetworkConfig{Mode: NetworkNone}},
+		{name: "mcp-only", config: &NetworkConfig{Mode: NetworkMCPOnly}},
+		{name: "allow", config: &NetworkConfig{Mode: NetworkAllow}},
+		{name: "invalid mode", config: &NetworkConfig{Mode: "deny"}, wantErr: true},
+		{name: "empty mode", config: &NetworkConfig{}, wantErr: true},
+		{name: "mcp-only with allowed_hosts", config: &NetworkConfig{Mode: NetworkMCPOnly, AllowedHosts: []string{"api.github.com", "*.example.com"}}},
+		{name: "none with allowed_hosts", config: &NetworkConfig{Mode: NetworkNone, AllowedHosts: []string{"api.github.com"}}, wantErr: true},
+		{name: "allow with allowed_hosts", config: &NetworkConfig{Mode: NetworkAllow, AllowedHosts: []string{"api.github.com"}}, wantErr: true},
+		{name: "wildcard only", config: &NetworkConfig{Mode: NetworkMCPOnly, AllowedHosts: []string{"*."}}, wantErr: true},
+		{name: "url", config: &NetworkConfig{Mode: NetworkMCPOnly, AllowedHosts: []string{"https://api.github.com"}}, wantErr: true},
+		{name: "port", config: &NetworkConfig{Mode: NetworkMCPOnly, AllowedHosts: []string{"api.github.com:443"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRawMCPServers(t *testing.T) {
+	codexConfig := agents.CodexConfig{
+		"mcp_servers":           map[string]any{"a": map[string]any{"command": "a"}},
+		"mcp_servers.b":         map[string]any{"url": "https://b.example.com"},
+		"mcp_servers.c.command": "c",
+		"model":                 "gpt-5",
+	}
+	want := map[string]map[string]any{
+		"a": {"command": "a"},
+		"b": {"url": "https://b.example.com"},
+		"c": {"command": "c"},
+	}
+	if got := rawMCPServers(codexConfig); !reflect.DeepEqual(got, want) {
+		t.Errorf("rawMCPServers() = %v, want %v", got, want)
+	}
+}
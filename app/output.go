@@ -0,0 +1,160 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/kurusugawa-computer/ace/templates"
+)
+
+// エージェントの出力の書き出し方
+type OutputOptions struct {
+	// 出力形式（json, yaml, text, template）
+	// 空文字列の場合、エージェントに output_template が定義されていれば template、そうでなければ json となる。
+	Format string
+
+	// 出力形式が template のときに用いるテンプレート
+	// 空文字列の場合はエージェントの output_template を用いる。
+	Template string
+
+	// 出力する値のパス（例: items.0.title）
+	// 空文字列の場合は出力全体を書き出す。
+	Field string
+}
+
+// エージェントの出力を指定された形式で書き出す
+func (app *App) WriteOutput(w io.Writer, agentName string, output any, options *OutputOptions) error {
+	// エージェントのConfigを取得
+	agentConfig, ok := app.config.Agents[agentName]
+	if !ok {
+		return errors.New("no such agent: " + agentName)
+	}
+
+	// 出力する値を選択
+	value, err := selectField(output, options.Field)
+	if err != nil {
+		return err
+	}
+
+	// 出力形式の解決
+	format := options.Format
+	if format == "" {
+		format = "json"
+		if options.Template != "" || agentConfig.OutputTemplate != "" {
+			format = "template"
+		}
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+
+	case "yaml":
+		b, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+
+	case "text":
+		text, err := formatText(value)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, text)
+		return err
+
+	case "template":
+		templateText := options.Template
+		if templateText == "" {
+			templateText = agentConfig.OutputTemplate
+		}
+		if templateText == "" {
+			return errors.New("output template is not specified: " + agentName)
+		}
+		outputTemplate, err := templates.Parse("output", templateText, app.config.MissingKey)
+		if err != nil {
+			return err
+		}
+		text, err := templates.Execute(outputTemplate, "", value)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, text)
+		return err
+
+	default:
+		return errors.New("unknown output format: " + format)
+	}
+}
+
+// ドット区切りのパスで出力の値を選択する
+// 配列の要素は数字で指定する
+func selectField(value any, path string) (any, error) {
+	if path == "" {
+		return value, nil
+	}
+
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		notFound := errors.New("no such field in output: " + strings.Join(keys[:i+1], "."))
+
+		switch current := value.(type) {
+		case map[string]any:
+			child, ok := current[key]
+			if !ok {
+				return nil, notFound
+			}
+			value = child
+
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, notFound
+			}
+			value = current[index]
+
+		default:
+			return nil, notFound
+		}
+	}
+
+	return value, nil
+}
+
+// 値をテキストとして表現する
+// 文字列はそのまま、オブジェクトと配列は JSON 形式とする
+func formatText(value any) (string, error) {
+	var text string
+	switch value := value.(type) {
+	case nil:
+		text = ""
+
+	case string:
+		text = value
+
+	case map[string]any, []any:
+		b, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		text = string(b)
+
+	default:
+		text = fmt.Sprint(value)
+	}
+
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	return text, nil
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectField(t *testing.T) {
+	output := map[string]any{
+		"title": "a",
+		"items": []any{
+			map[string]any{"name": "x"},
+			map[string]any{"name": "y"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    any
+		wantErr bool
+	}{
+		{name: "whole", path: "", want: output},
+		{name: "field", path: "title", want: "a"},
+		{name: "array element", path: "items.1.name", want: "y"},
+		{name: "no such field", path: "body", wantErr: true},
+		{name: "index out of range", path: "items.2", wantErr: true},
+		{name: "negative index", path: "items.-1", wantErr: true},
+		{name: "not a number", path: "items.first", wantErr: true},
+		{name: "field of string", path: "title.length", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectField(output, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectField(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectField(%q) = %#v, want %#v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	app := &App{
+		config: &Config{
+			Agents: map[string]*AgentConfig{
+				"plain":     {},
+				"templated": {OutputTemplate: "# {{ .title }}"},
+			},
+		},
+	}
+	output := map[string]any{"title": "a", "count": float64(2)}
+
+	tests := []struct {
+		name      string
+		agentName string
+		options   *OutputOptions
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "json by default",
+			agentName: "plain",
+			options:   &OutputOptions{},
+			want:      "{\n  \"count\": 2,\n  \"title\": \"a\"\n}\n",
+		},
+		{
+			name:      "template by default",
+			agentName: "templated",
+			options:   &OutputOptions{},
+			want:      "# a",
+		},
+		{
+			name:      "json overrides output_template",
+			agentName: "templated",
+			options:   &OutputOptions{Format: "json", Field: "title"},
+			want:      "\"a\"\n",
+		},
+		{
+			name:      "yaml",
+			agentName: "plain",
+			options:   &OutputOptions{Format: "yaml"},
+			want:      "count: 2.0\ntitle: a\n",
+		},
+		{
+			name:      "text of string",
+			agentName: "plain",
+			options:   &OutputOptions{Format: "text", Field: "title"},
+			want:      "a\n",
+		},
+		{
+			name:      "text of number",
+			agentName: "plain",
+			options:   &OutputOptions{Format: "text", Field: "count"},
+			want:      "2\n",
+		},
+		{
+			name:      "template option",
+			agentName: "plain",
+			options:   &OutputOptions{Template: "{{ .count }} items"},
+			want:      "2 items",
+		},
+		{
+			name:      "template without template",
+			agentName: "plain",
+			options:   &OutputOptions{Format: "template"},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown format",
+			agentName: "plain",
+			options:   &OutputOptions{Format: "xml"},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown field",
+			agentName: "plain",
+			options:   &OutputOptions{Field: "body"},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown agent",
+			agentName: "missing",
+			options:   &OutputOptions{},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &strings.Builder{}
+			err := app.WriteOutput(w, tt.agentName, output, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && w.String() != tt.want {
+				t.Errorf("WriteOutput() = %q, want %q", w.String(), tt.want)
+			}
+		})
+	}
+}
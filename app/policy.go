@@ -0,0 +1,240 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/kurusugawa-computer/ace/agents"
+)
+
+// セキュリティポリシーに反するエージェントの定義のエラー
+var ErrPolicyViolation = errors.New("denied by policy")
+
+// すべてのエージェントに適用する組織のセキュリティポリシー
+// 各項目は省略すると制限せず、空のリストを指定するとすべて許さない。
+type Policy struct {
+	// ポリシーファイルのパス
+	// YAML ファイルには記載しない。
+	Path string `yaml:"-"`
+
+	// 許すサンドボックスモード
+	SandboxModes []string `yaml:"sandbox_modes,omitempty"` // read-only, workspace-write, danger-full-access
+
+	// 許す承認ポリシー
+	ApprovalPolicies []string `yaml:"approval_policies,omitempty"` // untrusted, on-failure, on-request, never
+
+	// STDIO 形式の MCP Server として許すコマンド
+	// コマンドの指定そのものか、パスを除いたコマンド名が一致すれば許す。
+	MCPServerCommands []string `yaml:"mcp_server_commands,omitempty"`
+
+	// Streamable HTTP 形式の MCP Server として許すホスト名（*.example.com はサブドメインに一致する）
+	MCPServerHosts []string `yaml:"mcp_server_hosts,omitempty"`
+
+	// 許すモデル（gpt-5* のようなパターンも指定できる）
+	// 指定した場合、モデルを指定していないエージェントは許さない。
+	Models []string `yaml:"models,omitempty"`
+
+	// エージェントと MCP Server のタイムアウトの秒数の上限
+	MaxTimeoutSec int `yaml:"max_timeout_sec,omitempty"`
+
+	// サンドボックスの中のコマンドからのネットワークへのアクセス（sandbox_workspace_write.network_access）を許すか
+	SandboxNetworkAccess *bool `yaml:"sandbox_network_access,omitempty"`
+
+	// 作業ディレクトリのほかに書き込めるディレクトリ（sandbox_workspace_write.writable_roots）の指定を許すか
+	WritableRoots *bool `yaml:"writable_roots,omitempty"`
+
+	// あわせて検査するポリシー
+	// --policy で指定したポリシーがデフォルトのパスのポリシーを緩めないように、デフォルトのパスのポリシーを設定する。
+	// YAML ファイルには記載しない。
+	Base *Policy `yaml:"-"`
+}
+
+// ポリシーファイルのデフォルトのパスを返す
+// 組織で配布することを想定して、ユーザーの設定ディレクトリ（Linux では ~/.config/ace/policy.yaml）に置く
+func DefaultPolicyPath(appName string) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, appName, "policy.yaml"), nil
+}
+
+// ポリシーファイルを読み込む
+// 誤りに気づけるように、未知のフィールドはエラーとする
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := yaml.UnmarshalWithOptions(b, policy, yaml.DisallowUnknownField()); err != nil {
+		return nil, err
+	}
+	if policy.MaxTimeoutSec < 0 {
+		return nil, errors.New("max_timeout_sec must not be negative")
+	}
+	policy.Path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// 解決したエージェントの定義がポリシーに反していないか検査する
+// codexConfig は config に直接書かれたものと mcp_servers をマージした、最終的な Codex の Config
+func (policy *Policy) check(agentConfig *AgentConfig, sandbox string, approvalPolicy string, codexConfig agents.CodexConfig, fallbackModels []*agents.FallbackModel) error {
+	if policy.Base != nil {
+		if err := policy.Base.check(agentConfig, sandbox, approvalPolicy, codexConfig, fallbackModels); err != nil {
+			return err
+		}
+	}
+
+	denied := func(format string, args ...any) error {
+		return fmt.Errorf("%w (%s): %s", ErrPolicyViolation, policy.Path, fmt.Sprintf(format, args...))
+	}
+
+	// サンドボックスモードと承認ポリシー
+	// config に直接書かれた指定も検査する
+	sandboxModes := []string{sandbox}
+	if value, ok := codexConfig["sandbox_mode"]; ok {
+		sandboxModes = append(sandboxModes, fmt.Sprint(value))
+	}
+	approvalPolicies := []string{approvalPolicy}
+	if value, ok := codexConfig["approval_policy"]; ok {
+		approvalPolicies = append(approvalPolicies, fmt.Sprint(value))
+	}
+	for _, sandbox := range sandboxModes {
+		if policy.SandboxModes != nil && !slices.Contains(policy.SandboxModes, sandbox) {
+			return denied("sandbox %s is not allowed (allowed: %s)", sandbox, allowedText(policy.SandboxModes))
+		}
+	}
+	for _, approvalPolicy := range approvalPolicies {
+		if policy.ApprovalPolicies != nil && !slices.Contains(policy.ApprovalPolicies, approvalPolicy) {
+			return denied("approval_policy %s is not allowed (allowed: %s)", approvalPolicy, allowedText(policy.ApprovalPolicies))
+		}
+	}
+
+	// サンドボックスの中のコマンドからのネットワークへのアクセスと、書き込めるディレクトリ
+	if policy.SandboxNetworkAccess != nil && !*policy.SandboxNetworkAccess {
+		if lookupCodexConfig(codexConfig, codexNetworkAccessKey) == true {
+			return denied("sandbox_workspace_write.network_access is not allowed")
+		}
+	}
+	if policy.WritableRoots != nil && !*policy.WritableRoots {
+		if len(agentConfig.WritableRoots) > 0 || lookupCodexConfig(codexConfig, "sandbox_workspace_write.writable_roots") != nil {
+			return denied("writable_roots is not allowed")
+		}
+	}
+
+	// MCP Server のコマンドとホスト
+	// mcp_servers の定義はマージ済みなので、config に直接書かれたものとあわせて、書かれた単位ごとに検査する
+	var err error
+	walkMCPServers(codexConfig, func(mcpServerName string, mcpServerConfig map[string]any) {
+		if err == nil {
+			err = policy.checkMCPServer(mcpServerName, mcpServerConfig, denied)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// モデル（代替のモデルと、回答を整形するモデルを含む）
+	if policy.Models != nil {
+		model, _ := codexConfig["model"].(string)
+		if model == "" {
+			return denied("model must be specified (allowed: %s)", allowedText(policy.Models))
+		}
+		models := []string{model}
+		for _, fallbackModel := range fallbackModels {
+			models = append(models, fallbackModel.Model)
+		}
+		if agentConfig.RepairModel != "" {
+			models = append(models, agentConfig.RepairModel)
+		}
+		for _, model := range models {
+			if !modelAllowed(model, policy.Models) {
+				return denied("model %s is not allowed (allowed: %s)", model, allowedText(policy.Models))
+			}
+		}
+	}
+
+	// タイムアウト
+	timeoutSec := agentConfig.TimeoutSec
+	if timeoutSec == 0 {
+		timeoutSec = DefaultTimeoutSec
+	}
+	if policy.MaxTimeoutSec > 0 && timeoutSec > policy.MaxTimeoutSec {
+		return denied("timeout_sec %d exceeds max_timeout_sec %d (set timeout_sec)", timeoutSec, policy.MaxTimeoutSec)
+	}
+
+	return nil
+}
+
+// MCP Server の定義がポリシーに反していないか検査する
+func (policy *Policy) checkMCPServer(mcpServerName string, mcpServerConfig map[string]any, denied func(format string, args ...any) error) error {
+	if command, ok := mcpServerConfig["command"]; ok && policy.MCPServerCommands != nil {
+		command := fmt.Sprint(command)
+		if !slices.Contains(policy.MCPServerCommands, command) && !slices.Contains(policy.MCPServerCommands, filepath.Base(command)) {
+			return denied("mcp_servers.%s: command %s is not allowed (allowed: %s)", mcpServerName, command, allowedText(policy.MCPServerCommands))
+		}
+	}
+	if serverURL, ok := mcpServerConfig["url"]; ok && policy.MCPServerHosts != nil {
+		u, err := url.Parse(fmt.Sprint(serverURL))
+		if err != nil {
+			return err
+		}
+		if !hostAllowed(u.Hostname(), policy.MCPServerHosts) {
+			return denied("mcp_servers.%s: host %s is not allowed (allowed: %s)", mcpServerName, u.Hostname(), allowedText(policy.MCPServerHosts))
+		}
+	}
+
+	if policy.MaxTimeoutSec > 0 {
+		// startup_timeout_ms はミリ秒で指定する
+		for _, key := range []string{"startup_timeout_sec", "startup_timeout_ms", "tool_timeout_sec"} {
+			value, ok := mcpServerConfig[key]
+			if !ok {
+				continue
+			}
+			timeoutSec, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+			if err != nil {
+				return fmt.Errorf("mcp_servers.%s: invalid %s: %v", mcpServerName, key, value)
+			}
+			if key == "startup_timeout_ms" {
+				timeoutSec /= 1000
+			}
+			if timeoutSec > float64(policy.MaxTimeoutSec) {
+				return denied("mcp_servers.%s: timeout %v sec exceeds max_timeout_sec %d", mcpServerName, timeoutSec, policy.MaxTimeoutSec)
+			}
+		}
+	}
+
+	return nil
+}
+
+// モデルが許すモデルのパターンのいずれかに一致するか判定する
+func modelAllowed(model string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, model); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func allowedText(allowed []string) string {
+	if len(allowed) == 0 {
+		return "none"
+	}
+	return strings.Join(allowed, ", ")
+}
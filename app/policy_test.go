@@ -0,0 +1,204 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kurusugawa-computer/ace/agents"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	deny := false
+	policy := &Policy{
+		Path:                 "/etc/policy.yaml",
+		SandboxModes:         []string{"read-only", "workspace-write"},
+		ApprovalPolicies:     []string{"never"},
+		MCPServerCommands:    []string{"uvx"},
+		MCPServerHosts:       []string{"*.example.com"},
+		Models:               []string{"gpt-5*"},
+		MaxTimeoutSec:        3600,
+		SandboxNetworkAccess: &deny,
+		WritableRoots:        &deny,
+	}
+
+	tests := []struct {
+		name           string
+		agentConfig    *AgentConfig
+		sandbox        string
+		approvalPolicy string
+		codexConfig    agents.CodexConfig
+		fallbackModels []*agents.FallbackModel
+		wantDenied     bool
+	}{
+		{
+			name:        "allowed",
+			codexConfig: agents.CodexConfig{"mcp_servers.a": map[string]any{"command": "/usr/bin/uvx", "tool_timeout_sec": 60}},
+		},
+		{
+			name:       "sandbox",
+			sandbox:    "danger-full-access",
+			wantDenied: true,
+		},
+		{
+			name:        "sandbox_mode in config",
+			codexConfig: agents.CodexConfig{"sandbox_mode": "danger-full-access"},
+			wantDenied:  true,
+		},
+		{
+			name:           "approval_policy",
+			approvalPolicy: "on-request",
+			wantDenied:     true,
+		},
+		{
+			name:        "approval_policy in config",
+			codexConfig: agents.CodexConfig{"approval_policy": "on-request"},
+			wantDenied:  true,
+		},
+		{
+			name:        "mcp_servers command",
+			codexConfig: agents.CodexConfig{"mcp_servers.a": map[string]any{"command": "bash"}},
+			wantDenied:  true,
+		},
+		{
+			name:        "mcp_servers command in dotted key",
+			codexConfig: agents.CodexConfig{"mcp_servers.a.command": "bash"},
+			wantDenied:  true,
+		},
+		{
+			name:        "mcp_servers command in table",
+			codexConfig: agents.CodexConfig{"mcp_servers": map[string]any{"a": map[string]any{"command": "bash"}}},
+			wantDenied:  true,
+		},
+		{
+			name: "mcp_servers command overwritten in config",
+			codexConfig: agents.CodexConfig{
+				"mcp_servers.a":         map[string]any{"command": "uvx"},
+				"mcp_servers.a.command": "bash",
+			},
+			wantDenied: true,
+		},
+		{
+			name:        "mcp_servers allowed host",
+			codexConfig: agents.CodexConfig{"mcp_servers.a.url": "https://mcp.example.com/mcp"},
+		},
+		{
+			name:        "mcp_servers host",
+			codexConfig: agents.CodexConfig{"mcp_servers": map[string]any{"a": map[string]any{"url": "https://evil.test/mcp"}}},
+			wantDenied:  true,
+		},
+		{
+			name:        "mcp_servers timeout",
+			codexConfig: agents.CodexConfig{"mcp_servers.a": map[string]any{"command": "uvx", "startup_timeout_sec": uint64(7200)}},
+			wantDenied:  true,
+		},
+		{
+			name:        "mcp_servers startup_timeout_ms",
+			codexConfig: agents.CodexConfig{"mcp_servers.a": map[string]any{"command": "uvx", "startup_timeout_ms": uint64(7200000)}},
+			wantDenied:  true,
+		},
+		{
+			name:        "network_access",
+			codexConfig: agents.CodexConfig{"sandbox_workspace_write.network_access": true},
+			wantDenied:  true,
+		},
+		{
+			name:        "network_access in table",
+			codexConfig: agents.CodexConfig{"sandbox_workspace_write": map[string]any{"network_access": true}},
+			wantDenied:  true,
+		},
+		{
+			name:        "network_access disabled",
+			codexConfig: agents.CodexConfig{"sandbox_workspace_write.network_access": false},
+		},
+		{
+			name:        "writable_roots",
+			agentConfig: &AgentConfig{WritableRoots: []string{"out"}},
+			wantDenied:  true,
+		},
+		{
+			name:        "writable_roots in config",
+			codexConfig: agents.CodexConfig{"sandbox_workspace_write": map[string]any{"writable_roots": []any{"/"}}},
+			wantDenied:  true,
+		},
+		{
+			name:        "model not specified",
+			codexConfig: agents.CodexConfig{"model": ""},
+			wantDenied:  true,
+		},
+		{
+			name:        "model",
+			codexConfig: agents.CodexConfig{"model": "o3"},
+			wantDenied:  true,
+		},
+		{
+			name:           "fallback model",
+			fallbackModels: []*agents.FallbackModel{{Model: "o3"}},
+			wantDenied:     true,
+		},
+		{
+			name:        "repair_model",
+			agentConfig: &AgentConfig{RepairModel: "o3"},
+			wantDenied:  true,
+		},
+		{
+			name:        "allowed repair_model",
+			agentConfig: &AgentConfig{RepairModel: "gpt-5-mini"},
+		},
+		{
+			name:        "timeout_sec",
+			agentConfig: &AgentConfig{TimeoutSec: 7200},
+			wantDenied:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agentConfig := tt.agentConfig
+			if agentConfig == nil {
+				agentConfig = &AgentConfig{}
+			}
+			sandbox := tt.sandbox
+			if sandbox == "" {
+				sandbox = "read-only"
+			}
+			approvalPolicy := tt.approvalPolicy
+			if approvalPolicy == "" {
+				approvalPolicy = "never"
+			}
+			codexConfig := agents.CodexConfig{"model": "gpt-5"}
+			for key, value := range tt.codexConfig {
+				codexConfig[key] = value
+			}
+
+			err := policy.check(agentConfig, sandbox, approvalPolicy, codexConfig, tt.fallbackModels)
+			if denied := errors.Is(err, ErrPolicyViolation); denied != tt.wantDenied {
+				t.Errorf("check() error = %v, wantDenied %v", err, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckBase(t *testing.T) {
+	base := &Policy{Path: "/etc/policy.yaml", SandboxModes: []string{"read-only"}}
+
+	tests := []struct {
+		name       string
+		policy     *Policy
+		sandbox    string
+		wantDenied bool
+	}{
+		{name: "allowed by both", policy: &Policy{Base: base}, sandbox: "read-only"},
+		{name: "loosened", policy: &Policy{SandboxModes: []string{"danger-full-access"}, Base: base}, sandbox: "danger-full-access", wantDenied: true},
+		{name: "tightened", policy: &Policy{SandboxModes: []string{}, Base: base}, sandbox: "read-only", wantDenied: true},
+		{name: "without base", policy: &Policy{SandboxModes: []string{"danger-full-access"}}, sandbox: "danger-full-access"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.check(&AgentConfig{}, tt.sandbox, "never", agents.CodexConfig{}, nil)
+			if denied := errors.Is(err, ErrPolicyViolation); denied != tt.wantDenied {
+				t.Errorf("check() error = %v, wantDenied %v", err, tt.wantDenied)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"os"
+	"strings"
+
+	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/kurusugawa-computer/ace/redact"
+)
+
+// 値を秘密の情報とみなす名前に含まれる語
+// MCP Server の env と http_headers のうち、名前にこれらを含むものの値を取り除く
+var secretNameWords = []string{"key", "token", "secret", "password", "auth", "credential"}
+
+// YAML ファイルの定義と API Key から、秘密の情報を取り除く Redactor を返す
+// 取り除かない設定の場合は nil を返す
+func newRedactor(config *Config, apiKey string) *redact.Redactor {
+	redactionConfig := config.Redaction
+	if redactionConfig == nil {
+		redactionConfig = &RedactionConfig{}
+	}
+	if redactionConfig.Enabled != nil && !*redactionConfig.Enabled {
+		return nil
+	}
+
+	// patterns は LoadConfig で検証済みなので、構文解析できないものは無視する
+	patterns, _ := redactionConfig.Regexps()
+
+	// 資格情報の値
+	values := []string{apiKey}
+	for _, agentConfig := range config.Agents {
+		for _, mcpServerConfig := range agentConfig.MCPServers {
+			if mcpServerConfig == nil {
+				continue
+			}
+			for name, value := range mcpServerConfig.Env {
+				if isSecretName(name) {
+					values = append(values, value)
+				}
+			}
+			for name, value := range mcpServerConfig.HTTPHeaders {
+				if isSecretName(name) {
+					values = append(values, value)
+				}
+			}
+			for name, envVar := range mcpServerConfig.EnvHTTPHeaders {
+				if isSecretName(name) {
+					values = append(values, os.Getenv(envVar))
+				}
+			}
+			if mcpServerConfig.BearerTokenEnvVar != "" {
+				values = append(values, os.Getenv(mcpServerConfig.BearerTokenEnvVar))
+			}
+		}
+	}
+
+	return redact.New(patterns, values...)
+}
+
+// 名前が秘密の情報を表すものか判定する
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretNameWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// 実行記録から秘密の情報を取り除いたものを返す
+// 呼び出し元に返す実行記録は書き換えない
+func (app *App) redactRun(run *journal.Run) *journal.Run {
+	if app.redactor == nil {
+		return run
+	}
+
+	redacted := *run
+	redacted.Input, _ = app.redactor.Value(run.Input).(map[string]any)
+	redacted.Prompt = app.redactor.String(run.Prompt)
+	redacted.Answer = app.redactor.String(run.Answer)
+	redacted.Output = app.redactor.Value(run.Output)
+	redacted.Diff = app.redactor.String(run.Diff)
+	redacted.Error = app.redactor.String(run.Error)
+	redacted.Checks = make([]*journal.Check, 0, len(run.Checks))
+	for _, check := range run.Checks {
+		redacted.Checks = append(redacted.Checks, &journal.Check{
+			Name:    check.Name,
+			Passed:  check.Passed,
+			Message: app.redactor.String(check.Message),
+		})
+	}
+
+	return &redacted
+}
+
+// 秘密の情報を取り除いてから回答をキャッシュする Cache
+// キャッシュした回答を返すときの出力が変わらないように、出力は redaction.outputs の場合のみ取り除く
+type redactedCache struct {
+	agents.Cache
+	redactor *redact.Redactor
+	outputs  bool
+}
+
+func (cache *redactedCache) Save(key string, result *agents.Result) error {
+	redacted := *result
+	redacted.Prompt = cache.redactor.String(result.Prompt)
+	redacted.Answer = cache.redactor.String(result.Answer)
+	if cache.outputs {
+		redacted.Output = cache.redactor.Value(result.Output)
+	}
+	return cache.Cache.Save(key, &redacted)
+}
+
+// 出力からも秘密の情報を取り除く設定か
+func (app *App) redactsOutputs() bool {
+	return app.redactor != nil && app.config.Redaction != nil && app.config.Redaction.Outputs
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/redact"
+)
+
+// 保存した回答を覚えておく Cache
+type memoryCache map[string]*agents.Result
+
+func (cache memoryCache) Load(key string, ttl time.Duration) (*agents.Result, error) {
+	return cache[key], nil
+}
+
+func (cache memoryCache) Save(key string, result *agents.Result) error {
+	cache[key] = result
+	return nil
+}
+
+func TestRedactedCache(t *testing.T) {
+	const secret = "my-secret-value"
+
+	tests := []struct {
+		name    string
+		outputs bool
+		want    *agents.Result
+	}{
+		{
+			name: "prompt and answer",
+			want: &agents.Result{Prompt: redact.Placeholder, Answer: "a " + redact.Placeholder, Output: map[string]any{"v": secret}, Model: "gpt-5"},
+		},
+		{
+			name:    "outputs",
+			outputs: true,
+			want:    &agents.Result{Prompt: redact.Placeholder, Answer: "a " + redact.Placeholder, Output: map[string]any{"v": redact.Placeholder}, Model: "gpt-5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := memoryCache{}
+			cache := &redactedCache{Cache: memory, redactor: redact.New(nil, secret), outputs: tt.outputs}
+			result := &agents.Result{Prompt: secret, Answer: "a " + secret, Output: map[string]any{"v": secret}, Model: "gpt-5"}
+
+			if err := cache.Save("key", result); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := cache.Load("key", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, tt.want) {
+				t.Errorf("Load() = %+v, want %+v", loaded, tt.want)
+			}
+			if result.Prompt != secret {
+				t.Error("Save() should not modify the result")
+			}
+		})
+	}
+}
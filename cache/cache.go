@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/thamaji/files"
+)
+
+// キャッシュした回答
+type entry struct {
+	Key       string    `json:"key"`
+	Prompt    string    `json:"prompt,omitempty"`
+	Answer    string    `json:"answer,omitempty"`
+	Output    any       `json:"output,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 回答をキャッシュするディレクトリ
+type Cache struct {
+	dir string
+}
+
+var _ agents.Cache = (*Cache)(nil)
+
+func New(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// ユーザーのキャッシュディレクトリ以下に回答をキャッシュする Cache を返す
+func Open(appName string) (*Cache, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(filepath.Join(cacheDir, appName, "responses")), nil
+}
+
+// ttl より新しいキャッシュを返す
+// キャッシュがないか、古くなっている場合は nil を返す
+func (cache *Cache) Load(key string, ttl time.Duration) (*agents.Result, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) {
+		return nil, errors.New("invalid cache key: " + key)
+	}
+
+	f, err := files.OpenFileReader(filepath.Join(cache.dir, key+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entry := &entry{}
+	err = json.NewDecoder(f).Decode(entry)
+	f.Close()
+	if err != nil {
+		return nil, nil // 壊れたキャッシュは無視する
+	}
+
+	if ttl > 0 && time.Since(entry.CreatedAt) > ttl {
+		return nil, nil
+	}
+
+	return &agents.Result{Output: entry.Output, Prompt: entry.Prompt, Answer: entry.Answer, Model: entry.Model}, nil
+}
+
+// 回答をキャッシュする
+func (cache *Cache) Save(key string, result *agents.Result) error {
+	if key == "" || strings.ContainsAny(key, `/\`) {
+		return errors.New("invalid cache key: " + key)
+	}
+
+	f, err := files.OpenFileWriter(filepath.Join(cache.dir, key+".json"))
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(&entry{
+		Key:       key,
+		Prompt:    result.Prompt,
+		Answer:    result.Answer,
+		Output:    result.Output,
+		Model:     result.Model,
+		CreatedAt: time.Now(),
+	})
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kurusugawa-computer/ace/agents"
+)
+
+func TestSaveLoad(t *testing.T) {
+	cache := New(t.TempDir())
+	result := &agents.Result{
+		Output: map[string]any{"answer": "a"},
+		Prompt: "prompt",
+		Answer: "answer",
+		Model:  "gpt-5",
+	}
+
+	if err := cache.Save("key", result); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := cache.Load("key", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, result) {
+		t.Errorf("Load() = %+v, want %+v", loaded, result)
+	}
+
+	// ttl が 0 の場合は期限を設けない
+	loaded, err = cache.Load("key", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil {
+		t.Error("Load() with ttl 0 = nil, want the saved result")
+	}
+}
+
+func TestLoadMiss(t *testing.T) {
+	dir := t.TempDir()
+	cache := New(dir)
+
+	// 古いキャッシュ
+	b, err := json.Marshal(&entry{Key: "old", Answer: "answer", CreatedAt: time.Now().Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 壊れたキャッシュ
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		ttl  time.Duration
+		hit  bool
+	}{
+		{name: "missing", key: "missing", ttl: time.Hour},
+		{name: "expired", key: "old", ttl: time.Hour},
+		{name: "not expired", key: "old", ttl: 3 * time.Hour, hit: true},
+		{name: "broken", key: "broken", ttl: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := cache.Load(tt.key, tt.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (result != nil) != tt.hit {
+				t.Errorf("Load(%q, %s) = %+v, want hit %v", tt.key, tt.ttl, result, tt.hit)
+			}
+		})
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	cache := New(t.TempDir())
+	for _, key := range []string{"", "../a", `a\b`} {
+		if err := cache.Save(key, &agents.Result{}); err == nil {
+			t.Errorf("Save() with key %q should fail", key)
+		}
+		if _, err := cache.Load(key, time.Hour); err == nil {
+			t.Errorf("Load() with key %q should fail", key)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kurusugawa-computer/ace/app"
+	"github.com/kurusugawa-computer/ace/cache"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/urfave/cli/v3"
+)
+
+var _ subCommand = chat
+
+func chat(appName string, version string) *cli.Command {
+	return &cli.Command{
+		Name:    "chat",
+		Aliases: []string{},
+		Usage: `Chat with an AI agent defined in a YAML file.
+The first message is rendered from prompt_template with KEY=VALUE pairs, and free text is sent after that.`,
+		ArgsUsage: "AGENT_NAME [KEY=VALUE...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "set YAML file where the AI ​​agent is defined",
+				Value:   "agent.yaml",
+			},
+			&cli.StringFlag{
+				Name:    "workdir",
+				Aliases: []string{"w"},
+				Usage:   "set working directory",
+				Value:   ".",
+			},
+			&cli.StringSliceFlag{
+				Name:  "env-file",
+				Usage: "set an alternate environment file",
+				Value: []string{".env"},
+			},
+			&cli.StringFlag{
+				Name:  "codex-path",
+				Usage: "set codex executable path",
+				Value: "codex",
+			},
+			&cli.StringFlag{
+				Name:        "log-level",
+				Usage:       "set log-level (\"error\", \"warn\", \"info\", \"debug\", \"trace\", \"off\", default: \"off\")",
+				HideDefault: true,
+				Value:       "off",
+			},
+			&cli.StringFlag{
+				Name:  "session",
+				Usage: "continue the conversation of the named session (the session is created if it does not exist)",
+			},
+			&cli.StringFlag{
+				Name:  "resume",
+				Usage: "continue the conversation of the specified RUN_ID (prompt_template is not used)",
+			},
+			&cli.BoolFlag{
+				Name:  "cache",
+				Usage: "use cached responses for all agents, even if cache is not defined in the YAML file",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "do not use cached responses",
+			},
+			&cli.StringFlag{
+				Name:  "policy",
+				Usage: "set security policy file (default: policy.yaml in the user config directory, if it exists)",
+			},
+		},
+		Arguments: []cli.Argument{},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			// オプション引数の値を取得
+			configPath := cmd.String("config")
+			workdir := cmd.String("workdir")
+			envFiles := cmd.StringSlice("env-file")
+			codexPath := cmd.String("codex-path")
+			logLevel := cmd.String("log-level")
+			session := cmd.String("session")
+			resume := cmd.String("resume")
+
+			// エージェントを定義したYAMLファイルを読み込み
+			config, err := app.LoadConfig(configPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load agent defined YAML file.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// セキュリティポリシーを読み込む
+			policy, err := loadPolicy(cmd, appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load policy file.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 回答のキャッシュの利用方法を取得
+			cacheMode, err := cacheMode(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid cache options: %s\n", err)
+				return fmt.Errorf("%w: %s", ErrUsage, err)
+			}
+
+			// エージェント名のチェック
+			if cmd.Args().Len() == 0 {
+				fmt.Fprintf(os.Stderr, "Please specify AGENT_NAME.\n")
+				return fmt.Errorf("%w: %s", ErrUsage, err)
+			}
+
+			// OpenAI の API Key を取得
+			// 資格情報のプロファイルを利用するエージェントでは必須としない
+			apiKey, err := getAPIKey(ctx, appName, codexPath, envFiles, !usesCredentialProfile(config, cmd.Args().First()))
+			if err != nil {
+				return err
+			}
+
+			// 回答のキャッシュの保存先を開く
+			responseCache, err := cache.Open(appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open response cache.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 実行記録の保存先を開く
+			journal, err := journal.Open(appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open run journal.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 会話の継続
+			options := &app.RunOptions{
+				Session: session,
+				Resume:  resume,
+			}
+
+			// 出力形式
+			outputOptions := &app.OutputOptions{}
+
+			// アプリケーションをつくる
+			app := app.New(
+				config,
+				codexPath,
+				apiKey,
+				subAgentMCPServerConfig(configPath, codexPath, apiKey, slices.Concat(cacheModeFlags(cacheMode), policyFlags(policy))),
+				app.WithLogger(os.Stderr, logLevel),
+				app.WithJournal(journal),
+				app.WithCredentialResolver(credentialResolver(appName)),
+				app.WithCache(responseCache, cacheMode),
+				app.WithPolicy(policy),
+			)
+			agentName := cmd.Args().First()
+
+			// 最初のメッセージは prompt_template から構築する
+			// ただし、実行IDが指定されている場合はその続きから自由入力で会話する
+			if options.Resume == "" {
+				run, err := app.RunAgent(agentName, workdir, cmd.Args().Tail(), options)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to start AI agent\n")
+					return fmt.Errorf("%w: %s", ErrInternal, err)
+				}
+				if err := app.WriteOutput(os.Stdout, agentName, run.Output, outputOptions); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to write output.\n")
+					return fmt.Errorf("%w: %s", ErrInternal, err)
+				}
+				printChecks(run)
+				options.Resume = run.ID
+				options.Input = run.Input
+			} else {
+				resumed, err := journal.Load(options.Resume)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to load run: %s\n", options.Resume)
+					return fmt.Errorf("%w: %s", ErrInternal, err)
+				}
+				options.Input = resumed.Input
+			}
+
+			// 以降は自由入力のメッセージで会話を継続する
+			// instruction や workdir のテンプレートは、最初の実行の入力で展開する
+			fmt.Fprintf(os.Stderr, "Type a message to continue the conversation (\"exit\" or Ctrl+D to quit).\n")
+			scanner := bufio.NewScanner(os.Stdin)
+			for {
+				fmt.Fprintf(os.Stderr, "> ")
+				if !scanner.Scan() {
+					fmt.Fprintln(os.Stderr)
+					break
+				}
+
+				message := strings.TrimSpace(scanner.Text())
+				if message == "" {
+					continue
+				}
+				if message == "exit" || message == "quit" {
+					break
+				}
+
+				options.Message = message
+				run, err := app.RunAgent(agentName, workdir, nil, options)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+					continue
+				}
+				if err := app.WriteOutput(os.Stdout, agentName, run.Output, outputOptions); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to write output.\n")
+					return fmt.Errorf("%w: %s", ErrInternal, err)
+				}
+				printChecks(run)
+				options.Resume = run.ID
+			}
+			if err := scanner.Err(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read message.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			fmt.Fprintf(os.Stderr, "Run ID: %s\n", options.Resume)
+
+			return nil
+		},
+	}
+}
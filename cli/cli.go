@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/joho/godotenv"
 	"github.com/kurusugawa-computer/ace/agents"
+	"github.com/kurusugawa-computer/ace/app"
 	"github.com/kurusugawa-computer/ace/cli/credentials"
+	"github.com/kurusugawa-computer/ace/workspace"
 	"github.com/thamaji/codex-go"
 	"github.com/urfave/cli/v3"
 )
@@ -26,8 +29,13 @@ func New(appName string, version string, title string) *cli.Command {
 		HideVersion:           false,
 		Commands: []*cli.Command{
 			exec(appName, version),
+			chat(appName, version),
 			mcp(appName, version),
+			mcpClient(appName, version),
+			doctor(appName, version),
+			validate(appName, version),
 			setup(appName, version),
+			credentialProfiles(appName, version),
 		},
 	}
 }
@@ -35,33 +43,35 @@ func New(appName string, version string, title string) *cli.Command {
 type subCommand func(appName string, version string) *cli.Command
 
 // サブエージェントを実行するMCP Serverの起動方法を返す関数を返す関数
-func subAgentMCPServerConfig(configPath string, workdir string, codexPath string, apiKey string) func(subAgent *agents.SubAgent) (map[string]any, error) {
-	return func(subAgent *agents.SubAgent) (map[string]any, error) {
+// flags はサブエージェントの MCP Server に引き継ぐオプション引数
+// サブエージェントは、親のエージェントの作業ディレクトリ（隔離した場合はそのディレクトリ）で実行する
+func subAgentMCPServerConfig(configPath string, codexPath string, apiKey string, flags []string) func(subAgent *agents.SubAgent, parent *agents.RuntimeContext) (map[string]any, error) {
+	return func(subAgent *agents.SubAgent, parent *agents.RuntimeContext) (map[string]any, error) {
 		// 設定ファイルの絶対パスを取得
 		configAbsPath, err := filepath.Abs(configPath)
 		if err != nil {
 			return nil, err
 		}
 
-		// 作業ディレクトリの絶対パスを取得
-		workdirAbsPath, err := filepath.Abs(workdir)
-		if err != nil {
-			return nil, err
-		}
-
 		// サブエージェント MCP Server 用の Config を構築
+		args := []string{
+			"mcp-server",
+			"--config",
+			configAbsPath,
+			"--workdir",
+			parent.Workdir,
+			"--codex-path",
+			codexPath,
+			"--parent-agent",
+			parent.Agent,
+			"--depth",
+			strconv.Itoa(parent.Depth + 1),
+		}
+		args = append(args, flags...)
+		args = append(args, subAgent.Name)
 		config := map[string]any{
-			"command": os.Args[0],
-			"args": []string{
-				"mcp-server",
-				"--config",
-				configAbsPath,
-				"--workdir",
-				workdirAbsPath,
-				"--codex-path",
-				codexPath,
-				subAgent.Name,
-			},
+			"command":             os.Args[0],
+			"args":                args,
 			"startup_timeout_sec": 30,
 			"tool_timeout_sec":    subAgent.TimeoutSec,
 		}
@@ -75,40 +85,201 @@ func subAgentMCPServerConfig(configPath string, workdir string, codexPath string
 	}
 }
 
+// --cache と --no-cache から、回答のキャッシュの利用方法を返す
+func cacheMode(cmd *cli.Command) (string, error) {
+	switch {
+	case cmd.Bool("cache") && cmd.Bool("no-cache"):
+		return "", errors.New("--cache and --no-cache cannot be specified at the same time")
+	case cmd.Bool("cache"):
+		return app.CacheModeAll, nil
+	case cmd.Bool("no-cache"):
+		return app.CacheModeNone, nil
+	default:
+		return app.CacheModeAuto, nil
+	}
+}
+
+// サブエージェントの MCP Server に引き継ぐ、キャッシュの利用方法のオプション引数を返す
+func cacheModeFlags(mode string) []string {
+	switch mode {
+	case app.CacheModeAll:
+		return []string{"--cache"}
+	case app.CacheModeNone:
+		return []string{"--no-cache"}
+	default:
+		return nil
+	}
+}
+
+// --isolate と --changes から、作業ディレクトリを隔離する方法と変更をどうするかを返す
+func isolation(cmd *cli.Command) (string, string, error) {
+	isolate := cmd.String("isolate")
+	switch isolate {
+	case "", workspace.ModeTmpCopy, workspace.ModeGitWorktree:
+	default:
+		return "", "", errors.New("invalid --isolate: " + isolate)
+	}
+
+	changes := cmd.String("changes")
+	switch changes {
+	case workspace.ChangesApply, workspace.ChangesDiscard, workspace.ChangesKeep:
+	default:
+		return "", "", errors.New("invalid --changes: " + changes)
+	}
+
+	return isolate, changes, nil
+}
+
+// サブエージェントの MCP Server に引き継ぐ、作業ディレクトリの隔離のオプション引数を返す
+// 同時に実行するサブエージェントが互いのファイルを壊さないように、呼び出しごとに隔離して変更を反映させる。
+// 親のエージェントの作業ディレクトリは git のリポジトリとは限らないので、tmp-copy で隔離する。
+func isolationFlags(isolate string) []string {
+	if isolate == "" {
+		return nil
+	}
+	return []string{"--isolate", workspace.ModeTmpCopy, "--changes", workspace.ChangesApply}
+}
+
+// --policy で指定したファイルか、デフォルトのパスからセキュリティポリシーを読み込む
+// --policy を指定した場合も、デフォルトのパスのポリシーを緩められないように、あわせて検査する
+// --policy を指定せず、デフォルトのパスにもファイルがなければ nil を返す
+func loadPolicy(cmd *cli.Command, appName string) (*app.Policy, error) {
+	policyPath := cmd.String("policy")
+
+	// デフォルトのパスのポリシー
+	var defaultPolicy *app.Policy
+	defaultPath, err := app.DefaultPolicyPath(appName)
+	if err != nil {
+		if policyPath == "" {
+			return nil, err
+		}
+	} else if _, err := os.Stat(defaultPath); !errors.Is(err, os.ErrNotExist) {
+		defaultPolicy, err = app.LoadPolicy(defaultPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if policyPath == "" {
+		return defaultPolicy, nil
+	}
+
+	policy, err := app.LoadPolicy(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	if defaultPolicy != nil && defaultPolicy.Path != policy.Path {
+		policy.Base = defaultPolicy
+	}
+	return policy, nil
+}
+
+// サブエージェントの MCP Server に引き継ぐ、セキュリティポリシーのオプション引数を返す
+// サブエージェントにも同じポリシーを適用する
+func policyFlags(policy *app.Policy) []string {
+	if policy == nil {
+		return nil
+	}
+	return []string{"--policy", policy.Path}
+}
+
+// OpenAI の API Key の取得元
+type apiKeySource string
+
+const (
+	apiKeySourceNone        apiKeySource = ""
+	apiKeySourceCodexLogin  apiKeySource = "codex login"
+	apiKeySourceEnv         apiKeySource = "environment variable OPENAI_API_KEY"
+	apiKeySourceEnvFile     apiKeySource = ".env file"
+	apiKeySourceCredentials apiKeySource = "saved credentials"
+)
+
 // OpenAI の API Key を取得
 // 優先順位：Codex CLI のログイン状況 > 環境変数OPENAI_API_KEY > envfileオプションで指定された.envファイル > 設定ファイル
-func getAPIKey(ctx context.Context, appName string, codexPath string, envFiles []string) (string, error) {
+// required が false の場合、API Key が見つからなくてもエラーにしない
+func getAPIKey(ctx context.Context, appName string, codexPath string, envFiles []string, required bool) (string, error) {
+	apiKey, source, err := lookupAPIKey(ctx, appName, codexPath, envFiles)
+	if err != nil {
+		if source == apiKeySourceCodexLogin {
+			fmt.Fprintf(os.Stderr, "Failed to check codex login status.\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "Failed to load credentials file.\n")
+		}
+		return "", fmt.Errorf("%w: %s", ErrInternal, err)
+	}
+	if source == apiKeySourceNone && required {
+		fmt.Fprintf(os.Stderr, "The OpenAI API key is not set.\n")
+		fmt.Fprintf(os.Stderr, "Please specify the environment variable OPENAI_API_KEY or run the `%s setup` command.\n", filepath.Base(os.Args[0]))
+		return "", fmt.Errorf("%w: %s", ErrUsage, errors.New("the OpenAI API key is not set"))
+	}
+
+	return apiKey, nil
+}
+
+// 優先順位に従って OpenAI の API Key を探し、その取得元とともに返す
+// エラーの場合は、エラーが発生した取得元を返す
+func lookupAPIKey(ctx context.Context, appName string, codexPath string, envFiles []string) (string, apiKeySource, error) {
 	// Codex CLI のログイン状況
 	codexInstance := codex.New(codex.WithExecutablePath(codexPath))
 	loggedIn, err := codexInstance.IsLoggedIn(ctx)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to check codex login status.\n")
-		return "", fmt.Errorf("%w: %s", ErrInternal, err)
+		return "", apiKeySourceCodexLogin, err
 	}
 	if loggedIn {
-		return "", nil
+		return "", apiKeySourceCodexLogin, nil
 	}
 
 	// 環境変数 OPENAI_API_KEY
+	if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
+		return apiKey, apiKeySourceEnv, nil
+	}
+
 	// envfileオプションで指定された.envファイル
 	_ = godotenv.Load(envFiles...)
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey != "" {
-		return apiKey, nil
+	if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
+		return apiKey, apiKeySourceEnvFile, nil
 	}
 
 	// 設定ファイル
-	credentials, err := credentials.Load(appName)
+	// default プロファイルが OpenAI のものであれば、その API Key を用いる
+	profile, err := credentials.LoadProfile(appName, credentials.DefaultProfileName)
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			fmt.Fprintf(os.Stderr, "Failed to load credentials file.\n")
-			return "", fmt.Errorf("%w: %s", ErrInternal, err)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, credentials.ErrNoSuchProfile) {
+			return "", apiKeySourceNone, nil
 		}
+		return "", apiKeySourceCredentials, err
+	}
+	if profile.Provider != credentials.ProviderOpenAI {
+		return "", apiKeySourceNone, nil
+	}
 
-		fmt.Fprintf(os.Stderr, "The OpenAI API key is not set.\n")
-		fmt.Fprintf(os.Stderr, "Please specify the environment variable OPENAI_API_KEY or run the `%s setup` command.\n", filepath.Base(os.Args[0]))
-		return "", fmt.Errorf("%w: %s", ErrUsage, err)
+	return profile.APIKey, apiKeySourceCredentials, nil
+}
+
+// エージェントが資格情報のプロファイルを利用するか
+func usesCredentialProfile(config *app.Config, agentName string) bool {
+	agentConfig, ok := config.Agents[agentName]
+	return ok && agentConfig.Credential != ""
+}
+
+// 保存されている資格情報のプロファイルを、エージェントが利用するモデルプロバイダーとして返す関数を返す
+func credentialResolver(appName string) app.CredentialResolver {
+	return func(profileName string) (*agents.Provider, error) {
+		profile, err := credentials.LoadProfile(appName, profileName)
+		if err != nil {
+			return nil, err
+		}
+
+		return providerOf(profile), nil
 	}
+}
 
-	return credentials.OpenAIAPIKey, nil
+// 資格情報のプロファイルをモデルプロバイダーに変換する
+func providerOf(profile *credentials.Profile) *agents.Provider {
+	return &agents.Provider{
+		Name:       profile.Provider,
+		APIKey:     profile.APIKey,
+		BaseURL:    profile.BaseURL,
+		APIVersion: profile.APIVersion,
+	}
 }
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kurusugawa-computer/ace/agents"
+)
+
+func TestSubAgentMCPServerConfig(t *testing.T) {
+	parent := &agents.RuntimeContext{Workdir: "/work/isolated", Agent: "root", Depth: 1}
+	config, err := subAgentMCPServerConfig("ace.yaml", "codex", "", []string{"--no-cache"})(&agents.SubAgent{Name: "sub", TimeoutSec: 60}, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// サブエージェントは呼び出し元の作業ディレクトリで、呼び出し元の名前とひとつ深い深さで実行する
+	args, _ := config["args"].([]string)
+	for _, want := range [][]string{
+		{"--workdir", "/work/isolated"},
+		{"--parent-agent", "root"},
+		{"--depth", "2"},
+	} {
+		i := slices.Index(args, want[0])
+		if i < 0 || i+1 >= len(args) || args[i+1] != want[1] {
+			t.Errorf("args = %q, want %s %s", args, want[0], want[1])
+		}
+	}
+	if !slices.Contains(args, "--no-cache") || args[len(args)-1] != "sub" {
+		t.Errorf("args = %q, want flags and the sub agent name", args)
+	}
+	if _, ok := config["env"]; ok {
+		t.Errorf("env = %v, want no env without an API key", config["env"])
+	}
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	cre "github.com/kurusugawa-computer/ace/cli/credentials"
+	"github.com/urfave/cli/v3"
+)
+
+var _ subCommand = credentialProfiles
+
+func credentialProfiles(appName string, version string) *cli.Command {
+	return &cli.Command{
+		Name:      "credentials",
+		Aliases:   []string{},
+		Usage:     "Manage credential profiles registered by `" + appName + " setup`.",
+		ArgsUsage: " ",
+		Commands: []*cli.Command{
+			{
+				Name:      "list",
+				Usage:     "List credential profiles.",
+				ArgsUsage: " ",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					credentials, err := cre.Load(appName)
+					if err != nil {
+						if errors.Is(err, os.ErrNotExist) {
+							return nil
+						}
+						fmt.Fprintf(os.Stderr, "Failed to load credentials file.\n")
+						return fmt.Errorf("%w: %s", ErrInternal, err)
+					}
+
+					// API Key は末尾のみ表示する
+					for _, name := range slices.Sorted(maps.Keys(credentials.Profiles)) {
+						profile := credentials.Profiles[name]
+						fmt.Printf("%s\t%s\t%s\t%s\n", name, profile.Provider, maskAPIKey(profile.APIKey), profile.BaseURL)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "verify",
+				Usage:     "Verify a credential profile with the model listing endpoint of the provider.",
+				ArgsUsage: "[PROFILE_NAME]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "model",
+						Usage: "set model to check that it is accessible",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					profileName := cmd.Args().First()
+					if profileName == "" {
+						profileName = cre.DefaultProfileName
+					}
+
+					profile, err := cre.LoadProfile(appName, profileName)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to load credential profile: %s\n", err)
+						return fmt.Errorf("%w: %s", ErrUsage, err)
+					}
+
+					if err := verifyCredential(ctx, profile, cmd.String("model")); err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to verify credential profile.\n")
+						return fmt.Errorf("%w: %s", ErrInternal, err)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove a credential profile.",
+				ArgsUsage: "PROFILE_NAME",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() == 0 {
+						fmt.Fprintf(os.Stderr, "Please specify PROFILE_NAME.\n")
+						return fmt.Errorf("%w: %s", ErrUsage, errors.New("missing arguments"))
+					}
+
+					if err := cre.RemoveProfile(appName, cmd.Args().First()); err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to remove credential profile.\n")
+						return fmt.Errorf("%w: %s", ErrInternal, err)
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// API Key の末尾 4 文字以外を伏せる
+func maskAPIKey(apiKey string) string {
+	if len(apiKey) <= 8 {
+		return "****"
+	}
+	return "****" + apiKey[len(apiKey)-4:]
+}
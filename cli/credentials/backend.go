@@ -0,0 +1,242 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/thamaji/files"
+)
+
+// 資格情報の保存先の種類
+const (
+	BackendFile    = "file"    // キャッシュディレクトリの暗号化されたファイル
+	BackendKeyring = "keyring" // OS のキーリング（Secret Service、Linux のみ）
+	BackendCommand = "command" // credential_command が出力する API Key
+	BackendEnv     = "env"     // 環境変数 OPENAI_API_KEY のみ
+)
+
+var ErrReadOnlyBackend = errors.New("credential backend is read-only")
+
+// 資格情報の保存先
+type Backend interface {
+	// 保存されていない場合は os.ErrNotExist を返す
+	Load() (*Credentials, error)
+
+	// 書き込めない保存先の場合は ErrReadOnlyBackend を返す
+	Save(credentials *Credentials) error
+
+	// 保存されている資格情報を削除する
+	Remove() error
+}
+
+// 資格情報の保存先の設定
+type Settings struct {
+	Backend           string `json:"credential_backend"`           // file, keyring, command, env
+	CredentialCommand string `json:"credential_command,omitempty"` // backend が command のときに実行するコマンド
+}
+
+// 設定を読み込む
+// 設定ファイルがなければ file バックエンドを用いる
+func LoadSettings(appName string) (*Settings, error) {
+	settings := &Settings{Backend: BackendFile}
+
+	path, err := settingsPath(appName)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := files.OpenFileReader(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return settings, nil
+		}
+		return nil, err
+	}
+
+	err = json.NewDecoder(f).Decode(settings)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+// 設定を保存する
+func SaveSettings(appName string, settings *Settings) error {
+	path, err := settingsPath(appName)
+	if err != nil {
+		return err
+	}
+
+	f, err := files.OpenFileWriter(path)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(settings)
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 設定されたバックエンドを開く
+func OpenBackend(appName string) (Backend, error) {
+	settings, err := LoadSettings(appName)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBackend(appName, settings)
+}
+
+// 設定に従ってバックエンドをつくる
+func NewBackend(appName string, settings *Settings) (Backend, error) {
+	switch settings.Backend {
+	case "", BackendFile:
+		return &fileBackend{appName: appName}, nil
+
+	case BackendKeyring:
+		// Secret Service は Linux のデスクトップ環境のものなので、ほかの OS では保存先を切り替える前に拒否する
+		if !keyringSupported {
+			return nil, fmt.Errorf("credential backend keyring is not supported on %s (linux only)", runtime.GOOS)
+		}
+		return &keyringBackend{appName: appName}, nil
+
+	case BackendCommand:
+		if settings.CredentialCommand == "" {
+			return nil, errors.New("credential_command is required for backend command")
+		}
+		return &commandBackend{command: settings.CredentialCommand}, nil
+
+	case BackendEnv:
+		return &envBackend{}, nil
+
+	default:
+		return nil, errors.New("unknown credential backend: " + settings.Backend)
+	}
+}
+
+// 設定ファイルのパスを返す
+// キャッシュディレクトリは消されることがあるため、設定ディレクトリに保存する
+func settingsPath(appName string) (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, appName, "settings.json"), nil
+}
+
+// 暗号化していない資格情報の JSON 表現
+// キーリングや credential_command とのやりとりに用いる
+type plainCredentials struct {
+	Profiles map[string]*plainProfile `json:"profiles"`
+}
+
+type plainProfile struct {
+	Provider   string `json:"provider"`
+	APIKey     string `json:"api_key"`
+	BaseURL    string `json:"base_url,omitempty"`
+	APIVersion string `json:"api_version,omitempty"`
+}
+
+func marshalPlain(credentials *Credentials) ([]byte, error) {
+	plain := &plainCredentials{Profiles: map[string]*plainProfile{}}
+	for name, profile := range credentials.Profiles {
+		plain.Profiles[name] = &plainProfile{
+			Provider:   profile.Provider,
+			APIKey:     profile.APIKey,
+			BaseURL:    profile.BaseURL,
+			APIVersion: profile.APIVersion,
+		}
+	}
+
+	return json.Marshal(plain)
+}
+
+func unmarshalPlain(b []byte) (*Credentials, error) {
+	plain := &plainCredentials{}
+	if err := json.Unmarshal(b, plain); err != nil {
+		return nil, err
+	}
+
+	credentials := &Credentials{Profiles: map[string]*Profile{}}
+	for name, profile := range plain.Profiles {
+		provider := profile.Provider
+		if provider == "" {
+			provider = ProviderOpenAI
+		}
+		credentials.Profiles[name] = &Profile{
+			Provider:   provider,
+			APIKey:     profile.APIKey,
+			BaseURL:    profile.BaseURL,
+			APIVersion: profile.APIVersion,
+		}
+	}
+
+	return credentials, nil
+}
+
+// 保存先を切り替え、保存されている資格情報を新しい保存先へ移す
+// 新しい保存先に書き込めない場合は切り替えのみ行い、移したかどうかを返す
+func Migrate(appName string, settings *Settings) (bool, error) {
+	oldSettings, err := LoadSettings(appName)
+	if err != nil {
+		return false, err
+	}
+	oldBackend, err := NewBackend(appName, oldSettings)
+	if err != nil {
+		return false, err
+	}
+	newBackend, err := NewBackend(appName, settings)
+	if err != nil {
+		return false, err
+	}
+
+	// 新しい保存先が使えるか確認
+	if _, err := newBackend.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	// 保存されている資格情報を新しい保存先へ書き込む
+	// 環境変数や credential_command の値は保存されたものではないので移さない
+	migrated := false
+	if oldSettings.Backend != settings.Backend && (oldSettings.Backend == BackendFile || oldSettings.Backend == BackendKeyring) {
+		credentials, err := oldBackend.Load()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		if credentials != nil && len(credentials.Profiles) > 0 {
+			err := newBackend.Save(credentials)
+			if err != nil && !errors.Is(err, ErrReadOnlyBackend) {
+				return false, err
+			}
+			migrated = err == nil
+		}
+	}
+
+	if err := SaveSettings(appName, settings); err != nil {
+		return false, err
+	}
+
+	// 移し終えたら古い保存先から削除する
+	if migrated {
+		if err := oldBackend.Remove(); err != nil && !errors.Is(err, ErrReadOnlyBackend) && !errors.Is(err, os.ErrNotExist) {
+			return true, err
+		}
+	}
+
+	return migrated, nil
+}
@@ -0,0 +1,181 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// 設定と資格情報の保存先を一時ディレクトリにする
+func setupDirs(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG directories are only used on linux")
+	}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "")
+}
+
+// 項目をファイルに保存する secret-tool の代わりのスクリプトを PATH に置く
+func fakeSecretTool(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+store="$(dirname "$0")/secret.json"
+case "$1" in
+  store) cat > "$store" ;;
+  lookup) [ -f "$store" ] || exit 1; cat "$store" ;;
+  clear) rm -f "$store" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestMigrate(t *testing.T) {
+	const appName = "ace-test"
+	saved := &Credentials{Profiles: map[string]*Profile{
+		DefaultProfileName: {Provider: ProviderOpenAI, APIKey: "sk-saved"},
+		"local":            {Provider: ProviderOpenAICompatible, APIKey: "local-key", BaseURL: "http://localhost:8000/v1"},
+	}}
+
+	tests := []struct {
+		name         string
+		from         *Settings
+		to           *Settings
+		env          string // OPENAI_API_KEY
+		wantMigrated bool
+		wantErr      bool
+		wantProfiles int // 切り替えた後に読み込める資格情報のプロファイルの数
+		wantOldKept  bool
+	}{
+		{
+			name:         "file to command",
+			from:         &Settings{Backend: BackendFile},
+			to:           &Settings{Backend: BackendCommand, CredentialCommand: "echo sk-command"},
+			wantProfiles: 1,
+			wantOldKept:  true,
+		},
+		{
+			name:         "file to env",
+			from:         &Settings{Backend: BackendFile},
+			to:           &Settings{Backend: BackendEnv},
+			env:          "sk-env",
+			wantProfiles: 1,
+			wantOldKept:  true,
+		},
+		{
+			name:         "file to keyring",
+			from:         &Settings{Backend: BackendFile},
+			to:           &Settings{Backend: BackendKeyring},
+			wantMigrated: true,
+			wantProfiles: 2,
+		},
+		{
+			name:        "unavailable target",
+			from:        &Settings{Backend: BackendFile},
+			to:          &Settings{Backend: BackendCommand, CredentialCommand: "exit 1"},
+			wantErr:     true,
+			wantOldKept: true,
+		},
+		{
+			name:        "command without credential_command",
+			from:        &Settings{Backend: BackendFile},
+			to:          &Settings{Backend: BackendCommand},
+			wantErr:     true,
+			wantOldKept: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupDirs(t)
+			fakeSecretTool(t)
+			if tt.env != "" {
+				t.Setenv("OPENAI_API_KEY", tt.env)
+			}
+
+			if err := SaveSettings(appName, tt.from); err != nil {
+				t.Fatal(err)
+			}
+			oldBackend, err := NewBackend(appName, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := oldBackend.Save(saved); err != nil {
+				t.Fatal(err)
+			}
+
+			migrated, err := Migrate(appName, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Migrate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if migrated != tt.wantMigrated {
+				t.Errorf("Migrate() = %v, want %v", migrated, tt.wantMigrated)
+			}
+
+			// 失敗した場合は保存先を切り替えない
+			settings, err := LoadSettings(appName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBackend := tt.to.Backend
+			if tt.wantErr {
+				wantBackend = tt.from.Backend
+			}
+			if settings.Backend != wantBackend {
+				t.Errorf("backend = %s, want %s", settings.Backend, wantBackend)
+			}
+
+			if !tt.wantErr {
+				newBackend, err := OpenBackend(appName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				credentials, err := newBackend.Load()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(credentials.Profiles) != tt.wantProfiles {
+					t.Errorf("profiles = %d, want %d", len(credentials.Profiles), tt.wantProfiles)
+				}
+			}
+
+			// 移していない資格情報は古い保存先に残す
+			_, err = oldBackend.Load()
+			if oldKept := err == nil; oldKept != tt.wantOldKept {
+				t.Errorf("credentials kept in the old backend = %v (error = %v), want %v", oldKept, err, tt.wantOldKept)
+			}
+		})
+	}
+}
+
+func TestNewBackendKeyring(t *testing.T) {
+	defer func(supported bool) { keyringSupported = supported }(keyringSupported)
+
+	keyringSupported = false
+	if _, err := NewBackend("ace-test", &Settings{Backend: BackendKeyring}); err == nil {
+		t.Error("NewBackend() should reject keyring on unsupported systems")
+	}
+
+	keyringSupported = true
+	if _, err := NewBackend("ace-test", &Settings{Backend: BackendKeyring}); err != nil {
+		t.Errorf("NewBackend() error = %v", err)
+	}
+}
+
+func TestReadOnlyBackends(t *testing.T) {
+	for _, backend := range []Backend{&commandBackend{command: "echo sk"}, &envBackend{}} {
+		if err := backend.Save(&Credentials{}); !errors.Is(err, ErrReadOnlyBackend) {
+			t.Errorf("%T.Save() error = %v, want ErrReadOnlyBackend", backend, err)
+		}
+		if err := backend.Remove(); !errors.Is(err, ErrReadOnlyBackend) {
+			t.Errorf("%T.Remove() error = %v, want ErrReadOnlyBackend", backend, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// credential_command を実行して、その出力を資格情報とするバックエンド
+// 1Password CLI（op read ...）や pass（pass show ...）などと組み合わせて用いる。
+// 出力が JSON オブジェクトであればプロファイルの一覧、そうでなければ default プロファイルの OpenAI API Key とみなす。
+type commandBackend struct {
+	command string
+}
+
+func (backend *commandBackend) Load() (*Credentials, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", backend.command)
+	} else {
+		cmd = exec.Command("sh", "-c", backend.command)
+	}
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential_command: %w", err)
+	}
+
+	stdout = bytes.TrimSpace(stdout)
+	if len(stdout) == 0 {
+		return nil, errors.New("credential_command: output is empty")
+	}
+	if stdout[0] == '{' {
+		credentials, err := unmarshalPlain(stdout)
+		if err != nil {
+			return nil, fmt.Errorf("credential_command: %w", err)
+		}
+		return credentials, nil
+	}
+
+	return &Credentials{
+		Profiles: map[string]*Profile{
+			DefaultProfileName: {
+				Provider: ProviderOpenAI,
+				APIKey:   strings.TrimSpace(string(stdout)),
+			},
+		},
+	}, nil
+}
+
+func (backend *commandBackend) Save(credentials *Credentials) error {
+	return ErrReadOnlyBackend
+}
+
+func (backend *commandBackend) Remove() error {
+	return ErrReadOnlyBackend
+}
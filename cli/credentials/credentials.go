@@ -1,99 +1,114 @@
 package credentials
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
-	"path/filepath"
-
-	"github.com/denisbrodbeck/machineid"
-	"github.com/thamaji/files"
-	"github.com/thamaji/lazycrypto"
 )
 
-const appID = "github.com/kurusugawa-computer/ace"
+// プロファイル名を指定しなかったときに用いるプロファイル
+const DefaultProfileName = "default"
+
+var ErrNoSuchProfile = errors.New("no such credential profile")
+
+// モデルプロバイダーの種類
+const (
+	ProviderOpenAI           = "openai"
+	ProviderAzure            = "azure"
+	ProviderOpenAICompatible = "openai-compatible"
+)
 
 type Credentials struct {
-	OpenAIAPIKey string
+	Profiles map[string]*Profile // プロファイル名ごとの資格情報
 }
 
-type credentialsFile struct {
-	OpenAIAPIKey string `json:"openai_api_key"`
+// 名前付きの資格情報
+type Profile struct {
+	Provider   string // openai, azure, openai-compatible
+	APIKey     string
+	BaseURL    string // Azure OpenAI のエンドポイント、もしくは OpenAI 互換 API のベース URL
+	APIVersion string // Azure OpenAI の API バージョン（任意）
 }
 
-// Credentialsを暗号化してJSON形式で保存する
-func Save(appName string, credentials *Credentials) error {
-	passphrase, err := machineid.ProtectedID(appID)
-	if err != nil {
-		return err
-	}
-
-	openAIAPIKey, err := lazycrypto.EncryptToString([]byte(passphrase), []byte(credentials.OpenAIAPIKey))
-	if err != nil {
-		return err
+// プロファイルの内容が正しいか検証する
+func (profile *Profile) Validate() error {
+	switch profile.Provider {
+	case ProviderOpenAI:
+	case ProviderAzure, ProviderOpenAICompatible:
+		if profile.BaseURL == "" {
+			return errors.New("base url is required for provider " + profile.Provider)
+		}
+	default:
+		return errors.New("unknown provider: " + profile.Provider)
 	}
-
-	credentialsFile := credentialsFile{
-		OpenAIAPIKey: string(openAIAPIKey),
-	}
-
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		return err
-	}
-
-	path := filepath.Join(cacheDir, appName, "credentials.json")
-	f, err := files.OpenFileWriter(path)
-	if err != nil {
-		return err
+	if profile.APIKey == "" {
+		return errors.New("api key is empty")
 	}
+	return nil
+}
 
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	err = enc.Encode(credentialsFile)
-	if err1 := f.Close(); err1 != nil && err == nil {
-		err = err1
-	}
+// 設定されたバックエンドに Credentials を保存する
+func Save(appName string, credentials *Credentials) error {
+	backend, err := OpenBackend(appName)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return backend.Save(credentials)
 }
 
-// 暗号化されているCredentialsファイルを読み込んで複合化する
+// 設定されたバックエンドから Credentials を読み込む
+// 保存されていない場合は os.ErrNotExist を返す
 func Load(appName string) (*Credentials, error) {
-	cacheDir, err := os.UserCacheDir()
+	backend, err := OpenBackend(appName)
 	if err != nil {
 		return nil, err
 	}
 
-	credentialsFile := credentialsFile{}
+	return backend.Load()
+}
 
-	path := filepath.Join(cacheDir, appName, "credentials.json")
-	f, err := files.OpenFileReader(path)
+// 保存されているプロファイルを返す
+func LoadProfile(appName string, profileName string) (*Profile, error) {
+	credentials, err := Load(appName)
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.NewDecoder(f).Decode(&credentialsFile)
-	f.Close()
-	if err != nil {
-		return nil, err
+	profile, ok := credentials.Profiles[profileName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchProfile, profileName)
 	}
 
-	passphrase, err := machineid.ProtectedID(appID)
+	return profile, nil
+}
+
+// プロファイルを追加もしくは更新して保存する
+func SaveProfile(appName string, profileName string, profile *Profile) error {
+	credentials, err := Load(appName)
 	if err != nil {
-		return nil, err
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		credentials = &Credentials{Profiles: map[string]*Profile{}}
 	}
 
-	openAIAPIKey, err := lazycrypto.DecryptString([]byte(passphrase), credentialsFile.OpenAIAPIKey)
+	credentials.Profiles[profileName] = profile
+
+	return Save(appName, credentials)
+}
+
+// プロファイルを削除して保存する
+func RemoveProfile(appName string, profileName string) error {
+	credentials, err := Load(appName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	credentials := &Credentials{
-		OpenAIAPIKey: string(openAIAPIKey),
+	if _, ok := credentials.Profiles[profileName]; !ok {
+		return fmt.Errorf("%w: %s", ErrNoSuchProfile, profileName)
 	}
+	delete(credentials.Profiles, profileName)
 
-	return credentials, nil
+	return Save(appName, credentials)
 }
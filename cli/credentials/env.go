@@ -0,0 +1,33 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+)
+
+// 資格情報を保存せず、環境変数 OPENAI_API_KEY のみを用いるバックエンド
+type envBackend struct{}
+
+func (backend *envBackend) Load() (*Credentials, error) {
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY: %w", os.ErrNotExist)
+	}
+
+	return &Credentials{
+		Profiles: map[string]*Profile{
+			DefaultProfileName: {
+				Provider: ProviderOpenAI,
+				APIKey:   apiKey,
+			},
+		},
+	}, nil
+}
+
+func (backend *envBackend) Save(credentials *Credentials) error {
+	return ErrReadOnlyBackend
+}
+
+func (backend *envBackend) Remove() error {
+	return ErrReadOnlyBackend
+}
@@ -0,0 +1,153 @@
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/denisbrodbeck/machineid"
+	"github.com/thamaji/files"
+	"github.com/thamaji/lazycrypto"
+)
+
+const appID = "github.com/kurusugawa-computer/ace"
+
+// ユーザーのキャッシュディレクトリに、マシンIDで暗号化して保存するバックエンド
+type fileBackend struct {
+	appName string
+}
+
+type credentialsFile struct {
+	OpenAIAPIKey string                  `json:"openai_api_key,omitempty"` // 以前の形式。default プロファイルとして読み込む
+	Profiles     map[string]*profileFile `json:"profiles,omitempty"`
+}
+
+type profileFile struct {
+	Provider   string `json:"provider"`
+	APIKey     string `json:"api_key"` // 暗号化した API Key
+	BaseURL    string `json:"base_url,omitempty"`
+	APIVersion string `json:"api_version,omitempty"`
+}
+
+// Credentialsを暗号化してJSON形式で保存する
+func (backend *fileBackend) Save(credentials *Credentials) error {
+	passphrase, err := machineid.ProtectedID(appID)
+	if err != nil {
+		return err
+	}
+
+	credentialsFile := credentialsFile{
+		Profiles: map[string]*profileFile{},
+	}
+	for name, profile := range credentials.Profiles {
+		apiKey, err := lazycrypto.EncryptToString([]byte(passphrase), []byte(profile.APIKey))
+		if err != nil {
+			return err
+		}
+		credentialsFile.Profiles[name] = &profileFile{
+			Provider:   profile.Provider,
+			APIKey:     string(apiKey),
+			BaseURL:    profile.BaseURL,
+			APIVersion: profile.APIVersion,
+		}
+	}
+
+	path, err := filePath(backend.appName)
+	if err != nil {
+		return err
+	}
+
+	f, err := files.OpenFileWriter(path)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(credentialsFile)
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 暗号化されているCredentialsファイルを読み込んで複合化する
+func (backend *fileBackend) Load() (*Credentials, error) {
+	credentialsFile := credentialsFile{}
+
+	path, err := filePath(backend.appName)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := files.OpenFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.NewDecoder(f).Decode(&credentialsFile)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, err := machineid.ProtectedID(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := &Credentials{
+		Profiles: map[string]*Profile{},
+	}
+
+	// 以前の形式で保存された OpenAI の API Key
+	if credentialsFile.OpenAIAPIKey != "" {
+		openAIAPIKey, err := lazycrypto.DecryptString([]byte(passphrase), credentialsFile.OpenAIAPIKey)
+		if err != nil {
+			return nil, err
+		}
+		credentials.Profiles[DefaultProfileName] = &Profile{
+			Provider: ProviderOpenAI,
+			APIKey:   string(openAIAPIKey),
+		}
+	}
+
+	for name, profile := range credentialsFile.Profiles {
+		apiKey, err := lazycrypto.DecryptString([]byte(passphrase), profile.APIKey)
+		if err != nil {
+			return nil, err
+		}
+		credentials.Profiles[name] = &Profile{
+			Provider:   profile.Provider,
+			APIKey:     string(apiKey),
+			BaseURL:    profile.BaseURL,
+			APIVersion: profile.APIVersion,
+		}
+	}
+
+	return credentials, nil
+}
+
+// 保存されているCredentialsファイルを削除する
+func (backend *fileBackend) Remove() error {
+	path, err := filePath(backend.appName)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Credentialsファイルのパスを返す
+func filePath(appName string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, appName, "credentials.json"), nil
+}
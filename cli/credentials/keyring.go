@@ -0,0 +1,83 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// keyring バックエンドを利用できるか
+// secret-tool は Linux の Secret Service にのみ対応しているので、Linux 以外では利用できない。
+var keyringSupported = runtime.GOOS == "linux"
+
+// Secret Service（GNOME Keyring、KWallet など）に D-Bus 経由で保存するバックエンド
+// libsecret の secret-tool コマンドを用いる。
+type keyringBackend struct {
+	appName string
+}
+
+// キーリングの項目を特定する属性
+func (backend *keyringBackend) attributes() []string {
+	return []string{"service", appID, "account", backend.appName}
+}
+
+func (backend *keyringBackend) Load() (*Credentials, error) {
+	stdout, err := backend.secretTool(nil, append([]string{"lookup"}, backend.attributes()...)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(stdout)) == 0 {
+		return nil, fmt.Errorf("keyring: %w", os.ErrNotExist)
+	}
+
+	return unmarshalPlain(stdout)
+}
+
+func (backend *keyringBackend) Save(credentials *Credentials) error {
+	b, err := marshalPlain(credentials)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"store", "--label", backend.appName + " credentials"}, backend.attributes()...)
+	_, err = backend.secretTool(b, args...)
+	return err
+}
+
+func (backend *keyringBackend) Remove() error {
+	_, err := backend.secretTool(nil, append([]string{"clear"}, backend.attributes()...)...)
+	return err
+}
+
+// secret-tool を実行して標準出力を返す
+// lookup で項目が見つからない場合は、何も出力せず終了コード 1 で終了するので空として扱う
+func (backend *keyringBackend) secretTool(stdin []byte, args ...string) ([]byte, error) {
+	path, err := exec.LookPath("secret-tool")
+	if err != nil {
+		return nil, errors.New("keyring: secret-tool is not found, install libsecret tools (e.g. libsecret-tools)")
+	}
+
+	cmd := exec.Command(path, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		exitErr := &exec.ExitError{}
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+			return nil, nil
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("keyring: %s", message)
+		}
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+
+	return stdout, nil
+}
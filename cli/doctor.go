@@ -0,0 +1,354 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/kurusugawa-computer/ace/app"
+	"github.com/kurusugawa-computer/ace/cli/credentials"
+	"github.com/urfave/cli/v3"
+)
+
+var _ subCommand = doctor
+
+func doctor(appName string, version string) *cli.Command {
+	return &cli.Command{
+		Name:      "doctor",
+		Aliases:   []string{},
+		Usage:     "Diagnose the environment, credentials, agent definitions and the MCP servers they use.",
+		ArgsUsage: " ",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "set YAML file where the AI ​​agent is defined",
+				Value:   "agent.yaml",
+			},
+			&cli.StringFlag{
+				Name:  "codex-path",
+				Usage: "set codex executable path",
+				Value: "codex",
+			},
+			&cli.StringFlag{
+				Name:    "workdir",
+				Aliases: []string{"w"},
+				Usage:   "set working directory",
+				Value:   ".",
+			},
+			&cli.StringSliceFlag{
+				Name:  "env-file",
+				Usage: "set an alternate environment file",
+				Value: []string{".env"},
+			},
+		},
+		Arguments: []cli.Argument{},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			// オプション引数の値を取得
+			configPath := cmd.String("config")
+			workdir := cmd.String("workdir")
+			envFiles := cmd.StringSlice("env-file")
+			codexPath := cmd.String("codex-path")
+
+			report := &doctorReport{writer: os.Stdout}
+
+			// 実行環境の診断
+			checkCodex(ctx, report, codexPath)
+			checkCredentials(ctx, report, appName, codexPath, envFiles)
+			checkCacheDir(report, appName)
+
+			// エージェントを定義した YAML ファイルの診断
+			// -c が指定されておらず、デフォルトの YAML ファイルもなければ省略する
+			if _, err := os.Stat(configPath); err != nil && !cmd.IsSet("config") {
+				report.warn("%s not found, skipped checking agents (specify -c)", configPath)
+			} else {
+				// MCP Server に渡す環境変数を .env ファイルから読み込む
+				_ = godotenv.Load(envFiles...)
+				checkConfig(ctx, report, appName, configPath, workdir)
+			}
+
+			// 結果のまとめ
+			fmt.Fprintln(report.writer)
+			fmt.Fprintf(report.writer, "%d failed, %d warnings\n", report.failed, report.warned)
+			if report.failed > 0 {
+				return fmt.Errorf("%w: %s", ErrInternal, errors.New("some checks failed"))
+			}
+
+			return nil
+		},
+	}
+}
+
+// 診断結果を書き出す
+type doctorReport struct {
+	writer io.Writer
+	failed int // [NG] の数
+	warned int // [WARN] の数
+}
+
+func (report *doctorReport) ok(format string, args ...any) {
+	fmt.Fprintf(report.writer, "[OK]   %s\n", fmt.Sprintf(format, args...))
+}
+
+func (report *doctorReport) ng(format string, args ...any) {
+	report.failed++
+	fmt.Fprintf(report.writer, "[NG]   %s\n", fmt.Sprintf(format, args...))
+}
+
+func (report *doctorReport) warn(format string, args ...any) {
+	report.warned++
+	fmt.Fprintf(report.writer, "[WARN] %s\n", fmt.Sprintf(format, args...))
+}
+
+// Codex CLI の最低バージョン
+const minCodexVersion = "0.53.0"
+
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// Codex CLI が実行できて、最低バージョン以上か診断する
+func checkCodex(ctx context.Context, report *doctorReport, codexPath string) {
+	path, err := osexec.LookPath(codexPath)
+	if err != nil {
+		report.ng("codex: %s not found, install Codex CLI %s or later or specify --codex-path", codexPath, minCodexVersion)
+		return
+	}
+
+	out, err := osexec.CommandContext(ctx, path, "--version").Output()
+	if err != nil {
+		report.ng("codex: failed to get version: %s", err)
+		return
+	}
+	version := versionPattern.FindString(string(out))
+	if version == "" {
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		report.warn("codex: unknown version: %s", firstLine)
+		return
+	}
+	if compareVersions(version, minCodexVersion) < 0 {
+		report.ng("codex: version %s is older than %s", version, minCodexVersion)
+		return
+	}
+	report.ok("codex: version %s (%s)", version, path)
+}
+
+// x.y.z 形式のバージョンを比較する
+func compareVersions(a string, b string) int {
+	am := versionPattern.FindStringSubmatch(a)
+	bm := versionPattern.FindStringSubmatch(b)
+	for i := 1; i <= 3; i++ {
+		an, _ := strconv.Atoi(am[i])
+		bn, _ := strconv.Atoi(bm[i])
+		if an != bn {
+			return an - bn
+		}
+	}
+	return 0
+}
+
+// ログイン状況と、getAPIKey で使われる API Key の取得元を診断する
+func checkCredentials(ctx context.Context, report *doctorReport, appName string, codexPath string, envFiles []string) {
+	// 資格情報の保存先が読めるか
+	// 優先順位の高い取得元があると読まれないが、壊れていれば知らせる
+	settings, err := credentials.LoadSettings(appName)
+	if err != nil {
+		report.ng("credential settings: %s", err)
+		return
+	}
+	profileCount := 0
+	if saved, err := credentials.Load(appName); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			report.ok("credentials (%s): not found", settings.Backend)
+		} else {
+			report.ng("credentials (%s): %s (run `%s setup` again)", settings.Backend, err, appName)
+		}
+	} else {
+		profileCount = len(saved.Profiles)
+		report.ok("credentials (%s): readable, %d profiles", settings.Backend, profileCount)
+	}
+
+	_, source, err := lookupAPIKey(ctx, appName, codexPath, envFiles)
+	switch {
+	case err != nil && source == apiKeySourceCodexLogin:
+		report.ng("codex login: failed to check login status: %s", err)
+
+	case err != nil:
+		report.ng("%s: %s", source, err)
+
+	case source == apiKeySourceNone && profileCount > 0:
+		report.warn("credentials: the OpenAI API key is not set, only agents with a credential profile can run")
+
+	case source == apiKeySourceNone:
+		report.ng("credentials: the OpenAI API key is not set (set OPENAI_API_KEY, run `%s setup` or `codex login`)", appName)
+
+	case source == apiKeySourceCodexLogin:
+		report.ok("codex login: logged in, used as credentials")
+
+	default:
+		report.ok("codex login: not logged in")
+		report.ok("credentials: using %s", source)
+	}
+}
+
+// キャッシュディレクトリ（資格情報ファイルや実行記録の保存先）に書き込めるか診断する
+func checkCacheDir(report *doctorReport, appName string) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		report.ng("cache dir: %s", err)
+		return
+	}
+	dir := filepath.Join(cacheDir, appName)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		report.ng("cache dir: %s", err)
+		return
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		report.ng("cache dir: %s is not writable: %s", dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	report.ok("cache dir: %s is writable", dir)
+}
+
+// MCP Server のコマンドを提供するツールのインストール方法のヒント
+func installHint(command string) string {
+	switch filepath.Base(command) {
+	case "uv", "uvx":
+		return " (install uv: https://docs.astral.sh/uv/)"
+	case "npx", "npm", "node":
+		return " (install Node.js: https://nodejs.org/)"
+	case "docker":
+		return " (install Docker)"
+	}
+	return " in PATH"
+}
+
+// エージェントを定義した YAML ファイルと、エージェントが利用する MCP Server を診断する
+func checkConfig(ctx context.Context, report *doctorReport, appName string, configPath string, workdir string) {
+	// YAML ファイルの読み込みと検証
+	config, err := app.LoadConfig(configPath)
+	if err != nil {
+		report.ng("%s: %s", configPath, err)
+		return
+	}
+	report.ok("%s is valid", configPath)
+
+	app := app.New(config, "", "", nil)
+
+	// エージェントごとにビルドできるか
+	for _, agentName := range slices.Sorted(maps.Keys(config.Agents)) {
+		if err := app.ValidateAgent(agentName); err != nil {
+			report.ng("agent %s: %s", agentName, err)
+			continue
+		}
+		if profileName := config.Agents[agentName].Credential; profileName != "" {
+			if _, err := credentials.LoadProfile(appName, profileName); err != nil {
+				report.ng("agent %s: credential %s: %s", agentName, profileName, err)
+				continue
+			}
+		}
+		report.ok("agent %s", agentName)
+	}
+
+	// MCP Server ごとに診断
+	servers, err := app.MCPServers("", "")
+	if err != nil {
+		report.ng("%s", err)
+		return
+	}
+	for _, server := range servers {
+		checkMCPServer(ctx, report, server, workdir)
+	}
+}
+
+// MCP Server が起動できて、enabled_tools のツールを提供しているか診断する
+func checkMCPServer(ctx context.Context, report *doctorReport, server *app.MCPServer, workdir string) {
+	name := fmt.Sprintf("mcp server %s (agent: %s)", server.ServerName, server.AgentName)
+
+	// Codex が起動しない MCP Server は診断しない
+	if enabled := server.Config.Enabled; enabled != nil && !*enabled {
+		report.ok("%s: disabled", name)
+		return
+	}
+
+	// コマンドが見つからなければ起動を試みない
+	if command := server.Config.Command; command != "" {
+		if _, err := osexec.LookPath(command); err != nil {
+			report.ng("%s: command %s not found%s", name, command, installHint(command))
+			return
+		}
+	}
+
+	// 起動を待つ時間を過ぎたら、MCP Server への接続を取り消す
+	// 起動に失敗した MCP Server の子プロセスが残っていると、接続の終了を待ち続けることがあるため
+	// 取り消したら結果を待たずに次へ進む
+	startupTimeout := server.StartupTimeout()
+	probeCtx, cancel := context.WithTimeout(ctx, startupTimeout)
+	defer cancel()
+	type probeResult struct {
+		tools []*app.MCPTool
+		err   error
+	}
+	resultCh := make(chan probeResult, 1)
+	go func() {
+		tools, err := probeMCPServer(probeCtx, server, workdir)
+		resultCh <- probeResult{tools: tools, err: err}
+	}()
+	var tools []*app.MCPTool
+	var err error
+	select {
+	case result := <-resultCh:
+		tools, err = result.tools, result.err
+	case <-probeCtx.Done():
+		err = fmt.Errorf("did not start within %s", startupTimeout)
+	}
+	if err != nil {
+		report.ng("%s: %s", name, err)
+		return
+	}
+
+	if missing := server.MissingTools(tools); len(missing) > 0 {
+		report.ng("%s: enabled_tools not provided by the server: %s", name, strings.Join(missing, ", "))
+		return
+	}
+	enabled := 0
+	for _, tool := range tools {
+		if tool.Enabled {
+			enabled++
+		}
+	}
+	if enabled == 0 {
+		report.warn("%s: no tools are enabled", name)
+		return
+	}
+	report.ok("%s: %d of %d tools enabled", name, enabled, len(tools))
+}
+
+// MCP Server を起動して、提供するツールの一覧を返す
+func probeMCPServer(ctx context.Context, server *app.MCPServer, workdir string) ([]*app.MCPTool, error) {
+	session, err := server.Connect(ctx, workdir)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	tools, err := server.ListTools(ctx, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tools: %w", err)
+	}
+
+	return tools, nil
+}
@@ -2,11 +2,15 @@ package cli
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 
 	"github.com/kurusugawa-computer/ace/app"
+	"github.com/kurusugawa-computer/ace/cache"
+	"github.com/kurusugawa-computer/ace/journal"
+	"github.com/thamaji/files"
 	"github.com/urfave/cli/v3"
 )
 
@@ -48,6 +52,60 @@ KEY=VALUE pairs can be used to fill prompt_template variables.`,
 				HideDefault: true,
 				Value:       "off",
 			},
+			&cli.StringFlag{
+				Name:  "session",
+				Usage: "continue the conversation of the named session (the session is created if it does not exist)",
+			},
+			&cli.StringFlag{
+				Name:  "resume",
+				Usage: "continue the conversation of the specified RUN_ID",
+			},
+			&cli.StringFlag{
+				Name:        "output-format",
+				Usage:       "set output format (\"json\", \"yaml\", \"text\", \"template\", default: \"template\" if output_template is defined, otherwise \"json\")",
+				HideDefault: true,
+			},
+			&cli.StringFlag{
+				Name:  "output-template",
+				Usage: "set Go text/template applied to the output (implies --output-format template)",
+			},
+			&cli.StringFlag{
+				Name:  "output-file",
+				Usage: "write the output to the file instead of stdout",
+			},
+			&cli.StringFlag{
+				Name:  "field",
+				Usage: "output only the value at the dot separated path (e.g. items.0.title)",
+			},
+			&cli.BoolFlag{
+				Name:  "interactive",
+				Usage: "prompt on the terminal for input_schema fields not given as KEY=VALUE",
+			},
+			&cli.BoolFlag{
+				Name:  "cache",
+				Usage: "use cached responses for all agents, even if cache is not defined in the YAML file",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "do not use cached responses",
+			},
+			&cli.StringFlag{
+				Name:  "isolate",
+				Usage: "run the agent in an isolated copy of the working directory (\"tmp-copy\", \"git-worktree\")",
+			},
+			&cli.StringFlag{
+				Name:  "changes",
+				Usage: "set what to do with the changes in the isolated working directory (\"apply\", \"discard\", \"keep\")",
+				Value: "apply",
+			},
+			&cli.StringFlag{
+				Name:  "patch-file",
+				Usage: "write the diff of the changes in the isolated working directory to the file instead of stderr",
+			},
+			&cli.StringFlag{
+				Name:  "policy",
+				Usage: "set security policy file (default: policy.yaml in the user config directory, if it exists)",
+			},
 		},
 		Arguments: []cli.Argument{},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
@@ -57,12 +115,14 @@ KEY=VALUE pairs can be used to fill prompt_template variables.`,
 			envFiles := cmd.StringSlice("env-file")
 			codexPath := cmd.String("codex-path")
 			logLevel := cmd.String("log-level")
-
-			// OpenAI の API Key を取得
-			apiKey, err := getAPIKey(ctx, appName, codexPath, envFiles)
-			if err != nil {
-				return err
-			}
+			session := cmd.String("session")
+			resume := cmd.String("resume")
+			interactive := cmd.Bool("interactive")
+			outputFormat := cmd.String("output-format")
+			outputTemplate := cmd.String("output-template")
+			outputFile := cmd.String("output-file")
+			field := cmd.String("field")
+			patchFile := cmd.String("patch-file")
 
 			// エージェントを定義したYAMLファイルを読み込み
 			config, err := app.LoadConfig(configPath)
@@ -71,33 +131,182 @@ KEY=VALUE pairs can be used to fill prompt_template variables.`,
 				return fmt.Errorf("%w: %s", ErrInternal, err)
 			}
 
+			// セキュリティポリシーを読み込む
+			policy, err := loadPolicy(cmd, appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load policy file.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 回答のキャッシュの利用方法を取得
+			cacheMode, err := cacheMode(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid cache options: %s\n", err)
+				return fmt.Errorf("%w: %s", ErrUsage, err)
+			}
+
+			// 作業ディレクトリの隔離方法を取得
+			isolate, changes, err := isolation(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid isolation options: %s\n", err)
+				return fmt.Errorf("%w: %s", ErrUsage, err)
+			}
+
 			// エージェント名のチェック
 			if cmd.Args().Len() == 0 {
 				fmt.Fprintf(os.Stderr, "Please specify AGENT_NAME.\n")
 				return fmt.Errorf("%w: %s", ErrUsage, err)
 			}
 
+			// OpenAI の API Key を取得
+			// 資格情報のプロファイルを利用するエージェントでは必須としない
+			apiKey, err := getAPIKey(ctx, appName, codexPath, envFiles, !usesCredentialProfile(config, cmd.Args().First()))
+			if err != nil {
+				return err
+			}
+
+			// 回答のキャッシュの保存先を開く
+			responseCache, err := cache.Open(appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open response cache.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 実行記録の保存先を開く
+			journal, err := journal.Open(appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open run journal.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 会話の継続
+			options := &app.RunOptions{
+				Session: session,
+				Resume:  resume,
+			}
+
+			// 出力形式
+			outputOptions := &app.OutputOptions{
+				Format:   outputFormat,
+				Template: outputTemplate,
+				Field:    field,
+			}
+
+			// 不足している入力を対話的に入力してもらう
+			appOptions := []app.AppOption{
+				app.WithLogger(os.Stderr, logLevel),
+				app.WithJournal(journal),
+				app.WithCredentialResolver(credentialResolver(appName)),
+				app.WithCache(responseCache, cacheMode),
+				app.WithPolicy(policy),
+				app.WithIsolation(isolate, changes),
+			}
+			var interactiveInput *interactiveInput
+			if interactive {
+				interactiveInput, err = newInteractiveInput(workdir)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to start interactive input: %s\n", err)
+					return fmt.Errorf("%w: %s", ErrUsage, err)
+				}
+				appOptions = append(appOptions, app.WithInputPrompter(interactiveInput.prompt))
+			}
+
 			// アプリケーションをつくり、AIエージェントを実行
 			app := app.New(
 				config,
 				codexPath,
 				apiKey,
-				subAgentMCPServerConfig(configPath, workdir, codexPath, apiKey),
-				app.WithLogger(os.Stderr, logLevel),
+				subAgentMCPServerConfig(configPath, codexPath, apiKey, slices.Concat(cacheModeFlags(cacheMode), isolationFlags(isolate), policyFlags(policy))),
+				appOptions...,
 			)
 			agentName := cmd.Args().First()
-			output, err := app.RunAgent(agentName, workdir, cmd.Args().Tail())
+			run, err := app.RunAgent(agentName, workdir, cmd.Args().Tail(), options)
+
+			// 対話的に入力した場合は、同等のコマンドラインを出力して再利用できるようにする
+			if interactiveInput != nil && len(interactiveInput.arguments) > 0 {
+				fmt.Fprintf(os.Stderr, "\nEquivalent command:\n  %s\n\n", equivalentCommandLine(os.Args, interactiveInput.arguments))
+			}
+
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to start AI agent\n")
 				return fmt.Errorf("%w: %s", ErrInternal, err)
 			}
 
-			// AIエージェントの実行結果をJSON形式で出力
-			enc := json.NewEncoder(os.Stdout)
-			enc.SetIndent("", "  ")
-			_ = enc.Encode(output)
+			// 会話を継続できるように実行IDを出力
+			fmt.Fprintf(os.Stderr, "Run ID: %s\n", run.ID)
+			if run.Cached {
+				fmt.Fprintf(os.Stderr, "The cached response was used.\n")
+			}
+			if run.Model != "" {
+				fmt.Fprintf(os.Stderr, "Model: %s\n", run.Model)
+			}
+
+			// 隔離した作業ディレクトリでの変更を出力
+			if err := writeWorkspaceChanges(run, changes, patchFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write patch file.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// AIエージェントの実行結果を出力
+			if outputFile == "" {
+				err = app.WriteOutput(os.Stdout, agentName, run.Output, outputOptions)
+			} else {
+				f, err1 := files.OpenFileWriter(outputFile)
+				if err1 != nil {
+					fmt.Fprintf(os.Stderr, "Failed to open output file.\n")
+					return fmt.Errorf("%w: %s", ErrInternal, err1)
+				}
+				err = app.WriteOutput(f, agentName, run.Output, outputOptions)
+				if err1 := f.Close(); err1 != nil && err == nil {
+					err = err1
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write output.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 出力の検査の結果を出力
+			// 検査を満たさない出力は、スクリプトから検知できるようにエラーとする
+			printChecks(run)
+			if !run.ChecksPassed() {
+				fmt.Fprintf(os.Stderr, "The output of AI agent did not pass the output checks.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, errors.New("output checks failed"))
+			}
 
 			return nil
 		},
 	}
 }
+
+// 隔離した作業ディレクトリでの変更の差分をファイルもしくは標準エラー出力に出力する
+// 作業ディレクトリを残した場合は、そのパスも出力する
+func writeWorkspaceChanges(run *journal.Run, changes string, patchFile string) error {
+	if run.Workspace != "" {
+		fmt.Fprintf(os.Stderr, "Workspace: %s\n", run.Workspace)
+	}
+
+	if patchFile != "" {
+		return os.WriteFile(patchFile, []byte(run.Diff), 0644)
+	}
+	if run.Diff != "" {
+		fmt.Fprintf(os.Stderr, "Changes (%s):\n%s", changes, run.Diff)
+	}
+	return nil
+}
+
+// 出力の検査の結果を標準エラー出力に出力する
+func printChecks(run *journal.Run) {
+	if len(run.Checks) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Output checks:\n")
+	for _, check := range run.Checks {
+		if check.Passed {
+			fmt.Fprintf(os.Stderr, "  [PASS] %s\n", check.Name)
+		} else {
+			fmt.Fprintf(os.Stderr, "  [FAIL] %s: %s\n", check.Name, check.Message)
+		}
+	}
+}
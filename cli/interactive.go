@@ -0,0 +1,328 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"golang.org/x/term"
+)
+
+// 複数行の入力の開始と終了を表す行
+const multiLineDelimiter = `"""`
+
+// ファイル選択で一覧表示するエントリの最大数
+const maxFileChoices = 30
+
+// 不足している入力を端末から対話的に入力してもらう
+type interactiveInput struct {
+	workdir   string
+	reader    *bufio.Reader
+	writer    io.Writer
+	arguments []string // 入力された値の KEY=VALUE 形式
+}
+
+// 端末を開いて interactiveInput を返す
+// 標準入力が端末でなければ /dev/tty を開く
+func newInteractiveInput(workdir string) (*interactiveInput, error) {
+	var reader io.Reader = os.Stdin
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return nil, errors.New("interactive input requires a terminal")
+		}
+		reader = tty
+	}
+
+	return &interactiveInput{
+		workdir: workdir,
+		reader:  bufio.NewReader(reader),
+		writer:  os.Stderr,
+	}, nil
+}
+
+// app.InputPrompter として値を入力してもらう
+func (input *interactiveInput) prompt(key string, schema *jsonschema.Schema) (any, error) {
+	// 入力項目の説明を表示
+	fmt.Fprintln(input.writer)
+	fmt.Fprintf(input.writer, "%s (%s)\n", key, schema.Type)
+	if description := strings.TrimSpace(schema.Description); description != "" {
+		for line := range strings.SplitSeq(description, "\n") {
+			fmt.Fprintf(input.writer, "  %s\n", line)
+		}
+	}
+	if schema.Default != nil {
+		fmt.Fprintf(input.writer, "  default: %s (press Enter to use)\n", string(schema.Default))
+	}
+
+	for {
+		value, err := input.promptValue(key, schema)
+		if err != nil {
+			return nil, err
+		}
+
+		switch value := value.(type) {
+		case nil:
+			// 空入力でデフォルト値があればデフォルト値を使う
+			if schema.Default != nil {
+				return nil, nil
+			}
+			fmt.Fprintf(input.writer, "  %s is required.\n", key)
+
+		case string:
+			input.arguments = append(input.arguments, key+"="+value)
+			return value, nil
+
+		case []string:
+			for _, item := range value {
+				input.arguments = append(input.arguments, key+"="+item)
+			}
+			return value, nil
+		}
+	}
+}
+
+// スキーマの種類に応じた方法で値を入力してもらう
+// 空入力の場合は nil を返す
+func (input *interactiveInput) promptValue(key string, schema *jsonschema.Schema) (any, error) {
+	switch {
+	case len(schema.Enum) > 0:
+		return input.promptChoice(schema.Enum)
+
+	case schema.Type == "array":
+		return input.promptArray()
+
+	case schema.Type == "boolean":
+		for {
+			line, err := input.readLine("[y/n]> ")
+			if err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(line) {
+			case "":
+				return nil, nil
+			case "y", "yes", "true":
+				return "true", nil
+			case "n", "no", "false":
+				return "false", nil
+			}
+			fmt.Fprintf(input.writer, "  Please answer y or n.\n")
+		}
+
+	case schema.Type == "integer" || schema.Type == "number":
+		for {
+			line, err := input.readLine("> ")
+			if err != nil {
+				return nil, err
+			}
+			if line == "" {
+				return nil, nil
+			}
+			if schema.Type == "integer" {
+				_, err = strconv.ParseInt(line, 10, 64)
+			} else {
+				_, err = strconv.ParseFloat(line, 64)
+			}
+			if err == nil {
+				return line, nil
+			}
+			fmt.Fprintf(input.writer, "  Please enter a valid %s.\n", schema.Type)
+		}
+
+	case isPathInput(key, schema):
+		return input.promptPath()
+
+	default:
+		return input.promptString()
+	}
+}
+
+// 選択肢から値を選んでもらう
+func (input *interactiveInput) promptChoice(choices []any) (any, error) {
+	for i, choice := range choices {
+		fmt.Fprintf(input.writer, "  %d) %v\n", i+1, choice)
+	}
+
+	for {
+		line, err := input.readLine("> ")
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return nil, nil
+		}
+
+		// 番号か値そのもので選択できる
+		if number, err := strconv.Atoi(line); err == nil && number >= 1 && number <= len(choices) {
+			return fmt.Sprint(choices[number-1]), nil
+		}
+		if slices.ContainsFunc(choices, func(choice any) bool { return fmt.Sprint(choice) == line }) {
+			return line, nil
+		}
+		fmt.Fprintf(input.writer, "  Please choose one of the numbers above.\n")
+	}
+}
+
+// 配列の要素を 1 行にひとつずつ入力してもらう
+func (input *interactiveInput) promptArray() (any, error) {
+	fmt.Fprintf(input.writer, "  Enter one item per line, and an empty line to finish.\n")
+
+	items := []string{}
+	for {
+		line, err := input.readLine(fmt.Sprintf("[%d]> ", len(items)))
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		items = append(items, line)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	return items, nil
+}
+
+// 文字列を入力してもらう
+// """ で囲むと複数行の文字列を入力できる
+func (input *interactiveInput) promptString() (any, error) {
+	fmt.Fprintf(input.writer, "  Enter %s to start and end multi-line input.\n", multiLineDelimiter)
+
+	line, err := input.readLine("> ")
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, nil
+	}
+	if line != multiLineDelimiter {
+		return line, nil
+	}
+
+	lines := []string{}
+	for {
+		line, err := input.readRawLine("| ")
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == multiLineDelimiter {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// 作業ディレクトリのファイルから選ぶか、パスを入力してもらう
+func (input *interactiveInput) promptPath() (any, error) {
+	entries, _ := os.ReadDir(input.workdir)
+	choices := []string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		choices = append(choices, name)
+		if len(choices) >= maxFileChoices {
+			break
+		}
+	}
+	for i, choice := range choices {
+		fmt.Fprintf(input.writer, "  %d) %s\n", i+1, choice)
+	}
+	fmt.Fprintf(input.writer, "  Choose a number or enter a path relative to %s.\n", input.workdir)
+
+	line, err := input.readLine("> ")
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, nil
+	}
+	if number, err := strconv.Atoi(line); err == nil && number >= 1 && number <= len(choices) {
+		return strings.TrimSuffix(choices[number-1], "/"), nil
+	}
+
+	return filepath.ToSlash(filepath.Clean(line)), nil
+}
+
+// 1 行読み込み、前後の空白を取り除いて返す
+func (input *interactiveInput) readLine(prompt string) (string, error) {
+	line, err := input.readRawLine(prompt)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// 1 行読み込み、改行を取り除いて返す
+func (input *interactiveInput) readRawLine(prompt string) (string, error) {
+	fmt.Fprint(input.writer, prompt)
+	line, err := input.reader.ReadString('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(input.writer)
+			return "", errors.New("interactive input was canceled")
+		}
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// パスを表す入力か判定する
+// format で file、image、path、directory が指定されているか、入力名が path、file、dir で終わるもの
+func isPathInput(key string, schema *jsonschema.Schema) bool {
+	switch schema.Format {
+	case "file", "image", "path", "directory":
+		return true
+	}
+
+	name := strings.ToLower(key[strings.LastIndex(key, ".")+1:])
+	return strings.HasSuffix(name, "path") || strings.HasSuffix(name, "file") || strings.HasSuffix(name, "dir")
+}
+
+// 対話的に入力した値を含めた、同等の非対話的なコマンドラインを返す
+func equivalentCommandLine(args []string, arguments []string) string {
+	words := []string{filepath.Base(args[0])}
+	for _, arg := range args[1:] {
+		if arg == "--interactive" || arg == "-interactive" || strings.HasPrefix(arg, "--interactive=") {
+			continue
+		}
+		words = append(words, arg)
+	}
+	words = append(words, arguments...)
+
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, shellQuote(word))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// シェルで安全に使えるように引数をクォートする
+func shellQuote(word string) string {
+	if shellSafePattern.MatchString(word) {
+		return word
+	}
+
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 
 	"github.com/kurusugawa-computer/ace/app"
+	"github.com/kurusugawa-computer/ace/cache"
+	"github.com/kurusugawa-computer/ace/journal"
 	"github.com/urfave/cli/v3"
 )
 
@@ -46,6 +49,37 @@ func mcp(appName string, version string) *cli.Command {
 				HideDefault: true,
 				Value:       "off",
 			},
+			&cli.StringFlag{
+				Name:   "parent-agent",
+				Usage:  "set the name of the agent calling this agent as a sub-agent",
+				Hidden: true,
+			},
+			&cli.IntFlag{
+				Name:   "depth",
+				Usage:  "set the depth of sub-agent calls",
+				Hidden: true,
+			},
+			&cli.BoolFlag{
+				Name:  "cache",
+				Usage: "use cached responses for all agents, even if cache is not defined in the YAML file",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "do not use cached responses",
+			},
+			&cli.StringFlag{
+				Name:  "isolate",
+				Usage: "run the agent in an isolated copy of the working directory (\"tmp-copy\", \"git-worktree\")",
+			},
+			&cli.StringFlag{
+				Name:  "changes",
+				Usage: "set what to do with the changes in the isolated working directory (\"apply\", \"discard\", \"keep\")",
+				Value: "apply",
+			},
+			&cli.StringFlag{
+				Name:  "policy",
+				Usage: "set security policy file (default: policy.yaml in the user config directory, if it exists)",
+			},
 		},
 		Arguments: []cli.Argument{},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
@@ -55,12 +89,8 @@ func mcp(appName string, version string) *cli.Command {
 			envFiles := cmd.StringSlice("env-file")
 			codexPath := cmd.String("codex-path")
 			logLevel := cmd.String("log-level")
-
-			// OpenAI の API Key を取得
-			apiKey, err := getAPIKey(ctx, appName, codexPath, envFiles)
-			if err != nil {
-				return err
-			}
+			parentAgent := cmd.String("parent-agent")
+			depth := cmd.Int("depth")
 
 			// エージェントを定義したYAMLファイルを読み込み
 			config, err := app.LoadConfig(configPath)
@@ -69,19 +99,67 @@ func mcp(appName string, version string) *cli.Command {
 				return fmt.Errorf("%w: %s", ErrInternal, err)
 			}
 
+			// セキュリティポリシーを読み込む
+			policy, err := loadPolicy(cmd, appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load policy file.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 回答のキャッシュの利用方法を取得
+			cacheMode, err := cacheMode(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid cache options: %s\n", err)
+				return fmt.Errorf("%w: %s", ErrUsage, err)
+			}
+
+			// 作業ディレクトリの隔離方法を取得
+			isolate, changes, err := isolation(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid isolation options: %s\n", err)
+				return fmt.Errorf("%w: %s", ErrUsage, err)
+			}
+
 			// エージェント名のチェック
 			if cmd.Args().Len() == 0 {
 				fmt.Fprintf(os.Stderr, "Please specify AGENT_NAME.\n")
 				return fmt.Errorf("%w: %s", ErrUsage, err)
 			}
 
+			// OpenAI の API Key を取得
+			// 資格情報のプロファイルを利用するエージェントでは必須としない
+			apiKey, err := getAPIKey(ctx, appName, codexPath, envFiles, !usesCredentialProfile(config, cmd.Args().First()))
+			if err != nil {
+				return err
+			}
+
+			// 回答のキャッシュの保存先を開く
+			responseCache, err := cache.Open(appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open response cache.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 実行記録の保存先を開く
+			journal, err := journal.Open(appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open run journal.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
 			// アプリケーションをつくり、MCP Serverを実行
 			app := app.New(
 				config,
 				codexPath,
 				apiKey,
-				subAgentMCPServerConfig(configPath, workdir, codexPath, apiKey),
+				subAgentMCPServerConfig(configPath, codexPath, apiKey, slices.Concat(cacheModeFlags(cacheMode), isolationFlags(isolate), policyFlags(policy))),
 				app.WithLogger(os.Stderr, logLevel),
+				app.WithJournal(journal),
+				app.WithCredentialResolver(credentialResolver(appName)),
+				app.WithCache(responseCache, cacheMode),
+				app.WithPolicy(policy),
+				app.WithIsolation(isolate, changes),
+				app.WithParentAgent(parentAgent, depth),
 			)
 			agentName := cmd.Args().First()
 			if err := app.RunMCPServer(agentName, workdir); err != nil {
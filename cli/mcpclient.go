@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/kurusugawa-computer/ace/app"
+	"github.com/urfave/cli/v3"
+)
+
+var _ subCommand = mcpClient
+
+func mcpClient(appName string, version string) *cli.Command {
+	flags := func() []cli.Flag {
+		return []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "set YAML file where the AI ​​agent is defined",
+				Value:   "agent.yaml",
+			},
+			&cli.StringFlag{
+				Name:    "agent",
+				Aliases: []string{"a"},
+				Usage:   "set AI agent whose mcp_servers are used (default: search all agents)",
+			},
+			&cli.StringFlag{
+				Name:    "workdir",
+				Aliases: []string{"w"},
+				Usage:   "set working directory",
+				Value:   ".",
+			},
+			&cli.StringSliceFlag{
+				Name:  "env-file",
+				Usage: "set an alternate environment file",
+				Value: []string{".env"},
+			},
+		}
+	}
+
+	return &cli.Command{
+		Name:      "mcp",
+		Aliases:   []string{},
+		Usage:     "Start MCP servers defined in mcp_servers and list or call their tools without running a model.",
+		ArgsUsage: " ",
+		Commands: []*cli.Command{
+			{
+				Name:      "list-tools",
+				Usage:     "List tools provided by MCP servers defined in mcp_servers.",
+				ArgsUsage: "[SERVER_NAME]",
+				Flags:     flags(),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					// オプション引数の値を取得
+					configPath := cmd.String("config")
+					agentName := cmd.String("agent")
+					workdir := cmd.String("workdir")
+					envFiles := cmd.StringSlice("env-file")
+
+					app, err := newMCPClientApp(configPath, envFiles)
+					if err != nil {
+						return err
+					}
+
+					servers, err := app.MCPServers(agentName, cmd.Args().First())
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to find MCP servers: %s\n", err)
+						return fmt.Errorf("%w: %s", ErrUsage, err)
+					}
+
+					// MCP Server ごとにツールの一覧を出力
+					failed := false
+					for _, server := range servers {
+						fmt.Printf("%s (agent: %s)\n", server.ServerName, server.AgentName)
+
+						session, err := server.Connect(ctx, workdir)
+						if err != nil {
+							fmt.Printf("  ! %s\n", err)
+							failed = true
+							continue
+						}
+						tools, err := server.ListTools(ctx, session)
+						session.Close()
+						if err != nil {
+							fmt.Printf("  ! failed to list tools: %s\n", err)
+							failed = true
+							continue
+						}
+
+						for _, tool := range tools {
+							mark := "-"
+							if tool.Enabled {
+								mark = "+"
+							}
+							description, _, _ := strings.Cut(strings.TrimSpace(tool.Tool.Description), "\n")
+							fmt.Printf("  %s %s\t%s\n", mark, tool.Tool.Name, description)
+						}
+						for _, name := range server.MissingTools(tools) {
+							fmt.Printf("  ! %s\tlisted in enabled_tools but not provided by the server\n", name)
+							failed = true
+						}
+					}
+
+					if failed {
+						return fmt.Errorf("%w: %s", ErrInternal, errors.New("some MCP servers have problems"))
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "call",
+				Usage:     "Call a tool of an MCP server defined in mcp_servers and print the result as JSON.",
+				ArgsUsage: "SERVER_NAME TOOL_NAME [KEY=VALUE...]",
+				Flags:     flags(),
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					// オプション引数の値を取得
+					configPath := cmd.String("config")
+					agentName := cmd.String("agent")
+					workdir := cmd.String("workdir")
+					envFiles := cmd.StringSlice("env-file")
+
+					// 引数のチェック
+					if cmd.Args().Len() < 2 {
+						fmt.Fprintf(os.Stderr, "Please specify SERVER_NAME and TOOL_NAME.\n")
+						return fmt.Errorf("%w: %s", ErrUsage, errors.New("missing arguments"))
+					}
+					serverName := cmd.Args().Get(0)
+					toolName := cmd.Args().Get(1)
+
+					app, err := newMCPClientApp(configPath, envFiles)
+					if err != nil {
+						return err
+					}
+
+					server, err := app.MCPServer(agentName, serverName)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to find MCP server: %s\n", err)
+						return fmt.Errorf("%w: %s", ErrUsage, err)
+					}
+
+					// MCP Server に接続してツールを探す
+					session, err := server.Connect(ctx, workdir)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to start MCP server.\n")
+						return fmt.Errorf("%w: %s", ErrInternal, err)
+					}
+					defer session.Close()
+
+					tools, err := server.ListTools(ctx, session)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to list tools.\n")
+						return fmt.Errorf("%w: %s", ErrInternal, err)
+					}
+					index := -1
+					for i, tool := range tools {
+						if tool.Tool.Name == toolName {
+							index = i
+							break
+						}
+					}
+					if index < 0 {
+						fmt.Fprintf(os.Stderr, "No such tool: %s\n", toolName)
+						return fmt.Errorf("%w: %s", ErrUsage, errors.New("no such tool: "+toolName))
+					}
+					if !tools[index].Enabled {
+						fmt.Fprintf(os.Stderr, "Warning: %s is not enabled for Codex by enabled_tools or disabled_tools.\n", toolName)
+					}
+
+					// ツールを呼び出す
+					result, err := app.CallMCPTool(ctx, session, tools[index].Tool, cmd.Args().Slice()[2:])
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to call tool.\n")
+						return fmt.Errorf("%w: %s", ErrInternal, err)
+					}
+
+					// ツールの結果を JSON 形式で出力
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					_ = enc.Encode(app.MCPToolOutput(result))
+
+					if result.IsError {
+						return fmt.Errorf("%w: %s", ErrInternal, errors.New("tool returned an error"))
+					}
+
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// MCP Client として利用するアプリケーションをつくる
+// エージェントは実行しないので、Codex と API Key は不要
+func newMCPClientApp(configPath string, envFiles []string) (*app.App, error) {
+	// MCP Server に渡す環境変数を .env ファイルから読み込む
+	_ = godotenv.Load(envFiles...)
+
+	// エージェントを定義したYAMLファイルを読み込み
+	config, err := app.LoadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load agent defined YAML file.\n")
+		return nil, fmt.Errorf("%w: %s", ErrInternal, err)
+	}
+
+	return app.New(config, "", "", nil), nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strings"
 
 	cre "github.com/kurusugawa-computer/ace/cli/credentials"
@@ -20,21 +22,112 @@ func setup(appName string, version string) *cli.Command {
 		Aliases:   []string{},
 		Usage:     "Register your OpenAI API Key and setup " + appName + ".",
 		ArgsUsage: " ",
-		Flags:     []cli.Flag{},
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "profile",
+				Usage: "set credential profile name referenced by the credential of agents",
+				Value: cre.DefaultProfileName,
+			},
+			&cli.StringFlag{
+				Name:  "provider",
+				Usage: "set model provider (\"openai\", \"azure\", \"openai-compatible\")",
+				Value: cre.ProviderOpenAI,
+			},
+			&cli.StringFlag{
+				Name:  "base-url",
+				Usage: "set Azure OpenAI endpoint or base URL of OpenAI compatible API",
+			},
+			&cli.StringFlag{
+				Name:  "api-version",
+				Usage: "set Azure OpenAI API version",
+			},
+			&cli.StringFlag{
+				Name:  "backend",
+				Usage: "switch where credentials are stored (\"file\", \"keyring\", \"command\", \"env\") and migrate saved credentials",
+			},
+			&cli.StringFlag{
+				Name:  "credential-command",
+				Usage: "set command that prints the API key, used with --backend command",
+			},
+			&cli.BoolFlag{
+				Name:  "from-env",
+				Usage: "read the API key from the environment variable OPENAI_API_KEY (AZURE_OPENAI_API_KEY for azure) instead of prompting",
+			},
+			&cli.BoolFlag{
+				Name:  "stdin",
+				Usage: "read the API key from stdin instead of prompting",
+			},
+			&cli.StringFlag{
+				Name:  "key-file",
+				Usage: "read the API key from the file instead of prompting",
+			},
+			&cli.BoolFlag{
+				Name:  "verify",
+				Usage: "verify the API key with the model listing endpoint of the provider before saving",
+			},
+			&cli.StringFlag{
+				Name:  "model",
+				Usage: "set model to check that it is accessible, used with --verify",
+			},
+		},
 		Arguments: []cli.Argument{},
 		Action: func(aContext context.Context, aCommand *cli.Command) error {
-			// OpenAI の API Key を入力してもらう
-			openAIAPIKey, err := ReadPassword("Enter your OpenAI API key")
+			// オプション引数の値を取得
+			profileName := aCommand.String("profile")
+			profile := &cre.Profile{
+				Provider:   aCommand.String("provider"),
+				BaseURL:    aCommand.String("base-url"),
+				APIVersion: aCommand.String("api-version"),
+			}
+
+			// 資格情報の保存先を切り替える
+			if aCommand.IsSet("backend") {
+				settings := &cre.Settings{
+					Backend:           aCommand.String("backend"),
+					CredentialCommand: aCommand.String("credential-command"),
+				}
+				migrated, err := cre.Migrate(appName, settings)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to switch credential backend.\n")
+					return fmt.Errorf("%w: %s", ErrInternal, err)
+				}
+				fmt.Printf("Credential backend switched to %s.\n", settings.Backend)
+
+				// 保存されていた資格情報を移した場合や、書き込めない保存先の場合は入力は不要
+				if migrated {
+					fmt.Printf("Saved credentials were migrated.\n")
+					return nil
+				}
+				if settings.Backend == cre.BackendCommand || settings.Backend == cre.BackendEnv {
+					return nil
+				}
+			}
+
+			// API Key を取得
+			// --from-env、--stdin、--key-file のいずれも指定されていなければ、対話的に入力してもらう
+			apiKey, err := readAPIKey(aCommand, profile.Provider)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to read OpenAI API key.\n")
-				return fmt.Errorf("%w: %s", ErrInternal, err)
+				fmt.Fprintf(os.Stderr, "Failed to read API key: %s\n", err)
+				return fmt.Errorf("%w: %s", ErrUsage, err)
 			}
+			profile.APIKey = strings.TrimSpace(apiKey)
 
-			// OpenAI の API Key を保存
-			credentials := &cre.Credentials{
-				OpenAIAPIKey: strings.TrimSpace(openAIAPIKey),
+			// プロファイルの検証
+			if err := profile.Validate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid credential profile: %s\n", err)
+				return fmt.Errorf("%w: %s", ErrUsage, err)
 			}
-			if err := cre.Save(appName, credentials); err != nil {
+
+			// API Key が有効か確認
+			if aCommand.Bool("verify") {
+				if err := verifyCredential(aContext, profile, aCommand.String("model")); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to verify API key.\n")
+					return fmt.Errorf("%w: %s", ErrInternal, err)
+				}
+			}
+
+			// API Key を保存
+			if err := cre.SaveProfile(appName, profileName, profile); err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to save credentials.\n")
 				return fmt.Errorf("%w: %s", ErrInternal, err)
 			}
@@ -47,6 +140,71 @@ func setup(appName string, version string) *cli.Command {
 	}
 }
 
+// API Key の入力元のオプションに従って API Key を読み込む
+func readAPIKey(aCommand *cli.Command, provider string) (string, error) {
+	sources := 0
+	for _, name := range []string{"from-env", "stdin", "key-file"} {
+		if aCommand.IsSet(name) {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", errors.New("--from-env, --stdin and --key-file cannot be specified at the same time")
+	}
+
+	switch {
+	case aCommand.Bool("from-env"):
+		name := "OPENAI_API_KEY"
+		if provider == cre.ProviderAzure {
+			name = "AZURE_OPENAI_API_KEY"
+		}
+		apiKey := os.Getenv(name)
+		if apiKey == "" {
+			return "", errors.New("environment variable " + name + " is not set")
+		}
+		return apiKey, nil
+
+	case aCommand.Bool("stdin"):
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+
+	case aCommand.String("key-file") != "":
+		b, err := os.ReadFile(aCommand.String("key-file"))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+
+	default:
+		message := "Enter your OpenAI API key"
+		if provider != cre.ProviderOpenAI {
+			message = "Enter your API key for " + provider
+		}
+		return ReadPassword(message)
+	}
+}
+
+// プロバイダーのモデル一覧を取得して、API Key が有効か、モデルが利用できるか確認する
+func verifyCredential(ctx context.Context, profile *cre.Profile, model string) error {
+	models, err := providerOf(profile).ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("The API key is valid (%d models available).\n", len(models))
+
+	if model != "" {
+		if !slices.Contains(models, model) {
+			return errors.New("model is not accessible with the API key: " + model)
+		}
+		fmt.Printf("Model %s is accessible.\n", model)
+	}
+
+	return nil
+}
+
 func ReadPassword(aMessage string) (string, error) {
 	if !term.IsTerminal(int(os.Stdin.Fd())) {
 		return "", errors.New("stdin is not a terminal")
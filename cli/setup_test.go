@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	cre "github.com/kurusugawa-computer/ace/cli/credentials"
+)
+
+func TestSetup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG directories are only used on linux")
+	}
+	const appName = "ace-test"
+
+	// 正しい API Key にだけモデルの一覧を返す OpenAI 互換 API
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer valid-key" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":{"message":"invalid api key","type":"invalid_request_error"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"object":"list","data":[{"id":"gpt-5-mini","object":"model","created":0,"owned_by":"test"}]}`)
+	}))
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(keyFile, []byte("valid-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		wantErr    error
+		wantAPIKey string // 保存された API Key。空なら保存されていないこと
+	}{
+		{
+			name:       "key file",
+			args:       []string{"--key-file", keyFile},
+			wantAPIKey: "valid-key",
+		},
+		{
+			name:       "from env",
+			args:       []string{"--from-env"},
+			env:        map[string]string{"OPENAI_API_KEY": "env-key"},
+			wantAPIKey: "env-key",
+		},
+		{
+			name:       "from env for azure",
+			args:       []string{"--from-env", "--provider", "azure", "--base-url", "https://example.openai.azure.com"},
+			env:        map[string]string{"OPENAI_API_KEY": "openai-key", "AZURE_OPENAI_API_KEY": "azure-key"},
+			wantAPIKey: "azure-key",
+		},
+		{
+			name:    "from env without the variable",
+			args:    []string{"--from-env"},
+			wantErr: ErrUsage,
+		},
+		{
+			name:    "multiple sources",
+			args:    []string{"--from-env", "--key-file", keyFile},
+			env:     map[string]string{"OPENAI_API_KEY": "env-key"},
+			wantErr: ErrUsage,
+		},
+		{
+			name:    "invalid profile",
+			args:    []string{"--key-file", keyFile, "--provider", "azure"},
+			wantErr: ErrUsage,
+		},
+		{
+			name:       "verify",
+			args:       []string{"--key-file", keyFile, "--provider", "openai-compatible", "--base-url", server.URL + "/v1", "--verify", "--model", "gpt-5-mini"},
+			wantAPIKey: "valid-key",
+		},
+		{
+			name:    "verify inaccessible model",
+			args:    []string{"--key-file", keyFile, "--provider", "openai-compatible", "--base-url", server.URL + "/v1", "--verify", "--model", "o3"},
+			wantErr: ErrInternal,
+		},
+		{
+			name:    "verify invalid key",
+			args:    []string{"--from-env", "--provider", "openai-compatible", "--base-url", server.URL + "/v1", "--verify"},
+			env:     map[string]string{"OPENAI_API_KEY": "invalid-key"},
+			wantErr: ErrInternal,
+		},
+		{
+			name: "read-only backend",
+			args: []string{"--backend", "env"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+			t.Setenv("OPENAI_API_KEY", "")
+			t.Setenv("AZURE_OPENAI_API_KEY", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			err := setup(appName, "test").Run(context.Background(), append([]string{"setup"}, tt.args...))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("setup error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			profile, err := cre.LoadProfile(appName, cre.DefaultProfileName)
+			if tt.wantAPIKey == "" {
+				if err == nil {
+					t.Errorf("API key should not be saved: %q", profile.APIKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profile.APIKey != tt.wantAPIKey {
+				t.Errorf("saved API key = %q, want %q", profile.APIKey, tt.wantAPIKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kurusugawa-computer/ace/app"
+	"github.com/urfave/cli/v3"
+)
+
+var _ subCommand = validate
+
+func validate(appName string, version string) *cli.Command {
+	return &cli.Command{
+		Name:      "validate",
+		Aliases:   []string{},
+		Usage:     "Validate the agent definitions against the security policy and show the network access of each agent.",
+		ArgsUsage: "[AGENT_NAME...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "set YAML file where the AI ​​agent is defined",
+				Value:   "agent.yaml",
+			},
+			&cli.StringFlag{
+				Name:  "policy",
+				Usage: "set security policy file (default: policy.yaml in the user config directory, if it exists)",
+			},
+		},
+		Arguments: []cli.Argument{},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			// オプション引数の値を取得
+			configPath := cmd.String("config")
+
+			// エージェントを定義したYAMLファイルを読み込み
+			config, err := app.LoadConfig(configPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load agent defined YAML file.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// セキュリティポリシーを読み込む
+			policy, err := loadPolicy(cmd, appName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load policy file.\n")
+				return fmt.Errorf("%w: %s", ErrInternal, err)
+			}
+
+			// 検証するエージェント
+			// 指定されていなければ、すべてのエージェントを検証する
+			agentNames := cmd.Args().Slice()
+			if len(agentNames) == 0 {
+				agentNames = slices.Sorted(maps.Keys(config.Agents))
+			}
+
+			// エージェントごとに検証し、ネットワークへのアクセスの状況を出力
+			app := app.New(config, "", "", nil, app.WithPolicy(policy))
+			invalid := 0
+			for _, agentName := range agentNames {
+				if err := app.ValidateAgent(agentName); err != nil {
+					invalid++
+					fmt.Fprintf(os.Stdout, "[NG] agent %s: %s\n", agentName, err)
+					continue
+				}
+				exposure, err := app.NetworkExposure(agentName)
+				if err != nil {
+					invalid++
+					fmt.Fprintf(os.Stdout, "[NG] agent %s: %s\n", agentName, err)
+					continue
+				}
+				fmt.Fprintf(os.Stdout, "[OK] agent %s\n", agentName)
+				printNetworkExposure(os.Stdout, exposure)
+			}
+
+			if invalid > 0 {
+				fmt.Fprintf(os.Stderr, "%d of %d agents are invalid.\n", invalid, len(agentNames))
+				return fmt.Errorf("%w: %s", ErrInternal, errors.New("some agents are invalid"))
+			}
+
+			return nil
+		},
+	}
+}
+
+// エージェントのネットワークへのアクセスの状況を出力する
+func printNetworkExposure(w io.Writer, exposure *app.NetworkExposure) {
+	mode := exposure.Mode
+	if mode == "" {
+		mode = "(not set)"
+	}
+	if len(exposure.AllowedHosts) > 0 {
+		mode += " (mcp server hosts: " + strings.Join(exposure.AllowedHosts, ", ") + ")"
+	}
+
+	fmt.Fprintf(w, "    network:     %s\n", mode)
+	fmt.Fprintf(w, "    sandbox:     %s (network %s)\n", exposure.Sandbox, enabledText(exposure.SandboxNetwork))
+	fmt.Fprintf(w, "    web search:  %s\n", enabledText(exposure.WebSearch))
+	fmt.Fprintf(w, "    mcp servers: %s\n", listText(exposure.MCPServers))
+	fmt.Fprintf(w, "    sub agents:  %s\n", listText(exposure.SubAgents))
+}
+
+func enabledText(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func listText(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ", ")
+}
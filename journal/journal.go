@@ -0,0 +1,297 @@
+package journal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thamaji/files"
+)
+
+// エージェントの実行記録
+type Run struct {
+	ID         string         `json:"id"`                  // 実行ID
+	Agent      string         `json:"agent"`               // エージェント名
+	Session    string         `json:"session,omitempty"`   // セッション名
+	ParentID   string         `json:"parent_id,omitempty"` // 会話を継続した元の実行ID
+	Workdir    string         `json:"workdir"`             // 作業ディレクトリ
+	Input      map[string]any `json:"input,omitempty"`     // エージェントへの入力
+	Prompt     string         `json:"prompt,omitempty"`    // Codex に与えたプロンプト
+	Answer     string         `json:"answer,omitempty"`    // Codex の回答
+	Output     any            `json:"output,omitempty"`    // エージェントの出力
+	Checks     []*Check       `json:"checks,omitempty"`    // 出力にたいする検査の結果
+	Cached     bool           `json:"cached,omitempty"`    // キャッシュした回答を返した場合は true
+	Model      string         `json:"model,omitempty"`     // 回答したモデル
+	Diff       string         `json:"diff,omitempty"`      // 作業ディレクトリを隔離した場合、作業ディレクトリでの変更の差分
+	Workspace  string         `json:"workspace,omitempty"` // 作業ディレクトリを隔離して残した場合、そのパス
+	Error      string         `json:"error,omitempty"`     // 実行に失敗した場合のエラーメッセージ
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+}
+
+// 出力にたいする検査の結果
+type Check struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"` // 検査を満たさなかった理由
+}
+
+// 検査をすべて満たしたか判定する
+func (run *Run) ChecksPassed() bool {
+	for _, check := range run.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// 実行記録を保存するディレクトリ
+type Journal struct {
+	dir string
+
+	// 残す実行記録の数と期間の上限
+	// 保存するたびに、これを超えた古い実行記録を削除する。0 なら制限しない
+	MaxRuns int
+	MaxAge  time.Duration
+}
+
+// 残す実行記録の数と期間の上限のデフォルト値
+const (
+	DefaultMaxRuns = 1000
+	DefaultMaxAge  = 30 * 24 * time.Hour
+)
+
+// セッションごとの最新の実行ID を保存するディレクトリの名前
+const sessionsDirName = "sessions"
+
+func New(dir string) *Journal {
+	return &Journal{dir: dir, MaxRuns: DefaultMaxRuns, MaxAge: DefaultMaxAge}
+}
+
+// ユーザーのキャッシュディレクトリ以下に実行記録を保存する Journal を返す
+func Open(appName string) (*Journal, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(filepath.Join(cacheDir, appName, "runs")), nil
+}
+
+// 新しい実行IDを発行する
+// 実行IDは開始日時から始まるので、名前の順に並べると開始日時の順になる
+func NewID() string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(random)
+}
+
+// 実行記録をJSON形式で保存する
+// セッションの成功した実行であれば、セッションの最新の実行として記録する
+// 保存したあとに、上限を超えた古い実行記録を削除する
+func (journal *Journal) Save(run *Run) error {
+	if run.ID == "" || strings.ContainsAny(run.ID, `/\`) {
+		return errors.New("invalid run id: " + run.ID)
+	}
+
+	if err := writeJSON(filepath.Join(journal.dir, run.ID+".json"), run); err != nil {
+		return err
+	}
+
+	if run.Session != "" && run.Error == "" {
+		if err := journal.saveLatest(run); err != nil {
+			return err
+		}
+	}
+
+	return journal.prune()
+}
+
+// 実行記録を読み込む
+func (journal *Journal) Load(id string) (*Run, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, errors.New("invalid run id: " + id)
+	}
+
+	run := &Run{}
+	if err := readJSON(filepath.Join(journal.dir, id+".json"), run); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("no such run: " + id)
+		}
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// すべての実行IDを新しい順に返す
+// 実行記録そのものは読み込まない
+func (journal *Journal) IDs() ([]string, error) {
+	entries, err := os.ReadDir(journal.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	return ids, nil
+}
+
+// セッションの最新の実行を記録したファイルの内容
+type latest struct {
+	Agent     string    `json:"agent"`
+	Session   string    `json:"session"`
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// セッションの最新の実行を記録するファイルのパスを返す
+func (journal *Journal) latestPath(agentName string, session string) string {
+	sum := sha256.Sum256([]byte(agentName + "\x00" + session))
+	return filepath.Join(journal.dir, sessionsDirName, hex.EncodeToString(sum[:16])+".json")
+}
+
+// セッションの最新の実行として記録する
+// 同じセッションでより後に開始した実行が記録されていれば、記録しない
+func (journal *Journal) saveLatest(run *Run) error {
+	path := journal.latestPath(run.Agent, run.Session)
+	current := &latest{}
+	if err := readJSON(path, current); err == nil && current.StartedAt.After(run.StartedAt) {
+		return nil
+	}
+
+	return writeJSON(path, &latest{Agent: run.Agent, Session: run.Session, ID: run.ID, StartedAt: run.StartedAt})
+}
+
+// セッションの最新の成功した実行記録を返す
+// セッションが存在しない場合は nil を返す
+func (journal *Journal) Latest(agentName string, session string) (*Run, error) {
+	// セッションの最新の実行の記録から読み込む
+	current := &latest{}
+	if err := readJSON(journal.latestPath(agentName, session), current); err == nil && current.Agent == agentName && current.Session == session {
+		if run, err := journal.Load(current.ID); err == nil {
+			return run, nil
+		}
+	}
+
+	// 記録がないか、実行記録が削除されていれば、新しい順に探す
+	ids, err := journal.IDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		run, err := journal.Load(id)
+		if err != nil {
+			continue // 壊れた記録は無視する
+		}
+		if run.Agent == agentName && run.Session == session && run.Error == "" {
+			return run, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// 実行記録から ParentID をたどり、会話の履歴を古い順に返す
+// 失敗した実行は履歴に含めない
+// 古い実行記録が削除されていれば、残っている実行記録までを履歴とする
+func (journal *Journal) History(id string) ([]*Run, error) {
+	history := []*Run{}
+	visited := map[string]bool{}
+	for id != "" {
+		if visited[id] {
+			return nil, errors.New("circular run history: " + id)
+		}
+		visited[id] = true
+
+		run, err := journal.Load(id)
+		if err != nil {
+			if len(visited) > 1 {
+				break
+			}
+			return nil, err
+		}
+		if run.Error == "" {
+			history = append(history, run)
+		}
+		id = run.ParentID
+	}
+
+	// 古い順に並べ替える
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+
+	return history, nil
+}
+
+// 上限を超えた古い実行記録を削除する
+// 実行記録は読み込まず、実行IDの順と更新日時で判定する
+func (journal *Journal) prune() error {
+	ids, err := journal.IDs()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		path := filepath.Join(journal.dir, id+".json")
+		expired := journal.MaxRuns > 0 && i >= journal.MaxRuns
+		if !expired && journal.MaxAge > 0 {
+			info, err := os.Stat(path)
+			expired = err == nil && time.Since(info.ModTime()) > journal.MaxAge
+		}
+		if !expired {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 値を JSON 形式でファイルに書き込む
+func writeJSON(path string, v any) error {
+	f, err := files.OpenFileWriter(path)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(v)
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
+
+// JSON 形式のファイルを読み込む
+func readJSON(path string, v any) error {
+	f, err := files.OpenFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
@@ -0,0 +1,254 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	journal := New(t.TempDir())
+	startedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	run := &Run{
+		ID:         NewID(),
+		Agent:      "root",
+		Session:    "s",
+		ParentID:   "parent",
+		Workdir:    "/work",
+		Input:      map[string]any{"question": "q", "count": float64(1)},
+		Prompt:     "prompt",
+		Answer:     "answer",
+		Output:     map[string]any{"answer": "a"},
+		Checks:     []*Check{{Name: "check", Passed: false, Message: "failed"}},
+		Cached:     true,
+		Model:      "gpt-5",
+		Diff:       "diff",
+		Workspace:  "/tmp/ws",
+		Error:      "error",
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(time.Second),
+	}
+
+	if err := journal.Save(run); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := journal.Load(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, run) {
+		t.Errorf("Load() = %+v, want %+v", loaded, run)
+	}
+}
+
+func TestInvalidID(t *testing.T) {
+	journal := New(t.TempDir())
+	for _, id := range []string{"", "../a", `a\b`} {
+		if err := journal.Save(&Run{ID: id}); err == nil {
+			t.Errorf("Save() with id %q should fail", id)
+		}
+		if _, err := journal.Load(id); err == nil {
+			t.Errorf("Load() with id %q should fail", id)
+		}
+	}
+	if _, err := journal.Load("missing"); err == nil {
+		t.Error("Load() of a missing run should fail")
+	}
+}
+
+func TestIDsLatest(t *testing.T) {
+	dir := t.TempDir()
+	journal := New(dir)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	runs := []*Run{
+		{ID: "20260101T030000-c", Agent: "root", Session: "s", StartedAt: base.Add(3 * time.Hour)},
+		{ID: "20260101T010000-a", Agent: "root", Session: "s", StartedAt: base.Add(1 * time.Hour)},
+		{ID: "20260101T020000-b", Agent: "root", Session: "s", StartedAt: base.Add(2 * time.Hour)},
+		{ID: "20260101T040000-d", Agent: "root", Session: "s", StartedAt: base.Add(4 * time.Hour), Error: "failed"},
+		{ID: "20260101T050000-e", Agent: "other", Session: "s", StartedAt: base.Add(5 * time.Hour)},
+	}
+	for _, run := range runs {
+		if err := journal.Save(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := journal.IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"20260101T050000-e", "20260101T040000-d", "20260101T030000-c", "20260101T020000-b", "20260101T010000-a"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("IDs() = %v, want %v", ids, want)
+	}
+
+	tests := []struct {
+		agentName string
+		session   string
+		want      string
+	}{
+		{agentName: "root", session: "s", want: "20260101T030000-c"},
+		{agentName: "other", session: "s", want: "20260101T050000-e"},
+		{agentName: "root", session: "missing", want: ""},
+	}
+	for _, tt := range tests {
+		latest, err := journal.Latest(tt.agentName, tt.session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if latest != nil {
+			got = latest.ID
+		}
+		if got != tt.want {
+			t.Errorf("Latest(%q, %q) = %q, want %q", tt.agentName, tt.session, got, tt.want)
+		}
+	}
+}
+
+func TestLatestWithoutIndex(t *testing.T) {
+	dir := t.TempDir()
+	journal := New(dir)
+	for _, run := range []*Run{
+		{ID: "20260101T010000-a", Agent: "root", Session: "s"},
+		{ID: "20260101T020000-b", Agent: "root", Session: "s"},
+	} {
+		if err := journal.Save(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// セッションの最新の実行の記録がなければ、実行記録から探す
+	if err := os.RemoveAll(filepath.Join(dir, sessionsDirName)); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := journal.Latest("root", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == nil || latest.ID != "20260101T020000-b" {
+		t.Errorf("Latest() = %+v, want 20260101T020000-b", latest)
+	}
+
+	// 記録した実行記録が削除されていても、残っている実行記録から探す
+	if err := journal.Save(&Run{ID: "20260101T030000-c", Agent: "root", Session: "s", StartedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "20260101T030000-c.json")); err != nil {
+		t.Fatal(err)
+	}
+	latest, err = journal.Latest("root", "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == nil || latest.ID != "20260101T020000-b" {
+		t.Errorf("Latest() after removal = %+v, want 20260101T020000-b", latest)
+	}
+}
+
+func TestIDsEmpty(t *testing.T) {
+	journal := New(filepath.Join(t.TempDir(), "missing"))
+	ids, err := journal.IDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("IDs() = %v, want empty", ids)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxRuns int
+		maxAge  time.Duration
+		old     bool // 最も古い実行記録の更新日時を古くする
+		want    []string
+	}{
+		{name: "unlimited", want: []string{"3", "2", "1"}},
+		{name: "max runs", maxRuns: 2, want: []string{"3", "2"}},
+		{name: "max age", maxAge: time.Hour, old: true, want: []string{"3", "2"}},
+		{name: "not expired", maxAge: time.Hour, want: []string{"3", "2", "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			journal := New(dir)
+			journal.MaxRuns, journal.MaxAge = 0, 0
+			for _, id := range []string{"1", "2"} {
+				if err := journal.Save(&Run{ID: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.old {
+				old := time.Now().Add(-2 * time.Hour)
+				if err := os.Chtimes(filepath.Join(dir, "1.json"), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			journal.MaxRuns, journal.MaxAge = tt.maxRuns, tt.maxAge
+			if err := journal.Save(&Run{ID: "3"}); err != nil {
+				t.Fatal(err)
+			}
+			ids, err := journal.IDs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("IDs() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	journal := New(t.TempDir())
+	runs := []*Run{
+		{ID: "1", Prompt: "p1"},
+		{ID: "2", ParentID: "1", Prompt: "p2", Error: "failed"},
+		{ID: "3", ParentID: "2", Prompt: "p3"},
+		{ID: "x", ParentID: "y"},
+		{ID: "y", ParentID: "x"},
+		{ID: "pruned", ParentID: "removed", Prompt: "p"},
+	}
+	for _, run := range runs {
+		if err := journal.Save(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		want    []string
+		wantErr bool
+	}{
+		{name: "first", id: "1", want: []string{"1"}},
+		{name: "failed runs are skipped", id: "3", want: []string{"1", "3"}},
+		{name: "empty", id: "", want: []string{}},
+		{name: "circular", id: "x", wantErr: true},
+		{name: "pruned parent", id: "pruned", want: []string{"pruned"}},
+		{name: "missing", id: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := journal.History(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("History(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			ids := []string{}
+			for _, run := range history {
+				ids = append(ids, run.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("History(%q) = %v, want %v", tt.id, ids, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package redact
+
+import (
+	"bytes"
+	"cmp"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 取り除いた値の代わりに置く文字列
+const Placeholder = "[REDACTED]"
+
+// 取り除く値の最短の長さ
+// 短い値を取り除くと、関係のない文字列まで置き換えてしまうため
+const minValueLength = 8
+
+// 組み込みの API Key やトークンのパターン
+var builtinPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_-]{20,}`),                                                      // OpenAI, Anthropic
+	regexp.MustCompile(`gh[pousr]_[A-Za-z0-9]{36,}`),                                                 // GitHub
+	regexp.MustCompile(`github_pat_[A-Za-z0-9_]{22,}`),                                               // GitHub (fine-grained)
+	regexp.MustCompile(`xox[abposr]-[A-Za-z0-9-]{10,}`),                                              // Slack
+	regexp.MustCompile(`AKIA[0-9A-Z]{16}`),                                                           // AWS
+	regexp.MustCompile(`AIza[0-9A-Za-z_-]{35}`),                                                      // Google
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/-]{16,}=*`),                                      // Authorization ヘッダー
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), // 秘密鍵
+}
+
+// 文字列から秘密の情報を取り除く
+type Redactor struct {
+	patterns []*regexp.Regexp
+
+	mutex  sync.RWMutex
+	values []string // 長いものから順に並べる
+}
+
+// 組み込みのパターンと patterns に一致する文字列、values の値を取り除く Redactor を返す
+func New(patterns []*regexp.Regexp, values ...string) *Redactor {
+	redactor := &Redactor{
+		patterns: slices.Concat(builtinPatterns, patterns),
+	}
+	redactor.AddValues(values...)
+	return redactor
+}
+
+// 取り除く値を追加する
+// 資格情報のように、実行中に解決する値を追加するために用いる
+func (redactor *Redactor) AddValues(values ...string) {
+	redactor.mutex.Lock()
+	defer redactor.mutex.Unlock()
+
+	for _, value := range values {
+		if len(value) < minValueLength || slices.Contains(redactor.values, value) {
+			continue
+		}
+		redactor.values = append(redactor.values, value)
+	}
+
+	// ある値を含む長い値が部分的に残らないように、長い値から置き換える
+	slices.SortFunc(redactor.values, func(a string, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+}
+
+// 文字列から秘密の情報を取り除いたものを返す
+func (redactor *Redactor) String(s string) string {
+	redactor.mutex.RLock()
+	for _, value := range redactor.values {
+		s = strings.ReplaceAll(s, value, Placeholder)
+	}
+	redactor.mutex.RUnlock()
+
+	for _, pattern := range redactor.patterns {
+		s = pattern.ReplaceAllString(s, Placeholder)
+	}
+	return s
+}
+
+// JSON として表せる値に含まれる文字列から、秘密の情報を取り除いたものを返す
+// 元の値は書き換えない
+func (redactor *Redactor) Value(value any) any {
+	switch value := value.(type) {
+	case string:
+		return redactor.String(value)
+
+	case map[string]any:
+		redacted := make(map[string]any, len(value))
+		for key, child := range value {
+			redacted[key] = redactor.Value(child)
+		}
+		return redacted
+
+	case []any:
+		redacted := make([]any, 0, len(value))
+		for _, child := range value {
+			redacted = append(redacted, redactor.Value(child))
+		}
+		return redacted
+
+	case []string:
+		redacted := make([]string, 0, len(value))
+		for _, child := range value {
+			redacted = append(redacted, redactor.String(child))
+		}
+		return redacted
+
+	default:
+		return value
+	}
+}
+
+// 書き込みをためておく最大のバイト数
+// 改行を含まない書き込みが続いても、これを超えたら書き出す
+const maxPendingBytes = 64 * 1024
+
+// 書き込む内容から秘密の情報を取り除いて w に書き込む Writer を返す
+func (redactor *Redactor) Writer(w io.Writer) *Writer {
+	return &Writer{redactor: redactor, w: w}
+}
+
+// 書き込む内容から秘密の情報を取り除いて書き込む Writer
+// ひとつの値が複数の書き込みに分かれても取り除けるように、改行までためてから取り除いて書き出す。
+// 秘密鍵は複数の行にわたるので、終わりの行までためる。
+// ためた内容は Flush で書き出す。
+type Writer struct {
+	redactor *Redactor
+	w        io.Writer
+
+	mutex   sync.Mutex
+	pending []byte // まだ書き出していない内容
+}
+
+func (writer *Writer) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.pending = append(writer.pending, p...)
+
+	// 最後の改行までを書き出す。ためすぎた場合はすべて書き出す
+	n := bytes.LastIndexByte(writer.pending, '\n') + 1
+	if n > 0 {
+		if begin := openPrivateKey(writer.pending[:n]); begin >= 0 {
+			n = begin
+		}
+	}
+	if len(writer.pending) > maxPendingBytes {
+		n = len(writer.pending)
+	}
+	if n == 0 {
+		return len(p), nil
+	}
+
+	if err := writer.write(n); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ためている内容をすべて書き出す
+func (writer *Writer) Flush() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if len(writer.pending) == 0 {
+		return nil
+	}
+	return writer.write(len(writer.pending))
+}
+
+// ためている内容の先頭から n バイトを、秘密の情報を取り除いて書き出す
+func (writer *Writer) write(n int) error {
+	s := string(writer.pending[:n])
+	writer.pending = slices.Delete(writer.pending, 0, n)
+	_, err := io.WriteString(writer.w, writer.redactor.String(s))
+	return err
+}
+
+// 終わりの行が書き込まれていない秘密鍵の始まりの位置を返す
+// なければ -1 を返す
+func openPrivateKey(b []byte) int {
+	begin := bytes.LastIndex(b, []byte("-----BEGIN "))
+	if begin < 0 || !bytes.Contains(b[begin:], []byte("PRIVATE KEY-----")) || bytes.Contains(b[begin:], []byte("-----END ")) {
+		return -1
+	}
+	return begin
+}
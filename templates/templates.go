@@ -0,0 +1,267 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// 存在しないキーを参照したときの挙動
+const (
+	MissingKeyDefault = "default" // <no value> を出力する
+	MissingKeyZero    = "zero"    // ゼロ値を出力する
+	MissingKeyError   = "error"   // エラーにする
+)
+
+// include の入れ子の深さの上限
+// 自身を include するテンプレートが無限に再帰しないように制限する
+const maxIncludeDepth = 32
+
+// テンプレートを構文解析する
+// 関数は Execute の際に作業ディレクトリに応じたものへ差し替えるので、ここでは名前だけ登録する。
+func Parse(name string, text string, missingKey string) (*template.Template, error) {
+	option, err := missingKeyOption(missingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New(name).Option(option).Funcs(FuncMap("", nil)).Parse(text)
+}
+
+// 共通のテンプレート（パーシャル）をまとめたテンプレートセットを構文解析する
+// パーシャルは、ParseWith で構文解析したテンプレートから {{template "NAME" .}} で展開できる。
+func ParseSet(partials map[string]string, missingKey string) (*template.Template, error) {
+	option, err := missingKeyOption(missingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	set := template.New("").Option(option).Funcs(FuncMap("", nil))
+	for name, text := range partials {
+		if _, err := set.New(name).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// テンプレートセットのパーシャルを参照できるテンプレートを構文解析する
+// テンプレートセットはクローンして用いるので、ほかのテンプレートの define の影響は受けない。
+func ParseWith(set *template.Template, name string, text string) (*template.Template, error) {
+	if set.Lookup(name) != nil {
+		return nil, errors.New("template name is already used by a shared template: " + name)
+	}
+
+	set, err := set.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	return set.New(name).Parse(text)
+}
+
+// 作業ディレクトリを指定してテンプレートを展開する
+// 作業ディレクトリが空文字列の場合は、カレントディレクトリを作業ディレクトリとする。
+func Execute(t *template.Template, workdir string, data any) (string, error) {
+	t, err := t.Clone()
+	if err != nil {
+		return "", err
+	}
+	t.Funcs(FuncMap(workdir, t))
+
+	text := &strings.Builder{}
+	if err := t.Execute(text, data); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// 存在しないキーを参照したときの挙動を、テンプレートのオプションに変換する
+func missingKeyOption(missingKey string) (string, error) {
+	switch missingKey {
+	case "", MissingKeyDefault:
+		return "missingkey=default", nil
+	case MissingKeyZero:
+		return "missingkey=zero", nil
+	case MissingKeyError:
+		return "missingkey=error", nil
+	default:
+		return "", errors.New("invalid missing_key: " + missingKey)
+	}
+}
+
+// テンプレートで利用できる関数
+//
+//	toJson VALUE            値を JSON 形式の文字列にする
+//	toYaml VALUE            値を YAML 形式の文字列にする
+//	indent N TEXT           各行の先頭に N 個の空白を付ける
+//	join SEP LIST           リストの要素を SEP で連結する
+//	default DEFAULT VALUE   値が空のとき DEFAULT を返す
+//	readFile PATH           作業ディレクトリ以下のファイルを読み込む
+//	fileExists PATH         作業ディレクトリ以下にファイルが存在するか判定する
+//	env NAME                環境変数の値を返す
+//	now                     現在時刻を返す
+//	include NAME VALUE      名前付きテンプレートを展開した文字列を返す（indent などに渡せる）
+func FuncMap(workdir string, t *template.Template) template.FuncMap {
+	includeDepth := 0 // 展開中の include の入れ子の深さ
+
+	return template.FuncMap{
+		"toJson": func(value any) (string, error) {
+			b, err := json.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		},
+
+		"toYaml": func(value any) (string, error) {
+			b, err := yaml.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(string(b), "\n"), nil
+		},
+
+		"indent": func(n int, text string) string {
+			padding := strings.Repeat(" ", n)
+			return padding + strings.ReplaceAll(text, "\n", "\n"+padding)
+		},
+
+		"join": func(sep string, list any) (string, error) {
+			value := reflect.ValueOf(list)
+			if !value.IsValid() {
+				return "", nil
+			}
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+				return "", fmt.Errorf("join: not a list: %T", list)
+			}
+			items := make([]string, 0, value.Len())
+			for i := 0; i < value.Len(); i++ {
+				items = append(items, fmt.Sprint(value.Index(i).Interface()))
+			}
+			return strings.Join(items, sep), nil
+		},
+
+		"default": func(defaultValue any, value any) any {
+			if isEmpty(value) {
+				return defaultValue
+			}
+			return value
+		},
+
+		"readFile": func(path string) (string, error) {
+			absPath, err := resolvePath(workdir, path)
+			if err != nil {
+				return "", err
+			}
+			b, err := os.ReadFile(absPath)
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		},
+
+		"fileExists": func(path string) (bool, error) {
+			absPath, err := resolvePath(workdir, path)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return false, nil
+				}
+				return false, err
+			}
+			_, err = os.Stat(absPath)
+			return err == nil, nil
+		},
+
+		"env": func(name string) string {
+			return os.Getenv(name)
+		},
+
+		"now": func() time.Time {
+			return time.Now()
+		},
+
+		"include": func(name string, data any) (string, error) {
+			if t == nil {
+				return "", errors.New("include: template is not available")
+			}
+			if includeDepth >= maxIncludeDepth {
+				return "", fmt.Errorf("include: exceeded maximum nesting depth %d: %s", maxIncludeDepth, name)
+			}
+			includeDepth++
+			defer func() { includeDepth-- }()
+
+			text := &strings.Builder{}
+			if err := t.ExecuteTemplate(text, name, data); err != nil {
+				return "", err
+			}
+			return text.String(), nil
+		},
+	}
+}
+
+// 値が空か判定する
+// nil、ゼロ値、長さ 0 の文字列・配列・マップを空とする
+func isEmpty(value any) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// 作業ディレクトリからの相対パス、もしくは絶対パスを作業ディレクトリ以下の絶対パスに解決する
+func resolvePath(workdir string, path string) (string, error) {
+	if workdir == "" {
+		workdir = "."
+	}
+	workdirAbsPath, err := filepath.Abs(workdir)
+	if err != nil {
+		return "", err
+	}
+
+	absPath := path
+	if !filepath.IsAbs(absPath) {
+		absPath = filepath.Join(workdirAbsPath, path)
+	}
+	absPath = filepath.Clean(absPath)
+
+	if !isUnder(workdirAbsPath, absPath) {
+		return "", errors.New("path is outside of the working directory: " + path)
+	}
+
+	// シンボリックリンクで作業ディレクトリの外を指していないか
+	realWorkdir, err := filepath.EvalSymlinks(workdirAbsPath)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", err
+	}
+	if !isUnder(realWorkdir, realPath) {
+		return "", errors.New("path is outside of the working directory: " + path)
+	}
+
+	return absPath, nil
+}
+
+// path が dir 以下にあるか判定する
+func isUnder(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
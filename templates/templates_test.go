@@ -0,0 +1,167 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		missingKey string
+		data       any
+		want       string
+		wantErr    bool
+	}{
+		{name: "value", text: "{{ .a }}", data: map[string]any{"a": "x"}, want: "x"},
+		{name: "missing default", text: "{{ .a }}", data: map[string]any{}, want: "<no value>"},
+		{name: "missing error", text: "{{ .a }}", missingKey: MissingKeyError, data: map[string]any{}, wantErr: true},
+		{name: "toJson", text: "{{ toJson .a }}", data: map[string]any{"a": []any{1, "x"}}, want: `[1,"x"]`},
+		{name: "toYaml", text: "{{ toYaml .a }}", data: map[string]any{"a": map[string]any{"b": 1}}, want: "b: 1"},
+		{name: "indent", text: "{{ indent 2 .a }}", data: map[string]any{"a": "x\ny"}, want: "  x\n  y"},
+		{name: "join", text: "{{ join \", \" .a }}", data: map[string]any{"a": []any{1, 2}}, want: "1, 2"},
+		{name: "join not a list", text: "{{ join \", \" .a }}", data: map[string]any{"a": 1}, wantErr: true},
+		{name: "default empty", text: "{{ default \"d\" .a }}", data: map[string]any{"a": ""}, want: "d"},
+		{name: "default value", text: "{{ default \"d\" .a }}", data: map[string]any{"a": "x"}, want: "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse("test", tt.text, tt.missingKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Execute(tmpl, "", tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Execute(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalidMissingKey(t *testing.T) {
+	if _, err := Parse("test", "", "ignore"); err == nil {
+		t.Error("Parse() with invalid missing_key should fail")
+	}
+	if _, err := ParseSet(map[string]string{}, "ignore"); err == nil {
+		t.Error("ParseSet() with invalid missing_key should fail")
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	partials := map[string]string{
+		"greeting":  "Hello, {{ .name }}!",
+		"list":      "- {{ .name }}\n- {{ .name }}",
+		"recursive": `{{ include "recursive" . }}`,
+		"nested":    `[{{ include "greeting" . }}]`,
+	}
+	set, err := ParseSet(partials, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+		errText string
+	}{
+		{name: "template", text: `{{ template "greeting" . }}`, want: "Hello, ace!"},
+		{name: "include", text: `{{ include "greeting" . | printf "%q" }}`, want: `"Hello, ace!"`},
+		{name: "include with indent", text: `{{ include "list" . | indent 2 }}`, want: "  - ace\n  - ace"},
+		{name: "nested include", text: `{{ include "nested" . }}`, want: "[Hello, ace!]"},
+		{name: "undefined", text: `{{ include "missing" . }}`, wantErr: true},
+		{name: "recursive include", text: `{{ include "recursive" . }}`, wantErr: true, errText: "exceeded maximum nesting depth"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseWith(set, "test", tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Execute(tmpl, "", map[string]any{"name": "ace"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("Execute(%q) error = %v, want %q", tt.text, err, tt.errText)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Execute(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWithSharedName(t *testing.T) {
+	set, err := ParseSet(map[string]string{"shared": "x"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseWith(set, "shared", "y"); err == nil {
+		t.Error("ParseWith() with the name of a shared template should fail")
+	}
+
+	// 別のテンプレートの define は、テンプレートセットに影響しない
+	if _, err := ParseWith(set, "a", `{{ define "local" }}a{{ end }}`); err != nil {
+		t.Fatal(err)
+	}
+	if set.Lookup("local") != nil {
+		t.Error("ParseWith() should not modify the template set")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	workdir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workdir, "a.txt"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(workdir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "relative", text: `{{ readFile "a.txt" }}`, want: "content"},
+		{name: "absolute", text: `{{ readFile "` + filepath.Join(workdir, "a.txt") + `" }}`, want: "content"},
+		{name: "parent", text: `{{ readFile "../a.txt" }}`, wantErr: true},
+		{name: "outside", text: `{{ readFile "` + filepath.Join(outside, "secret.txt") + `" }}`, wantErr: true},
+		{name: "symlink outside", text: `{{ readFile "link.txt" }}`, wantErr: true},
+		{name: "not exist", text: `{{ readFile "missing.txt" }}`, wantErr: true},
+		{name: "fileExists", text: `{{ fileExists "a.txt" }}`, want: "true"},
+		{name: "fileExists missing", text: `{{ fileExists "missing.txt" }}`, want: "false"},
+		{name: "fileExists outside", text: `{{ fileExists "../a.txt" }}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse("test", tt.text, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Execute(tmpl, workdir, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Execute(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
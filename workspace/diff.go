@@ -0,0 +1,346 @@
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// 変更の種類
+const (
+	changeAdded    = "added"
+	changeModified = "modified"
+	changeDeleted  = "deleted"
+)
+
+// ファイルの変更
+type change struct {
+	path string // 作業ディレクトリからの相対パス
+	kind string // added, modified, deleted
+}
+
+// 差分に含めないディレクトリ
+var ignoredDirs = []string{".git", ".ace"}
+
+// ディレクトリ以下の通常のファイルとシンボリックリンクの状態を、相対パスをキーとして返す
+// 隔離したときの状態を記録して、その後の変更を調べるために用いる
+func snapshotDir(dir string) (map[string]string, error) {
+	paths, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]string, len(paths))
+	for path := range paths {
+		state, err := fileState(filepath.Join(dir, path))
+		if err != nil {
+			return nil, err
+		}
+		snapshot[path] = state
+	}
+	return snapshot, nil
+}
+
+// 記録したときの状態とディレクトリを比較して、変更されたファイルをパスの昇順で返す
+func compareSnapshot(snapshot map[string]string, dir string) ([]change, error) {
+	paths, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []change{}
+	for path := range paths {
+		before, ok := snapshot[path]
+		if !ok {
+			changes = append(changes, change{path: path, kind: changeAdded})
+			continue
+		}
+		after, err := fileState(filepath.Join(dir, path))
+		if err != nil {
+			return nil, err
+		}
+		if after != before {
+			changes = append(changes, change{path: path, kind: changeModified})
+		}
+	}
+	for path := range snapshot {
+		if _, ok := paths[path]; !ok {
+			changes = append(changes, change{path: path, kind: changeDeleted})
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b change) int { return strings.Compare(a.path, b.path) })
+	return changes, nil
+}
+
+// ディレクトリ以下の通常のファイルとシンボリックリンクの相対パスを返す
+// ディレクトリへのシンボリックリンクはたどらない
+func listFiles(dir string) (map[string]struct{}, error) {
+	files := map[string]struct{}{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && path != dir && slices.Contains(ignoredDirs, entry.Name()) {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() && entry.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = struct{}{}
+		return nil
+	})
+	return files, err
+}
+
+// ファイルのパーミッションと内容のハッシュを、状態を表す文字列として返す
+// シンボリックリンクはリンク先をたどらずに、リンク先のパスを状態とする
+// ファイルが存在しなければ空文字列を返す
+func fileState(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		return "symlink " + link, nil
+	}
+	if !info.Mode().IsRegular() {
+		return info.Mode().Type().String(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%o %x", info.Mode().Perm(), hash.Sum(nil)), nil
+}
+
+// ファイルの変更を unified diff 形式で書き出す
+func writeFileDiff(w io.Writer, before string, after string, change change) error {
+	var beforeData, afterData []byte
+	var err error
+	if change.kind != changeAdded {
+		// 隔離してから元の作業ディレクトリで削除されたファイルは、空のファイルとの差分とする
+		if beforeData, err = readEntry(filepath.Join(before, change.path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if change.kind != changeDeleted {
+		if afterData, err = readEntry(filepath.Join(after, change.path)); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.ToSlash(change.path)
+	fromName, toName := "a/"+path, "b/"+path
+	switch change.kind {
+	case changeAdded:
+		fromName = "/dev/null"
+	case changeDeleted:
+		toName = "/dev/null"
+	}
+
+	fmt.Fprintf(w, "diff -u a/%s b/%s\n", path, path)
+	if bytes.IndexByte(beforeData, 0) >= 0 || bytes.IndexByte(afterData, 0) >= 0 {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", fromName, toName)
+		return nil
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range unifiedHunks(splitLines(beforeData), splitLines(afterData), 3) {
+		if _, err := io.WriteString(w, hunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ファイルの内容を返す
+// シンボリックリンクは、git と同じくリンク先のパスを内容とする
+func readEntry(path string) ([]byte, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(link), nil
+	}
+	return os.ReadFile(path)
+}
+
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// 編集操作
+type edit struct {
+	op   byte // ' ', '-', '+'
+	line string
+}
+
+// Myers の差分アルゴリズムで、a を b にする編集操作の列を返す
+func diffLines(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+2)
+	trace := [][]int{}
+
+	// 最短の編集距離に達するまで、各編集距離で到達できる位置を記録する
+	found := false
+	for d := 0; d <= maxD && !found; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	trace = append(trace, slices.Clone(v))
+
+	// 記録をたどって編集操作の列を復元する
+	edits := []edit{}
+	x, y := n, m
+	for d := len(trace) - 2; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{op: ' ', line: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				edits = append(edits, edit{op: '+', line: b[y]})
+			} else {
+				x--
+				edits = append(edits, edit{op: '-', line: a[x]})
+			}
+		}
+	}
+	slices.Reverse(edits)
+	return edits
+}
+
+// 編集操作の列を、前後 context 行を含む unified diff のハンクにまとめる
+func unifiedHunks(a []string, b []string, context int) []string {
+	edits := diffLines(a, b)
+
+	hunks := []string{}
+	for i := 0; i < len(edits); {
+		// 次の変更を探す
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// 変更の前後 context 行を含む範囲を決める
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end = min(end+context, len(edits))
+
+		// ハンクの先頭行の位置を数える
+		aStart, bStart := 0, 0
+		for _, e := range edits[:start] {
+			if e.op != '+' {
+				aStart++
+			}
+			if e.op != '-' {
+				bStart++
+			}
+		}
+		aLen, bLen := 0, 0
+		body := &strings.Builder{}
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				aLen++
+			}
+			if e.op != '-' {
+				bLen++
+			}
+			body.WriteByte(e.op)
+			body.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				body.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		hunks = append(hunks, fmt.Sprintf("@@ -%s +%s @@\n%s", hunkRange(aStart, aLen), hunkRange(bStart, bLen), body.String()))
+
+		i = end
+	}
+	return hunks
+}
+
+// ハンクの範囲の表記を返す
+func hunkRange(start int, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
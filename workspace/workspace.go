@@ -0,0 +1,393 @@
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 作業ディレクトリを隔離する方法
+// overlay のようなコピーオンライトのディレクトリには対応していない
+const (
+	ModeTmpCopy     = "tmp-copy"     // 一時ディレクトリに作業ディレクトリをコピーする
+	ModeGitWorktree = "git-worktree" // git worktree で HEAD をチェックアウトし、コミットしていない変更とファイルを取り込んだ一時ディレクトリを作る
+)
+
+// 実行を終えたときに、隔離した作業ディレクトリでの変更をどうするか
+const (
+	ChangesApply   = "apply"   // 元の作業ディレクトリに反映して、隔離した作業ディレクトリを削除する
+	ChangesDiscard = "discard" // 反映せずに、隔離した作業ディレクトリを削除する
+	ChangesKeep    = "keep"    // 反映せずに、隔離した作業ディレクトリを残す
+)
+
+// 隔離した作業ディレクトリ
+type Workspace struct {
+	Mode    string // tmp-copy, git-worktree
+	Workdir string // 元の作業ディレクトリの絶対パス
+	Dir     string // 隔離した作業ディレクトリの絶対パス
+
+	root     string            // 一時ディレクトリ、もしくは worktree のルート
+	repoRoot string            // git-worktree の場合、元のリポジトリのルート
+	baseTree string            // git-worktree の場合、隔離したときの worktree の状態を表す tree オブジェクト
+	snapshot map[string]string // tmp-copy の場合、隔離したときのファイルの状態
+}
+
+// 作業ディレクトリを隔離する
+// paths には、隔離した作業ディレクトリに必ず含めるファイル（ファイルの入力など）を作業ディレクトリからの相対パスで指定する。
+// git-worktree では、git で無視されるファイルは paths に指定したもののみ取り込む。
+func Create(mode string, workdir string, paths ...string) (*Workspace, error) {
+	workdirAbsPath, err := filepath.Abs(workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case ModeTmpCopy:
+		root, err := os.MkdirTemp("", "ace-workspace-")
+		if err != nil {
+			return nil, err
+		}
+		if err := copyDir(workdirAbsPath, root); err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+
+		// 変更を反映するときに元の作業ディレクトリでの変更と衝突しないか確かめられるように、ファイルの状態を記録する
+		snapshot, err := snapshotDir(root)
+		if err != nil {
+			os.RemoveAll(root)
+			return nil, err
+		}
+		return &Workspace{Mode: mode, Workdir: workdirAbsPath, Dir: root, root: root, snapshot: snapshot}, nil
+
+	case ModeGitWorktree:
+		repoRoot, err := git(workdirAbsPath, nil, "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, fmt.Errorf("working directory is not in a git repository: %w", err)
+		}
+		repoRoot = strings.TrimSpace(repoRoot)
+		rel, err := filepath.Rel(repoRoot, workdirAbsPath)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpDir, err := os.MkdirTemp("", "ace-workspace-")
+		if err != nil {
+			return nil, err
+		}
+		root := filepath.Join(tmpDir, "worktree")
+		if _, err := git(repoRoot, nil, "worktree", "add", "--detach", root, "HEAD"); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, err
+		}
+		workspace := &Workspace{Mode: mode, Workdir: workdirAbsPath, Dir: filepath.Join(root, rel), root: root, repoRoot: repoRoot}
+
+		// コミットしていない変更とファイル、paths に指定したファイルを取り込み、
+		// その状態を変更の差分の基準とする
+		if err := workspace.importUncommitted(paths); err != nil {
+			workspace.Remove()
+			return nil, err
+		}
+		if _, err := git(root, nil, "add", "-A"); err != nil {
+			workspace.Remove()
+			return nil, err
+		}
+		baseTree, err := git(root, nil, "write-tree")
+		if err != nil {
+			workspace.Remove()
+			return nil, err
+		}
+		workspace.baseTree = strings.TrimSpace(baseTree)
+		return workspace, nil
+
+	default:
+		return nil, fmt.Errorf("unknown isolation mode: %s (available: %s, %s)", mode, ModeTmpCopy, ModeGitWorktree)
+	}
+}
+
+// 元のリポジトリの、HEAD からの変更と追跡されていないファイルを worktree に取り込む
+// paths に指定したファイルは、git で無視されるファイルであっても取り込む
+func (workspace *Workspace) importUncommitted(paths []string) error {
+	modified, err := git(workspace.repoRoot, nil, "diff", "--name-only", "-z", "HEAD")
+	if err != nil {
+		return err
+	}
+	untracked, err := git(workspace.repoRoot, nil, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return err
+	}
+	for _, rel := range strings.Split(modified+untracked, "\x00") {
+		if rel == "" {
+			continue
+		}
+		if err := copyEntry(filepath.Join(workspace.repoRoot, rel), filepath.Join(workspace.root, rel)); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		if filepath.IsAbs(path) {
+			continue
+		}
+		src := filepath.Join(workspace.Workdir, path)
+		dst := filepath.Join(workspace.Dir, path)
+		if !isUnder(workspace.Workdir, src) {
+			continue // 作業ディレクトリの外のファイルは取り込まない
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			continue
+		}
+		if _, err := os.Lstat(src); err != nil {
+			continue // 存在しないファイルは、実行前の確認でエラーとなる
+		}
+		if err := copyEntry(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 隔離した作業ディレクトリでの変更を unified diff 形式で返す
+// 隔離したときの状態からの差分を返す
+func (workspace *Workspace) Diff() (string, error) {
+	switch workspace.Mode {
+	case ModeGitWorktree:
+		rel, err := filepath.Rel(workspace.root, workspace.Dir)
+		if err != nil {
+			return "", err
+		}
+		if _, err := git(workspace.root, nil, "add", "-A", "--", rel); err != nil {
+			return "", err
+		}
+		return git(workspace.root, nil, "diff", "--cached", "--binary", workspace.baseTree, "--", rel)
+
+	default:
+		changes, err := compareSnapshot(workspace.snapshot, workspace.Dir)
+		if err != nil {
+			return "", err
+		}
+		diff := &strings.Builder{}
+		for _, change := range changes {
+			if err := writeFileDiff(diff, workspace.Workdir, workspace.Dir, change); err != nil {
+				return "", err
+			}
+		}
+		return diff.String(), nil
+	}
+}
+
+// 隔離した作業ディレクトリでの変更を、元の作業ディレクトリに反映する
+// 隔離してから元の作業ディレクトリで変更されたファイルと衝突する場合は、何も反映せずにエラーとする
+func (workspace *Workspace) Apply() error {
+	switch workspace.Mode {
+	case ModeGitWorktree:
+		patch, err := workspace.Diff()
+		if err != nil {
+			return err
+		}
+		if patch == "" {
+			return nil
+		}
+		_, err = git(workspace.repoRoot, strings.NewReader(patch), "apply", "--binary", "-")
+		return err
+
+	default:
+		changes, err := compareSnapshot(workspace.snapshot, workspace.Dir)
+		if err != nil {
+			return err
+		}
+
+		conflicts := []string{}
+		for _, change := range changes {
+			state, err := fileState(filepath.Join(workspace.Workdir, change.path))
+			if err != nil {
+				return err
+			}
+			if state != workspace.snapshot[change.path] {
+				conflicts = append(conflicts, filepath.ToSlash(change.path))
+			}
+		}
+		if len(conflicts) > 0 {
+			return errors.New("changes conflict with files modified in the working directory: " + strings.Join(conflicts, ", "))
+		}
+
+		for _, change := range changes {
+			dst := filepath.Join(workspace.Workdir, change.path)
+			if change.kind == changeDeleted {
+				if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+				continue
+			}
+			if err := copyEntry(filepath.Join(workspace.Dir, change.path), dst); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// 隔離した作業ディレクトリを削除する
+func (workspace *Workspace) Remove() error {
+	switch workspace.Mode {
+	case ModeGitWorktree:
+		if _, err := git(workspace.repoRoot, nil, "worktree", "remove", "--force", workspace.root); err != nil {
+			return err
+		}
+		return os.RemoveAll(filepath.Dir(workspace.root))
+
+	default:
+		return os.RemoveAll(workspace.root)
+	}
+}
+
+// 変更の内容に応じて、変更を反映・破棄する
+func (workspace *Workspace) Finish(changes string) error {
+	switch changes {
+	case ChangesApply:
+		if err := workspace.Apply(); err != nil {
+			return err
+		}
+		return workspace.Remove()
+	case ChangesDiscard:
+		return workspace.Remove()
+	case ChangesKeep:
+		return nil
+	default:
+		return errors.New("unknown changes: " + changes)
+	}
+}
+
+// git コマンドを実行して標準出力を返す
+func git(dir string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdin = stdin
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// ディレクトリを再帰的にコピーする
+func copyDir(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case entry.IsDir():
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(target, info.Mode().Perm())
+
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+
+		case entry.Type().IsRegular():
+			return copyFile(path, target)
+
+		default:
+			return nil // ソケットなどはコピーしない
+		}
+	})
+}
+
+// ファイルもしくはシンボリックリンクをコピーする
+// コピー元が存在しなければ、コピー先を削除する
+func copyEntry(src string, dst string) error {
+	info, err := os.Lstat(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.RemoveAll(dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+
+	case info.Mode().IsRegular():
+		// シンボリックリンクを通常のファイルに置き換える場合は、リンク先に書き込まないように先に削除する
+		if dstInfo, err := os.Lstat(dst); err == nil && dstInfo.Mode()&fs.ModeSymlink != 0 {
+			if err := os.Remove(dst); err != nil {
+				return err
+			}
+		}
+		return copyFile(src, dst)
+
+	default:
+		return nil // サブモジュールなどはコピーしない
+	}
+}
+
+// path が dir 以下にあるか判定する
+func isUnder(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ファイルをパーミッションとともにコピーする
+func copyFile(src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err1 := w.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	// 既存のファイルに上書きした場合も、パーミッションをそろえる
+	return os.Chmod(dst, info.Mode().Perm())
+}
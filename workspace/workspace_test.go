@@ -0,0 +1,352 @@
+package workspace
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// テスト用のファイルを書き込む
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ファイルの内容を返す。存在しなければ空文字列を返す
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// テスト用の git リポジトリを作る
+func initRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "tracked.txt"), "tracked\n")
+	writeFile(t, filepath.Join(dir, "dirty.txt"), "committed\n")
+	writeFile(t, filepath.Join(dir, "removed.txt"), "removed\n")
+	writeFile(t, filepath.Join(dir, ".gitignore"), "ignored/\n")
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		if _, err := git(dir, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// コミットしていない変更とファイル
+	writeFile(t, filepath.Join(dir, "dirty.txt"), "uncommitted\n")
+	writeFile(t, filepath.Join(dir, "untracked.txt"), "untracked\n")
+	writeFile(t, filepath.Join(dir, "ignored", "input.txt"), "input\n")
+	writeFile(t, filepath.Join(dir, "ignored", "other.txt"), "other\n")
+	if err := os.Remove(filepath.Join(dir, "removed.txt")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		wantFiles   map[string]string
+		wantMissing []string
+	}{
+		{
+			name: "tmp-copy",
+			mode: ModeTmpCopy,
+			wantFiles: map[string]string{
+				"dirty.txt":         "uncommitted\n",
+				"untracked.txt":     "untracked\n",
+				"ignored/input.txt": "input\n",
+				"ignored/other.txt": "other\n",
+			},
+			wantMissing: []string{"removed.txt"},
+		},
+		{
+			name: "git-worktree",
+			mode: ModeGitWorktree,
+			wantFiles: map[string]string{
+				"dirty.txt":         "uncommitted\n",
+				"untracked.txt":     "untracked\n",
+				"ignored/input.txt": "input\n",
+			},
+			wantMissing: []string{"removed.txt", "ignored/other.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := initRepo(t)
+			workspace, err := Create(tt.mode, dir, "ignored/input.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer workspace.Remove()
+
+			for path, want := range tt.wantFiles {
+				if got := readFile(t, filepath.Join(workspace.Dir, path)); got != want {
+					t.Errorf("%s = %q, want %q", path, got, want)
+				}
+			}
+			for _, path := range tt.wantMissing {
+				if _, err := os.Lstat(filepath.Join(workspace.Dir, path)); !os.IsNotExist(err) {
+					t.Errorf("%s should not exist in the workspace", path)
+				}
+			}
+
+			// 隔離した直後は変更がない
+			diff, err := workspace.Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff != "" {
+				t.Errorf("Diff() just after Create() = %q, want empty", diff)
+			}
+		})
+	}
+}
+
+func TestCreateUnknownMode(t *testing.T) {
+	if _, err := Create("overlay", t.TempDir()); err == nil {
+		t.Error("Create() with an unknown mode should fail")
+	}
+}
+
+func TestDiffApply(t *testing.T) {
+	for _, mode := range []string{ModeTmpCopy, ModeGitWorktree} {
+		t.Run(mode, func(t *testing.T) {
+			dir := initRepo(t)
+			workspace, err := Create(mode, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer workspace.Remove()
+
+			// エージェントによる変更
+			writeFile(t, filepath.Join(workspace.Dir, "dirty.txt"), "changed\n")
+			writeFile(t, filepath.Join(workspace.Dir, "added.txt"), "added\n")
+			if err := os.Remove(filepath.Join(workspace.Dir, "tracked.txt")); err != nil {
+				t.Fatal(err)
+			}
+
+			// 元の作業ディレクトリは変わらない
+			if got := readFile(t, filepath.Join(dir, "dirty.txt")); got != "uncommitted\n" {
+				t.Errorf("dirty.txt in the working directory = %q before Apply()", got)
+			}
+
+			diff, err := workspace.Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"-uncommitted", "+changed", "+added", "-tracked"} {
+				if !strings.Contains(diff, want) {
+					t.Errorf("Diff() does not contain %q:\n%s", want, diff)
+				}
+			}
+			for _, unwanted := range []string{"committed\n-", "untracked", "removed"} {
+				if strings.Contains(diff, unwanted) {
+					t.Errorf("Diff() contains changes before isolation %q:\n%s", unwanted, diff)
+				}
+			}
+
+			if err := workspace.Apply(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, filepath.Join(dir, "dirty.txt")); got != "changed\n" {
+				t.Errorf("dirty.txt = %q, want %q", got, "changed\n")
+			}
+			if got := readFile(t, filepath.Join(dir, "added.txt")); got != "added\n" {
+				t.Errorf("added.txt = %q, want %q", got, "added\n")
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "tracked.txt")); !os.IsNotExist(err) {
+				t.Error("tracked.txt should be deleted")
+			}
+			if got := readFile(t, filepath.Join(dir, "untracked.txt")); got != "untracked\n" {
+				t.Errorf("untracked.txt = %q, want %q", got, "untracked\n")
+			}
+		})
+	}
+}
+
+func TestApplySymlinks(t *testing.T) {
+	for _, mode := range []string{ModeTmpCopy, ModeGitWorktree} {
+		t.Run(mode, func(t *testing.T) {
+			dir := initRepo(t)
+			writeFile(t, filepath.Join(dir, "target", "kept.txt"), "kept\n")
+			if err := os.Symlink("tracked.txt", filepath.Join(dir, "link.txt")); err != nil {
+				t.Fatal(err)
+			}
+			workspace, err := Create(mode, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer workspace.Remove()
+
+			// エージェントがシンボリックリンクを作る
+			if err := os.Symlink("target/kept.txt", filepath.Join(workspace.Dir, "added-link.txt")); err != nil {
+				t.Fatal(err)
+			}
+			// ファイルをシンボリックリンクに置き換える
+			if err := os.Remove(filepath.Join(workspace.Dir, "untracked.txt")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("target/kept.txt", filepath.Join(workspace.Dir, "untracked.txt")); err != nil {
+				t.Fatal(err)
+			}
+			// シンボリックリンクを通常のファイルに置き換える
+			if err := os.Remove(filepath.Join(workspace.Dir, "link.txt")); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, filepath.Join(workspace.Dir, "link.txt"), "replaced\n")
+
+			diff, err := workspace.Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"added-link.txt", "untracked.txt", "link.txt", "+target/kept.txt"} {
+				if !strings.Contains(diff, want) {
+					t.Errorf("Diff() does not contain %q:\n%s", want, diff)
+				}
+			}
+
+			if err := workspace.Apply(); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{"added-link.txt", "untracked.txt"} {
+				if link, err := os.Readlink(filepath.Join(dir, path)); err != nil || link != "target/kept.txt" {
+					t.Errorf("%s links to %q (error = %v), want %q", path, link, err, "target/kept.txt")
+				}
+			}
+			if info, err := os.Lstat(filepath.Join(dir, "link.txt")); err != nil || !info.Mode().IsRegular() {
+				t.Errorf("link.txt should be a regular file: %v", err)
+			}
+			if got := readFile(t, filepath.Join(dir, "link.txt")); got != "replaced\n" {
+				t.Errorf("link.txt = %q, want %q", got, "replaced\n")
+			}
+			// リンク先は変わらない
+			if got := readFile(t, filepath.Join(dir, "tracked.txt")); got != "tracked\n" {
+				t.Errorf("tracked.txt = %q, want %q", got, "tracked\n")
+			}
+			if got := readFile(t, filepath.Join(dir, "target", "kept.txt")); got != "kept\n" {
+				t.Errorf("target/kept.txt = %q, want %q", got, "kept\n")
+			}
+		})
+	}
+}
+
+func TestApplyDirReplacedBySymlink(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "target", "a.txt"), "target\n")
+	writeFile(t, filepath.Join(dir, "dir", "a.txt"), "dir\n")
+	workspace, err := Create(ModeTmpCopy, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer workspace.Remove()
+
+	// ディレクトリをシンボリックリンクに置き換える
+	if err := os.RemoveAll(filepath.Join(workspace.Dir, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target", filepath.Join(workspace.Dir, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 元の作業ディレクトリのディレクトリを消さないように、衝突として何も反映しない
+	if err := workspace.Apply(); err == nil {
+		t.Fatal("Apply() should fail when a directory is replaced by a symlink")
+	}
+	if got := readFile(t, filepath.Join(dir, "dir", "a.txt")); got != "dir\n" {
+		t.Errorf("dir/a.txt = %q, want %q", got, "dir\n")
+	}
+	if got := readFile(t, filepath.Join(dir, "target", "a.txt")); got != "target\n" {
+		t.Errorf("target/a.txt = %q, want %q", got, "target\n")
+	}
+}
+
+func TestApplyConflict(t *testing.T) {
+	for _, mode := range []string{ModeTmpCopy, ModeGitWorktree} {
+		t.Run(mode, func(t *testing.T) {
+			dir := initRepo(t)
+			workspace, err := Create(mode, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer workspace.Remove()
+
+			writeFile(t, filepath.Join(workspace.Dir, "dirty.txt"), "agent\n")
+			writeFile(t, filepath.Join(workspace.Dir, "added.txt"), "added\n")
+
+			// 隔離してから元の作業ディレクトリで変更された
+			writeFile(t, filepath.Join(dir, "dirty.txt"), "user\n")
+
+			if err := workspace.Apply(); err == nil {
+				t.Fatal("Apply() should fail on conflict")
+			}
+
+			// 何も反映しない
+			if got := readFile(t, filepath.Join(dir, "dirty.txt")); got != "user\n" {
+				t.Errorf("dirty.txt = %q, want %q", got, "user\n")
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "added.txt")); !os.IsNotExist(err) {
+				t.Error("added.txt should not be applied on conflict")
+			}
+		})
+	}
+}
+
+func TestFinish(t *testing.T) {
+	tests := []struct {
+		changes     string
+		wantApplied bool
+		wantKept    bool
+		wantErr     bool
+	}{
+		{changes: ChangesApply, wantApplied: true},
+		{changes: ChangesDiscard},
+		{changes: ChangesKeep, wantKept: true},
+		{changes: "unknown", wantKept: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.changes, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "a.txt"), "a\n")
+			workspace, err := Create(ModeTmpCopy, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer workspace.Remove()
+			writeFile(t, filepath.Join(workspace.Dir, "a.txt"), "b\n")
+
+			err = workspace.Finish(tt.changes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Finish(%q) error = %v, wantErr %v", tt.changes, err, tt.wantErr)
+			}
+			if applied := readFile(t, filepath.Join(dir, "a.txt")) == "b\n"; applied != tt.wantApplied {
+				t.Errorf("applied = %v, want %v", applied, tt.wantApplied)
+			}
+			if _, err := os.Stat(workspace.Dir); (err == nil) != tt.wantKept {
+				t.Errorf("kept = %v, want %v", err == nil, tt.wantKept)
+			}
+		})
+	}
+}